@@ -1,13 +1,19 @@
 package vcsinfo
 
 import (
+	"context"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // BzrProbe is a probe for extracting information out of an Bazaar repository.
 type BzrProbe struct{}
 
+// bzrDateLayout is the layout of the dates output by bzr version-info.
+const bzrDateLayout = "2006-01-02 15:04:05.999999999 -0700"
+
 // Name returns the human-facing name of the probe.
 func (probe BzrProbe) Name() string {
 	return "bzr"
@@ -16,7 +22,7 @@ func (probe BzrProbe) Name() string {
 // DefaultFormat returns the default format string to use for Bazaar
 // repositories.
 func (probe BzrProbe) DefaultFormat() string {
-	return "%n[%b%m%u%t]"
+	return "%n[%b%c%m%u%t]"
 }
 
 // IsAvailable indicates whether or not this probe has the tools/environment
@@ -31,29 +37,44 @@ func (probe BzrProbe) IsRepositoryRoot(path string) (bool, error) {
 	return dirExists(filepath.Join(path, ".bzr/branch"))
 }
 
-func (probe BzrProbe) extractStatus(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "bzr", "status")
+func (probe BzrProbe) extractStatus(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "bzr", "status")
 	if err != nil {
 		return err
 	}
 
+	// Each section header is followed by the files it applies to, indented.
+	var count *int
 	for _, line := range out {
+		if strings.HasPrefix(line, " ") {
+			if count != nil {
+				*count++
+			}
+			continue
+		}
+
+		count = nil
 		if strings.HasPrefix(line, "added") ||
 			strings.HasPrefix(line, "removed") ||
 			strings.HasPrefix(line, "renamed") ||
 			strings.HasPrefix(line, "kind changed") ||
 			strings.HasPrefix(line, "modified") {
 			info.HasModified = true
+			count = &info.ModifiedCount
 		} else if strings.HasPrefix(line, "unknown") {
 			info.HasNew = true
+			count = &info.UntrackedCount
+		} else if strings.HasPrefix(line, "conflicts") {
+			info.HasConflicts = true
+			count = &info.ConflictCount
 		}
 	}
 
 	return nil
 }
 
-func (probe BzrProbe) extractCommitInfo(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "bzr", "version-info")
+func (probe BzrProbe) extractCommitInfo(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "bzr", "version-info")
 	if err != nil {
 		return err
 	}
@@ -73,17 +94,125 @@ func (probe BzrProbe) extractCommitInfo(path string, info *VcsInfo) error {
 		} else if parts[0] == "branch-nick" {
 			info.Branch = parts[1]
 
+		} else if parts[0] == "date" {
+			commitTime, err := time.Parse(bzrDateLayout, parts[1])
+			if err != nil {
+				return err
+			}
+			info.CommitTime = commitTime
+
 		}
 	}
 
 	return nil
 }
 
-func (probe BzrProbe) extractShelved(path string, info *VcsInfo) error {
-	_, err := runCommand(path, "bzr", "shelve", "--list")
+func (probe BzrProbe) extractRemote(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "bzr", "info")
 	if err != nil {
-		exitCode := getExitCode(err)
-		info.HasStashed = exitCode > 0
+		return err
+	}
+
+	for _, line := range out {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "parent branch: ") {
+			setRemote(info, "parent", line[15:])
+			break
+		}
+	}
+
+	return nil
+}
+
+func (probe BzrProbe) extractAuthor(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "bzr", "log", "--limit", "1")
+	if err != nil {
+		return err
+	}
+
+	committer, author := "", ""
+	for idx, line := range out {
+		if strings.HasPrefix(line, "committer: ") {
+			committer = line[11:]
+
+		} else if strings.HasPrefix(line, "author: ") {
+			author = line[8:]
+
+		} else if line == "message:" {
+			info.CommitSubject = firstLine(strings.Join(out[idx+1:], "\n"))
+			break
+		}
+	}
+
+	// The author is only listed if it's someone other than the committer.
+	if author == "" {
+		author = committer
+	}
+	if author != "" {
+		info.CommitAuthor, info.CommitEmail = splitAuthor(author)
+	}
+
+	return nil
+}
+
+func (probe BzrProbe) extractTags(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "bzr", "revno")
+	if err != nil || len(out) == 0 {
+		return err
+	}
+	current, err := strconv.Atoi(strings.TrimSpace(out[0]))
+	if err != nil {
+		return err
+	}
+
+	out, err = runCommand(ctx, path, "bzr", "tags")
+	if err != nil {
+		return err
+	}
+
+	nearestRevno := -1
+	for _, line := range out {
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			continue
+		}
+
+		// Tags on revisions that aren't on the mainline of this branch have
+		// dotted (or no) revision numbers; we skip those.
+		revno, err := strconv.Atoi(parts[1])
+		if err != nil || revno > current {
+			continue
+		}
+
+		if revno == current {
+			info.Tags = append(info.Tags, parts[0])
+		}
+		if revno > nearestRevno {
+			nearestRevno = revno
+			info.NearestTag = parts[0]
+			info.NearestTagDistance = current - revno
+		}
+	}
+
+	return nil
+}
+
+func (probe BzrProbe) extractShelved(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "bzr", "shelve", "--list")
+	if err != nil {
+		if getExitCode(err) != 1 {
+			return err
+		}
+		// An exit code of 1 means there are shelves to list.
+		info.HasStashed = true
+	}
+
+	// Each shelf is listed as "ID: MESSAGE".
+	for _, line := range out {
+		line = strings.TrimSpace(line)
+		if line != "" && line[0] >= '0' && line[0] <= '9' {
+			info.StashCount++
+		}
 	}
 	return nil
 }
@@ -91,6 +220,21 @@ func (probe BzrProbe) extractShelved(path string, info *VcsInfo) error {
 // GatherInfo extracts and returns VCS information for the Bazaar repository at
 // the specified path.
 func (probe BzrProbe) GatherInfo(path string) (VcsInfo, []error) {
+	return probe.GatherInfoContext(context.Background(), path)
+}
+
+// GatherInfoContext extracts and returns VCS information for the Bazaar
+// repository at the specified path. If the context expires before all
+// information could be gathered, the fields collected so far are returned.
+func (probe BzrProbe) GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error) {
+	return probe.GatherInfoFields(ctx, path, FieldAll)
+}
+
+// GatherInfoFields extracts and returns the requested VCS information for the
+// Bazaar repository at the specified path, skipping the work needed for the
+// other fields. If the context expires before all information could be
+// gathered, the fields collected so far are returned.
+func (probe BzrProbe) GatherInfoFields(ctx context.Context, path string, fields FieldMask) (VcsInfo, []error) {
 	info := VcsInfo{
 		VcsName: probe.Name(),
 		Path:    path,
@@ -103,18 +247,120 @@ func (probe BzrProbe) GatherInfo(path string) (VcsInfo, []error) {
 	info.RepositoryRoot = root
 
 	errors := waitGroup(
-		func() error {
-			return probe.extractStatus(path, &info)
-		},
+		fields.when(FieldStatus|FieldUntracked, func() error {
+			return probe.extractStatus(ctx, path, &info)
+		}),
+
+		fields.when(FieldHash|FieldRevision|FieldBranch|FieldCommit, func() error {
+			return probe.extractCommitInfo(ctx, path, &info)
+		}),
+
+		fields.when(FieldStash, func() error {
+			return probe.extractShelved(ctx, path, &info)
+		}),
 
-		func() error {
-			return probe.extractCommitInfo(path, &info)
-		},
+		fields.when(FieldTags, func() error {
+			return probe.extractTags(ctx, path, &info)
+		}),
 
-		func() error {
-			return probe.extractShelved(path, &info)
-		},
+		fields.when(FieldRemote, func() error {
+			return probe.extractRemote(ctx, path, &info)
+		}),
+
+		fields.when(FieldCommit, func() error {
+			return probe.extractAuthor(ctx, path, &info)
+		}),
 	)
 
 	return info, errors
 }
+
+// FileStatus returns the status of each file in the working tree of the Bazaar
+// branch at the specified path that differs from the current revision.
+func (probe BzrProbe) FileStatus(path string) ([]FileStatus, error) {
+	return probe.FileStatusContext(context.Background(), path)
+}
+
+// FileStatusContext returns the status of each file in the working tree of the
+// Bazaar branch at the specified path that differs from the current revision,
+// abandoning the work when the context expires.
+func (probe BzrProbe) FileStatusContext(ctx context.Context, path string) ([]FileStatus, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := runCommand(ctx, root, "bzr", "status", "--short")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []FileStatus{}
+	for _, line := range out {
+		if len(line) < 5 {
+			continue
+		}
+
+		// The first column describes the versioning of the file, the second
+		// its contents, and the third its execute bit.
+		versioning, contents, execute := line[0], line[1], line[2]
+		name, original := strings.TrimSpace(line[3:]), ""
+
+		var state FileState
+		switch {
+		case versioning == '?':
+			state = FileUntracked
+		case versioning == 'C':
+			state = FileConflicted
+			// Conflicts are described (e.g., "Text conflict in foo").
+			if idx := strings.Index(name, " in "); idx >= 0 {
+				name = name[idx+4:]
+			}
+		case versioning == 'R':
+			state = FileRenamed
+			if parts := strings.SplitN(name, " => ", 2); len(parts) == 2 {
+				original, name = parts[0], parts[1]
+			}
+		case versioning == '+' || contents == 'N':
+			state = FileAdded
+		case versioning == '-':
+			state = FileDeleted
+		case contents == 'D':
+			state = FileMissing
+		case contents == 'M' || contents == 'K' || execute == '*':
+			state = FileModified
+		default:
+			continue
+		}
+
+		file := worktreeStatus(name, state)
+		file.OriginalPath = filepath.ToSlash(original)
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// DiffStat summarizes the differences between the working tree of the Bazaar
+// branch at the specified path and the current revision.
+func (probe BzrProbe) DiffStat(path string) (DiffStat, error) {
+	return probe.DiffStatContext(context.Background(), path)
+}
+
+// DiffStatContext summarizes the differences between the working tree of the
+// Bazaar branch at the specified path and the current revision, abandoning the
+// work when the context expires.
+func (probe BzrProbe) DiffStatContext(ctx context.Context, path string) (DiffStat, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	out, err := runCommand(ctx, root, "bzr", "diff")
+	if err != nil && getExitCode(err) != 1 {
+		// An exit code of 1 just means there were differences.
+		return DiffStat{}, err
+	}
+
+	return parseUnifiedDiff(out), nil
+}
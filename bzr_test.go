@@ -1,6 +1,9 @@
 package vcsinfo_test
 
 import (
+	"context"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gstruct"
@@ -170,6 +173,67 @@ var _ = Describe("Bazaar", func() {
 			}))
 		})
 
+		It("counts changes", func() {
+			writeFile(dir, "foo", "bar")
+			writeFile(dir, "bar", "baz")
+			run(dir, "bzr", "add", "foo", "bar")
+			run(dir, "bzr", "commit", "-m", "blah")
+			writeFile(dir, "foo", "modified")
+			writeFile(dir, "bar", "modified")
+			writeFile(dir, "new", "file")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"ModifiedCount":  Equal(2),
+				"UntrackedCount": Equal(1),
+			}))
+		})
+
+		It("sees conflicts", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "bzr", "add", "foo")
+			run(dir, "bzr", "commit", "-m", "blah")
+			run(repoDir, "bzr", "branch", "trunk", "other")
+			writeFile(repoDir+"/other", "foo", "baz")
+			run(repoDir+"/other", "bzr", "commit", "-m", "other")
+			writeFile(dir, "foo", "qux")
+			run(dir, "bzr", "commit", "-m", "trunk")
+			runIgnoringFailure(dir, "bzr", "merge", "../other")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasConflicts": BeTrue(),
+			}))
+		})
+
+		It("sees tags", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "bzr", "add", "foo")
+			run(dir, "bzr", "commit", "-m", "blah")
+			run(dir, "bzr", "tag", "v1.0")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Tags":               ConsistOf("v1.0"),
+				"NearestTag":         Equal("v1.0"),
+				"NearestTagDistance": Equal(0),
+			}))
+
+			writeFile(dir, "foo", "baz")
+			run(dir, "bzr", "commit", "-m", "blah")
+			info, err = probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Tags":               BeEmpty(),
+				"NearestTag":         Equal("v1.0"),
+				"NearestTagDistance": Equal(1),
+			}))
+		})
+
 		It("sees branches", func() {
 			run(repoDir, "bzr", "branch", "trunk", "mycoolbranch")
 			info, _ := probe.GatherInfo(repoDir + "/mycoolbranch")
@@ -179,9 +243,120 @@ var _ = Describe("Bazaar", func() {
 			}))
 		})
 
+		It("sees remotes", func() {
+			run(repoDir, "bzr", "branch", "trunk", "other")
+			info, err := probe.GatherInfo(repoDir + "/other")
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"RemoteName": Equal("parent"),
+				"RemoteURL":  ContainSubstring("trunk"),
+				"RemoteHost": Equal(""),
+			}))
+		})
+
+		It("sees no remotes", func() {
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"RemoteName": Equal(""),
+				"RemoteURL":  Equal(""),
+			}))
+		})
+
+		It("sees commit metadata", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "bzr", "add", "foo")
+			run(dir, "bzr", "commit", "--author", "Jay <jay@example.com>", "-m", "First line\n\nMore details")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"CommitAuthor":  Equal("Jay"),
+				"CommitEmail":   Equal("jay@example.com"),
+				"CommitTime":    BeTemporally("~", time.Now(), time.Minute),
+				"CommitSubject": Equal("First line"),
+			}))
+		})
+
+		It("returns partial info when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			info, err := probe.GatherInfoContext(ctx, dir)
+			Expect(err).To(ContainElement(context.Canceled))
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName":        Equal("bzr"),
+				"Path":           Equal(dir),
+				"RepositoryRoot": Equal(dir),
+			}))
+		})
+
 		It("doesnt crash when in VCS special dir", func() {
 			_, err := probe.GatherInfo(dir + "/.bzr")
 			Expect(err).To(BeEmpty())
 		})
 	})
+
+	Describe("FileStatus", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			run(dir, "bzr", "init")
+			writeFile(dir, "foo", "bar")
+			writeFile(dir, "baz", "qux")
+			run(dir, "bzr", "add", "foo", "baz")
+			run(dir, "bzr", "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("sees changed files", func() {
+			writeFile(dir, "foo", "changed")
+			writeFile(dir, "new", "file")
+			run(dir, "bzr", "mv", "baz", "moved")
+			files, err := probe.FileStatus(dir)
+			Expect(err).To(BeNil())
+
+			Expect(files).To(ConsistOf(
+				FileStatus{Path: "foo", Index: FileUnmodified, Worktree: FileModified},
+				FileStatus{Path: "new", Index: FileUnmodified, Worktree: FileUntracked},
+				FileStatus{Path: "moved", Index: FileUnmodified, Worktree: FileRenamed, OriginalPath: "baz"},
+			))
+		})
+	})
+
+	Describe("DiffStat", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			run(dir, "bzr", "init")
+			writeFile(dir, "foo", "one\ntwo\nthree\n")
+			run(dir, "bzr", "add", "foo")
+			run(dir, "bzr", "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("counts changed files and lines", func() {
+			writeFile(dir, "foo", "one\nTWO\nthree\nfour\n")
+			stat, err := probe.DiffStat(dir)
+			Expect(err).To(BeNil())
+
+			Expect(stat).To(Equal(DiffStat{
+				FilesChanged: 1,
+				Insertions:   2,
+				Deletions:    1,
+			}))
+		})
+	})
 })
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/jayclassless/vcsinfo"
+)
+
+// configSetting is a setting that can be specified in the configuration file,
+// along with the flag it provides the value for.
+type configSetting struct {
+	key  string
+	flag string
+}
+
+// configValue is a value for a setting that was read from the configuration
+// file.
+type configValue struct {
+	value  string
+	source string
+}
+
+// configSections are the tables in the configuration file that hold settings.
+var configSections = []string{"formats", "indicators"}
+
+func makeConfigSettings(probes []vcsinfo.VcsProbe) []configSetting {
+	settings := []configSetting{
+		{"probes", "probes"},
+		{"format", "format"},
+		{"template", "template"},
+		{"color", "color"},
+		{"timeout", "timeout"},
+		{"diff_stat", "diff-stat"},
+		{"git_native", "git-native"},
+		{"hg_compare_upstream", "hg-compare-upstream"},
+		{"jj_ignore_working_copy", "jj-ignore-working-copy"},
+		{"svn_ask_server", "svn-ask-server"},
+		{"cvs_ask_server", "cvs-ask-server"},
+		{"p4_ask_server", "p4-ask-server"},
+		{"use_daemon", "use-daemon"},
+		{"socket", "socket"},
+	}
+
+	for _, probe := range probes {
+		settings = append(settings, configSetting{
+			key:  "formats." + probe.Name(),
+			flag: "format-" + probe.Name(),
+		})
+	}
+
+	for _, indicator := range []string{"untracked", "modified", "staged", "stashed", "conflicts", "unknown"} {
+		settings = append(settings, configSetting{
+			key:  "indicators." + indicator,
+			flag: "format-" + indicator,
+		})
+	}
+
+	return settings
+}
+
+// determineConfigPath returns the path to the configuration file, which
+// defaults to vcsinfo/config.toml in $XDG_CONFIG_HOME.
+func determineConfigPath() (string, error) {
+	if *configPath != "" {
+		return *configPath, nil
+	}
+	if configDir := os.Getenv("XDG_CONFIG_HOME"); configDir != "" {
+		return filepath.Join(configDir, "vcsinfo", "config.toml"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "vcsinfo", "config.toml"), nil
+}
+
+// matchesDirectory indicates whether or not the path, or any of the
+// directories it is within, matches the glob pattern.
+func matchesDirectory(pattern string, path string) (bool, error) {
+	if strings.HasPrefix(pattern, "~") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return false, err
+		}
+		pattern = home + pattern[1:]
+	}
+	pattern = filepath.Clean(pattern)
+
+	for {
+		matched, err := filepath.Match(pattern, path)
+		if err != nil || matched {
+			return matched, err
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false, nil
+		}
+		path = parent
+	}
+}
+
+// flattenConfig adds the settings in a table of the configuration file to the
+// values, keyed by their dotted names.
+func flattenConfig(table map[string]interface{}, prefix string, source string, known map[string]bool, values map[string]configValue) error {
+	for key, value := range table {
+		name := prefix + key
+
+		var str string
+		switch value := value.(type) {
+		case map[string]interface{}:
+			if prefix == "" && isConfigSection(key) {
+				if err := flattenConfig(value, key+".", source, known, values); err != nil {
+					return err
+				}
+				continue
+			}
+			return fmt.Errorf("unexpected table %q in %s", name, source)
+		case string:
+			str = value
+		case bool:
+			str = strconv.FormatBool(value)
+		case int64:
+			str = strconv.FormatInt(value, 10)
+		case []interface{}:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			str = strings.Join(items, ",")
+		default:
+			return fmt.Errorf("unsupported value for %s in %s", name, source)
+		}
+
+		if !known[name] {
+			if prefix == "formats." {
+				// The VCS may just not be installed on this machine.
+				continue
+			}
+			return fmt.Errorf("unknown setting %q in %s", name, source)
+		}
+		values[name] = configValue{value: str, source: source}
+	}
+
+	return nil
+}
+
+func isConfigSection(key string) bool {
+	for _, section := range configSections {
+		if key == section {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig reads the values of the settings from the configuration file,
+// including those from the [[directory]] tables whose path globs match the
+// target path. A missing configuration file is not an error.
+func loadConfig(path string, target string, settings []configSetting) (map[string]configValue, error) {
+	values := make(map[string]configValue)
+
+	var raw map[string]interface{}
+	_, err := toml.DecodeFile(path, &raw)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return values, err
+	}
+
+	known := make(map[string]bool)
+	for _, setting := range settings {
+		known[setting.key] = true
+	}
+
+	directories, _ := raw["directory"].([]map[string]interface{})
+	if _, ok := raw["directory"]; ok && directories == nil {
+		return nil, fmt.Errorf("directory must be an array of tables in %s", path)
+	}
+	delete(raw, "directory")
+
+	err = flattenConfig(raw, "", path, known, values)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, directory := range directories {
+		pattern, ok := directory["path"].(string)
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("directory tables must have a path in %s", path)
+		}
+		delete(directory, "path")
+
+		overrides := make(map[string]configValue)
+		source := fmt.Sprintf("%s [directory %s]", path, pattern)
+		err = flattenConfig(directory, "", source, known, overrides)
+		if err != nil {
+			return nil, err
+		}
+
+		if target == "" {
+			continue
+		}
+		matched, err := matchesDirectory(pattern, target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid directory path %q in %s: %s", pattern, path, err)
+		}
+		if matched {
+			for key, value := range overrides {
+				values[key] = value
+			}
+		}
+	}
+
+	return values, nil
+}
+
+// explicitFlags returns the names of the flags that were specified on the
+// command line.
+func explicitFlags(args []string) (map[string]bool, error) {
+	parsed, err := app.ParseContext(args)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := make(map[string]bool)
+	for _, element := range parsed.Elements {
+		if flag, ok := element.Clause.(*kingpin.FlagClause); ok {
+			flags[flag.Model().Name] = true
+		}
+	}
+	return flags, nil
+}
+
+// applyConfig uses the values from the configuration file for the flags that
+// weren't specified on the command line or via environment variables, and
+// returns where the value of each setting came from.
+func applyConfig(values map[string]configValue, settings []configSetting, explicit map[string]bool) (map[string]string, error) {
+	sources := make(map[string]string)
+
+	for _, setting := range settings {
+		flag := app.GetFlag(setting.flag)
+		model := flag.Model()
+
+		if explicit[setting.flag] {
+			sources[setting.key] = "--" + setting.flag
+			continue
+		}
+		if model.Envar != "" && os.Getenv(model.Envar) != "" {
+			sources[setting.key] = "$" + model.Envar
+			continue
+		}
+
+		value, ok := values[setting.key]
+		if !ok {
+			sources[setting.key] = "default"
+			continue
+		}
+		if err := model.Value.Set(value.value); err != nil {
+			return nil, fmt.Errorf("invalid value for %s in %s: %s", setting.key, value.source, err)
+		}
+		sources[setting.key] = value.source
+	}
+
+	return sources, nil
+}
+
+// configureFromFile loads the configuration file, applies it to the flags,
+// and returns where the value of each setting came from.
+func configureFromFile(target string, allProbes []vcsinfo.VcsProbe) (map[string]string, error) {
+	path, err := determineConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := makeConfigSettings(allProbes)
+	values, err := loadConfig(path, target, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	explicit, err := explicitFlags(os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return applyConfig(values, settings, explicit)
+}
+
+func showConfig(allProbes []vcsinfo.VcsProbe, probes []vcsinfo.VcsProbe, sources map[string]string) {
+	path, err := determineConfigPath()
+	failIfError(err, "Could not find configuration file")
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		fmt.Printf("# %s (not found)\n", path)
+	} else {
+		fmt.Printf("# %s\n", path)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	printSetting := func(key string, value string) {
+		name := key[strings.LastIndex(key, ".")+1:]
+		fmt.Fprintf(writer, "%s = %s\t# %s\n", name, value, sources[key])
+	}
+
+	settings := makeConfigSettings(allProbes)
+	for _, section := range append([]string{""}, configSections...) {
+		if section != "" {
+			fmt.Fprintf(writer, "\n[%s]\n", section)
+		}
+
+		for _, setting := range settings {
+			if section == "" && strings.Contains(setting.key, ".") {
+				continue
+			}
+			if section != "" && !strings.HasPrefix(setting.key, section+".") {
+				continue
+			}
+
+			if setting.key == "probes" {
+				// Show the probes that are actually in use.
+				names := make([]string, 0, len(probes))
+				for _, probe := range probes {
+					names = append(names, strconv.Quote(probe.Name()))
+				}
+				printSetting(setting.key, fmt.Sprintf("[%s]", strings.Join(names, ", ")))
+				continue
+			}
+
+			model := app.GetFlag(setting.flag).Model()
+			value := model.Value.String()
+			if !model.IsBoolFlag() {
+				value = strconv.Quote(value)
+			}
+			printSetting(setting.key, value)
+		}
+	}
+
+	err = writer.Flush()
+	failIfError(err, "Failure producing output")
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"context"
+	encjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/jayclassless/vcsinfo"
+)
+
+// daemonDialTimeout is how long clients will wait to connect to the daemon
+// before falling back to gathering the information themselves.
+const daemonDialTimeout = 100 * time.Millisecond
+
+// daemonMaxRoots and daemonMaxEntries limit how many repositories the daemon
+// watches, and how many paths it caches the information of. When there are
+// more, the least recently requested are forgotten.
+const (
+	daemonMaxRoots   = 32
+	daemonMaxEntries = 1024
+)
+
+// daemonRequest is sent by clients to the daemon as a single JSON document.
+type daemonRequest struct {
+	Path string `json:"path"`
+}
+
+// daemonResponse is sent by the daemon in reply to a daemonRequest. Probe is
+// empty if the path is not in a repository. If the daemon can't find out about
+// the path at all, it closes the connection without responding.
+type daemonResponse struct {
+	Probe    string          `json:"probe"`
+	Info     vcsinfo.VcsInfo `json:"info"`
+	Errors   []string        `json:"errors"`
+	TimedOut bool            `json:"timed_out"`
+}
+
+// fallbackSocketDir returns the directory the socket is put in when there is no
+// $XDG_RUNTIME_DIR. It's specific to the user, and only they can access it, so
+// that other users can't interfere with the socket.
+func fallbackSocketDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("vcsinfo-%d", os.Getuid()))
+}
+
+func determineSocketPath() string {
+	if *socketPath != "" {
+		return *socketPath
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "vcsinfo.sock")
+	}
+	return filepath.Join(fallbackSocketDir(), "vcsinfo.sock")
+}
+
+// checkOwner ensures that the file is owned by the current user, and that no
+// one else can access it, so that another user can't pose as the daemon.
+func checkOwner(path string, fileInfo os.FileInfo) error {
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("could not determine the owner of %s", path)
+	}
+	if int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is not owned by the current user", path)
+	}
+	if fileInfo.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s is accessible by other users", path)
+	}
+	return nil
+}
+
+// makePrivateDir creates the directory (if it doesn't already exist) so that
+// only the current user can access it.
+func makePrivateDir(dir string) error {
+	err := os.Mkdir(dir, 0700)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	fileInfo, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fileInfo.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return checkOwner(dir, fileInfo)
+}
+
+// queryDaemon asks the daemon listening on the socket for the VCS information
+// for the path.
+func queryDaemon(socket string, path string, timeout time.Duration) (daemonResponse, error) {
+	var response daemonResponse
+
+	fileInfo, err := os.Stat(socket)
+	if err != nil {
+		return response, err
+	}
+	err = checkOwner(socket, fileInfo)
+	if err != nil {
+		return response, err
+	}
+
+	conn, err := net.DialTimeout("unix", socket, daemonDialTimeout)
+	if err != nil {
+		return response, err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		err = conn.SetDeadline(time.Now().Add(timeout))
+		if err != nil {
+			return response, err
+		}
+	}
+
+	err = encjson.NewEncoder(conn).Encode(daemonRequest{Path: path})
+	if err != nil {
+		return response, err
+	}
+
+	err = encjson.NewDecoder(conn).Decode(&response)
+	return response, err
+}
+
+// cacheEntry is the VCS information cached for a path.
+type cacheEntry struct {
+	root     string
+	response daemonResponse
+	lastUsed time.Time
+}
+
+// watchedRoot is a repository root the daemon is watching for changes.
+type watchedRoot struct {
+	// The number of times the root has been invalidated.
+	generation int
+	lastUsed   time.Time
+
+	// The number of times information is being gathered for the root. While
+	// it is, changes to the VCS metadata are assumed to be made by the probe
+	// itself (e.g., Git refreshing its index), so they don't invalidate
+	// anything.
+	gathering int
+}
+
+// infoCache maintains the VCS information for the paths requested of the
+// daemon, discarding it whenever anything changes within the repository the
+// path is in.
+type infoCache struct {
+	sync.Mutex
+
+	probes  []vcsinfo.VcsProbe
+	timeout time.Duration
+	watcher *fsnotify.Watcher
+
+	// The cached information, keyed by the requested path.
+	entries map[string]cacheEntry
+
+	// The repository roots being watched.
+	roots map[string]*watchedRoot
+
+	// A directory that is also watched, in which a file is created and
+	// removed whenever information has been gathered. The events are
+	// delivered in order, so the changes to the metadata seen before the
+	// file's removal were made while gathering.
+	markerDir string
+	markers   map[string]*watchedRoot
+	marked    int
+}
+
+func newInfoCache(probes []vcsinfo.VcsProbe, timeout time.Duration) (*infoCache, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	markerDir, err := os.MkdirTemp("", "vcsinfo-daemon-")
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	err = watcher.Add(markerDir)
+	if err != nil {
+		watcher.Close()
+		os.RemoveAll(markerDir)
+		return nil, err
+	}
+
+	cache := &infoCache{
+		probes:    probes,
+		timeout:   timeout,
+		watcher:   watcher,
+		entries:   make(map[string]cacheEntry),
+		roots:     make(map[string]*watchedRoot),
+		markerDir: markerDir,
+		markers:   make(map[string]*watchedRoot),
+	}
+	go cache.processEvents()
+
+	return cache, nil
+}
+
+func (cache *infoCache) Close() error {
+	err := cache.watcher.Close()
+	os.RemoveAll(cache.markerDir)
+	return err
+}
+
+// watchTree adds watches for the directory and the directories beneath it
+// that can change the information about the repository.
+func (cache *infoCache) watchTree(dir string) error {
+	return vcsinfo.WatchTree(cache.watcher, dir)
+}
+
+// markGathered indicates that information has been gathered for the root, by
+// creating and removing a file in the marker directory. Must be called with
+// the lock held.
+func (cache *infoCache) markGathered(watching *watchedRoot) {
+	cache.marked++
+	path := filepath.Join(cache.markerDir, fmt.Sprintf("%d", cache.marked))
+
+	file, err := os.Create(path)
+	if err == nil {
+		file.Close()
+		err = os.Remove(path)
+	}
+	if err != nil {
+		// Without the marker, the root would be considered to be gathering
+		// forever.
+		watching.gathering--
+		return
+	}
+	cache.markers[path] = watching
+}
+
+// settle handles the event for the removal of a marker file, after which the
+// changes to the metadata are no longer the probe's.
+func (cache *infoCache) settle(path string) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if watching, ok := cache.markers[path]; ok {
+		watching.gathering--
+		delete(cache.markers, path)
+	}
+}
+
+// findRoot returns the watched repository root that contains the path, if
+// any. If repositories are nested, the innermost is returned.
+func (cache *infoCache) findRoot(path string) string {
+	found := ""
+	for root := range cache.roots {
+		if (path == root || strings.HasPrefix(path, root+string(filepath.Separator))) && len(root) > len(found) {
+			found = root
+		}
+	}
+	return found
+}
+
+func (cache *infoCache) invalidate(path string) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	root := cache.findRoot(path)
+	if root == "" {
+		return
+	}
+	if cache.roots[root].gathering > 0 && vcsinfo.IsMetadataPath(strings.TrimPrefix(path, root)) {
+		return
+	}
+
+	cache.roots[root].generation++
+	for key, entry := range cache.entries {
+		if entry.root == root {
+			delete(cache.entries, key)
+		}
+	}
+}
+
+func (cache *infoCache) processEvents() {
+	for {
+		select {
+		case event, ok := <-cache.watcher.Events:
+			if !ok {
+				return
+			}
+
+			if filepath.Dir(event.Name) == cache.markerDir {
+				if event.Op&fsnotify.Remove == fsnotify.Remove {
+					cache.settle(event.Name)
+				}
+				continue
+			}
+
+			// Lock files come and go while the VCS tools read the
+			// repository, so they don't indicate an actual change.
+			if strings.HasSuffix(event.Name, ".lock") {
+				continue
+			}
+
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				if exists, _ := isDir(event.Name); exists {
+					err := cache.watchTree(event.Name)
+					if err != nil {
+						app.Errorf("%s", err)
+					}
+				}
+			}
+
+			cache.invalidate(event.Name)
+
+		case err, ok := <-cache.watcher.Errors:
+			if !ok {
+				return
+			}
+			app.Errorf("Failure watching for changes: %s", err)
+		}
+	}
+}
+
+func isDir(path string) (bool, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return false, err
+	}
+	return fileInfo.IsDir(), nil
+}
+
+// findRepositoryRoot returns the closest ancestor of the path (or the path
+// itself) that the probe considers to be the root of a repository.
+func findRepositoryRoot(path string, probe vcsinfo.VcsProbe) (string, error) {
+	for {
+		isRoot, err := probe.IsRepositoryRoot(path)
+		if err != nil {
+			return "", err
+		}
+		if isRoot {
+			return path, nil
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", fmt.Errorf("could not find the repository root for %s", path)
+		}
+		path = parent
+	}
+}
+
+// unwatchTree removes the watches added by watchTree for the directory, other
+// than those within the other repository roots being watched.
+func (cache *infoCache) unwatchTree(dir string, otherRoots []string) {
+	dirs, _ := vcsinfo.WatchedDirs(dir)
+	for _, path := range dirs {
+		within := false
+		for _, root := range otherRoots {
+			if root != dir && (path == root || strings.HasPrefix(path, root+string(filepath.Separator))) {
+				within = true
+				break
+			}
+		}
+		if !within {
+			cache.watcher.Remove(path)
+		}
+	}
+}
+
+// evict forgets the least recently requested roots and entries while there
+// are too many of them. Must be called with the lock held; the roots that are
+// no longer watched are returned, so that their watches can be removed once
+// the lock is released.
+func (cache *infoCache) evict() []string {
+	evicted := []string{}
+
+	for len(cache.roots) > daemonMaxRoots {
+		oldest := ""
+		for root, watched := range cache.roots {
+			if oldest == "" || watched.lastUsed.Before(cache.roots[oldest].lastUsed) {
+				oldest = root
+			}
+		}
+
+		delete(cache.roots, oldest)
+		for key, entry := range cache.entries {
+			if entry.root == oldest {
+				delete(cache.entries, key)
+			}
+		}
+		evicted = append(evicted, oldest)
+	}
+
+	for len(cache.entries) > daemonMaxEntries {
+		oldest := ""
+		for key, entry := range cache.entries {
+			if oldest == "" || entry.lastUsed.Before(cache.entries[oldest].lastUsed) {
+				oldest = key
+			}
+		}
+		delete(cache.entries, oldest)
+	}
+
+	return evicted
+}
+
+// forget removes the watches for the roots that were evicted.
+func (cache *infoCache) forget(evicted []string) {
+	if len(evicted) == 0 {
+		return
+	}
+
+	cache.Lock()
+	otherRoots := []string{}
+	for root := range cache.roots {
+		otherRoots = append(otherRoots, root)
+	}
+	cache.Unlock()
+
+	for _, root := range evicted {
+		cache.unwatchTree(root, otherRoots)
+	}
+}
+
+// Lookup returns the VCS information for the path, gathering it if it is not
+// already cached.
+func (cache *infoCache) Lookup(path string) (daemonResponse, error) {
+	now := time.Now()
+
+	cache.Lock()
+	entry, ok := cache.entries[path]
+	if ok {
+		entry.lastUsed = now
+		cache.entries[path] = entry
+		cache.roots[entry.root].lastUsed = now
+	}
+	cache.Unlock()
+	if ok {
+		return entry.response, nil
+	}
+
+	response := daemonResponse{}
+
+	probe, err := vcsinfo.FindProbeForPath(path, cache.probes)
+	if err != nil || probe == nil {
+		return response, err
+	}
+	response.Probe = probe.Name()
+
+	root, err := findRepositoryRoot(path, probe)
+	if err != nil {
+		return response, err
+	}
+
+	cache.Lock()
+	_, watched := cache.roots[root]
+	cache.Unlock()
+
+	// The root is only marked as watched once it is, otherwise the
+	// information cached for it would never be invalidated.
+	if !watched {
+		err = cache.watchTree(root)
+		if err != nil {
+			return response, err
+		}
+	}
+
+	cache.Lock()
+	watching, watched := cache.roots[root]
+	if !watched {
+		watching = &watchedRoot{}
+		cache.roots[root] = watching
+	}
+	watching.lastUsed = now
+	watching.gathering++
+	generation := watching.generation
+	evicted := cache.evict()
+	cache.Unlock()
+	cache.forget(evicted)
+
+	ctx := context.Background()
+	if cache.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cache.timeout)
+		defer cancel()
+	}
+
+	info, errs := probe.GatherInfoContext(ctx, path)
+	response.Info = info
+	for _, err := range errs {
+		if err == context.DeadlineExceeded {
+			response.TimedOut = true
+		} else {
+			response.Errors = append(response.Errors, err.Error())
+		}
+	}
+
+	// Only cache complete information that nothing has changed underneath
+	// while it was being gathered, and that will be invalidated when it does.
+	cache.Lock()
+	cache.markGathered(watching)
+	evicted = nil
+	if len(errs) == 0 {
+		if current, watched := cache.roots[root]; watched && current == watching && current.generation == generation {
+			cache.entries[path] = cacheEntry{
+				root:     root,
+				response: response,
+				lastUsed: now,
+			}
+			evicted = cache.evict()
+		}
+	}
+	cache.Unlock()
+	cache.forget(evicted)
+
+	return response, nil
+}
+
+func (cache *infoCache) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	var request daemonRequest
+	err := encjson.NewDecoder(conn).Decode(&request)
+	if err != nil {
+		// Connections closed without a request are just checking whether or
+		// not the daemon is running.
+		if err != io.EOF {
+			app.Errorf("Could not read request: %s", err)
+		}
+		return
+	}
+
+	response, err := cache.Lookup(filepath.Clean(request.Path))
+	if err != nil {
+		// Closing the connection without a response tells the client to
+		// gather the information itself.
+		app.Errorf("Could not retrieve information for %s: %s", request.Path, err)
+		return
+	}
+
+	err = encjson.NewEncoder(conn).Encode(response)
+	if err != nil {
+		app.Errorf("Could not send response: %s", err)
+	}
+}
+
+// listen opens the daemon's socket, replacing it if it was left behind by a
+// daemon that is no longer running.
+func listen(socket string) (net.Listener, error) {
+	if filepath.Dir(socket) == fallbackSocketDir() {
+		err := makePrivateDir(filepath.Dir(socket))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if conn, err := net.DialTimeout("unix", socket, daemonDialTimeout); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a daemon is already listening on %s", socket)
+	}
+
+	err := os.Remove(socket)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// The socket is created inaccessible to other users, rather than being
+	// restricted afterwards, so that there's no moment they can connect to it.
+	mask := syscall.Umask(0177)
+	listener, err := net.Listen("unix", socket)
+	syscall.Umask(mask)
+
+	return listener, err
+}
+
+func runDaemon(probes []vcsinfo.VcsProbe) {
+	cache, err := newInfoCache(probes, *timeout)
+	app.FatalIfError(err, "Could not start watching for changes")
+	defer cache.Close()
+
+	socket := determineSocketPath()
+	listener, err := listen(socket)
+	app.FatalIfError(err, "Could not listen on %s", socket)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// The listener is only closed when we've been asked to stop.
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			app.FatalIfError(err, "Failure accepting connection")
+		}
+
+		go cache.handleConnection(conn)
+	}
+}
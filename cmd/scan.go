@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/jayclassless/vcsinfo"
+)
+
+// metadataDirs are the names of the directories that the VCS tools keep their
+// metadata in, which will never contain repositories of their own.
+var metadataDirs = map[string]bool{
+	".git":   true,
+	".jj":    true,
+	".sl":    true,
+	".hg":    true,
+	".svn":   true,
+	".bzr":   true,
+	"_darcs": true,
+	"CVS":    true,
+}
+
+// scanResult is a repository found by a scan.
+type scanResult struct {
+	path  string
+	probe vcsinfo.VcsProbe
+	info  vcsinfo.VcsInfo
+	errs  []error
+}
+
+// findRepositories walks the directory tree, returning every repository found
+// within it.
+func findRepositories(root string, probes []vcsinfo.VcsProbe) ([]scanResult, error) {
+	results := []scanResult{}
+
+	err := filepath.Walk(root, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			// Skip anything we're not allowed to look at, or that has
+			// disappeared since it was found.
+			if path != root && (os.IsPermission(err) || os.IsNotExist(err)) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !fileInfo.IsDir() {
+			return nil
+		}
+		if metadataDirs[fileInfo.Name()] {
+			return filepath.SkipDir
+		}
+
+		skip, err := fileExists(filepath.Join(path, ".novcsinfo"))
+		if err != nil {
+			return err
+		}
+		if skip {
+			return filepath.SkipDir
+		}
+
+		for _, probe := range probes {
+			isRoot, err := probe.IsRepositoryRoot(path)
+			if err != nil {
+				return err
+			}
+			if isRoot {
+				results = append(results, scanResult{
+					path:  path,
+					probe: probe,
+				})
+				break
+			}
+		}
+
+		return nil
+	})
+
+	return results, err
+}
+
+func fileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// gatherAll retrieves the VCS information for the repositories using the
+// specified number of concurrent workers.
+func gatherAll(results []scanResult, jobs int) {
+	fields := requiredFields
+	if !hasCustomOutput() {
+		tableFields, _ := vcsinfo.FormatFields(scanTableFormat)
+		fields = func(vcsinfo.VcsProbe) vcsinfo.FieldMask {
+			return tableFields
+		}
+	}
+
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	queue := make(chan *scanResult)
+	var wg sync.WaitGroup
+
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for result := range queue {
+				ctx := context.Background()
+				cancel := func() {}
+				if *timeout > 0 {
+					ctx, cancel = context.WithTimeout(ctx, *timeout)
+				}
+				result.info, result.errs = vcsinfo.GatherInfoWithFields(ctx, result.probe, result.path, fields(result.probe))
+				if err := addDiffStat(ctx, result.probe, result.path, &result.info); err != nil {
+					result.errs = append(result.errs, err)
+				}
+				cancel()
+			}
+		}()
+	}
+
+	for idx := range results {
+		queue <- &results[idx]
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// hasCustomOutput indicates whether or not the output of the scan has been
+// customized via the output format flags.
+func hasCustomOutput() bool {
+	if *json || *xml || *format != "" || *outputTemplate != "" {
+		return true
+	}
+	for _, probeFormat := range probeFormats {
+		if *probeFormat != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// scanTableFormat is the format string used to render the columns describing
+// each repository in the default output of the scan.
+const scanTableFormat = "%n\t%d\t%v\t%o%c%a%m%u%t"
+
+func printTable(root string, results []scanResult) error {
+	options := makeFormatOptions()
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "PATH\tVCS\tBRANCH\tREVISION\tSTATUS")
+
+	for _, result := range results {
+		relPath, err := filepath.Rel(root, result.path)
+		if err != nil {
+			return err
+		}
+
+		row, err := vcsinfo.InfoToString(result.info, scanTableFormat, options)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\n", relPath, row)
+	}
+
+	return writer.Flush()
+}
+
+func scan(allProbes []vcsinfo.VcsProbe) {
+	root, err := determinePath(*scanPath)
+	failIfError(err, "Could not find directory to scan")
+
+	results, err := findRepositories(root, allProbes)
+	failIfError(err, "Failure searching for repositories")
+
+	gatherAll(results, *scanJobs)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].path < results[j].path
+	})
+
+	if *noisy {
+		for _, result := range results {
+			for _, err := range result.errs {
+				if err == context.DeadlineExceeded {
+					app.Errorf("%s: Timed out retrieving VCS information; output may be incomplete", result.path)
+				} else {
+					app.Errorf("%s: %s", result.path, err)
+				}
+			}
+		}
+	}
+
+	if !hasCustomOutput() {
+		err = printTable(root, results)
+		failIfError(err, "Failure producing output")
+		return
+	}
+
+	for _, result := range results {
+		output, err := produceOutput(result.info, result.probe)
+		failIfError(err, "Failure producing output")
+
+		fmt.Println(output)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jayclassless/vcsinfo"
+)
+
+func printFiles(files []vcsinfo.FileStatus) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "INDEX\tWORKTREE\tPATH")
+
+	state := func(state vcsinfo.FileState) string {
+		if state == vcsinfo.FileUnmodified {
+			return "-"
+		}
+		return string(state)
+	}
+
+	for _, file := range files {
+		path := file.Path
+		if file.OriginalPath != "" {
+			path = fmt.Sprintf("%s -> %s", file.OriginalPath, file.Path)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", state(file.Index), state(file.Worktree), path)
+	}
+
+	return writer.Flush()
+}
+
+func status(allProbes []vcsinfo.VcsProbe) {
+	path, err := determinePath(*targetPath)
+	failIfError(err, "Could not find path to analyze")
+
+	probe, err := vcsinfo.FindProbeForPath(path, allProbes)
+	failIfError(err, "Failure detecting VCS")
+	if probe == nil {
+		return
+	}
+
+	statusProbe, ok := probe.(vcsinfo.FileStatusProbe)
+	if !ok {
+		failIfError(fmt.Errorf("the %s probe cannot list file status", probe.Name()), "Failure retrieving file status")
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	files, err := statusProbe.FileStatusContext(ctx, path)
+	failIfError(err, "Failure retrieving file status")
+
+	var output string
+	switch {
+	case *json:
+		output, err = vcsinfo.FilesToJSON(files)
+	case *xml:
+		output, err = vcsinfo.FilesToXML(files)
+	default:
+		err = printFiles(files)
+		failIfError(err, "Failure producing output")
+		return
+	}
+	failIfError(err, "Failure producing output")
+
+	fmt.Println(output)
+}
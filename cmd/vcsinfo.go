@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -36,6 +40,10 @@ var (
 		"format",
 		"The output format of the VCS information.",
 	).Short('f').OverrideDefaultFromEnvar("VCSINFO_FORMAT").String()
+	outputTemplate = app.Flag(
+		"template",
+		"A Go text/template to render the VCS information with (overrides --format).",
+	).OverrideDefaultFromEnvar("VCSINFO_TEMPLATE").String()
 	formatUntracked = app.Flag(
 		"format-untracked",
 		"The string to use for the untracked files indicator.",
@@ -52,23 +60,121 @@ var (
 		"format-stashed",
 		"The string to use for the stashed changes indicator.",
 	).Default("@").OverrideDefaultFromEnvar("VCSINFO_STASHED").String()
+	formatConflicts = app.Flag(
+		"format-conflicts",
+		"The string to use for the conflicted files indicator.",
+	).Default("!").OverrideDefaultFromEnvar("VCSINFO_CONFLICTS").String()
 	formatUnknown = app.Flag(
 		"format-unknown",
 		"The string to use for format codes where no value could be determined.",
 	).Default("").OverrideDefaultFromEnvar("VCSINFO_UNKNOWN").String()
+	colorMode = app.Flag(
+		"color",
+		"How colors/styles in the format string are rendered (ansi, bash, zsh, tmux, none).",
+	).Default("ansi").OverrideDefaultFromEnvar("VCSINFO_COLOR").Enum("ansi", "bash", "zsh", "tmux", "none")
 	json = app.Flag(
 		"json",
-		"Renders the output in a JSON object (overrides --format).",
+		"Renders the output in a JSON object (overrides --format and --template).",
 	).Short('j').Bool()
 	xml = app.Flag(
 		"xml",
-		"Renders the output in an XML document (overrides --format).",
+		"Renders the output in an XML document (overrides --format and --template).",
 	).Short('x').Bool()
+	diffStat = app.Flag(
+		"diff-stat",
+		"Count the files and lines changed in the working copy, even if the format string doesn't use them.",
+	).OverrideDefaultFromEnvar("VCSINFO_DIFF_STAT").Bool()
+	probeNames = app.Flag(
+		"probes",
+		"The comma-separated names of the probes to use (e.g., git,hg). Names prefixed with - are excluded instead (e.g., -cvs).",
+	).OverrideDefaultFromEnvar("VCSINFO_PROBES").PlaceHolder("NAMES").String()
+	timeout = app.Flag(
+		"timeout",
+		"The maximum amount of time to spend retrieving VCS information (e.g., 500ms). Whatever was retrieved before the timeout is still output.",
+	).Default("0").OverrideDefaultFromEnvar("VCSINFO_TIMEOUT").Duration()
+	gitNative = app.Flag(
+		"git-native",
+		"Read the branch, hashes and stash of Git repositories directly rather than invoking the git command (which is still used for the status of the working tree).",
+	).OverrideDefaultFromEnvar("VCSINFO_GIT_NATIVE").Bool()
+	hgCompareUpstream = app.Flag(
+		"hg-compare-upstream",
+		"Count the changesets that Mercurial repositories are ahead of/behind their default path by, which contacts the repository it points to (possibly over the network).",
+	).OverrideDefaultFromEnvar("VCSINFO_HG_COMPARE_UPSTREAM").Bool()
+	jjIgnoreWorkingCopy = app.Flag(
+		"jj-ignore-working-copy",
+		"Don't let jj snapshot the working copy of Jujutsu repositories before examining them. This is faster in large repositories, but changes made since the last jj command won't be reported.",
+	).OverrideDefaultFromEnvar("VCSINFO_JJ_IGNORE_WORKING_COPY").Bool()
+	svnAskServer = app.Flag(
+		"svn-ask-server",
+		"Ask the Subversion server for the subject of the last commit (which isn't kept in the working copy), possibly over the network.",
+	).OverrideDefaultFromEnvar("VCSINFO_SVN_ASK_SERVER").Bool()
+	cvsAskServer = app.Flag(
+		"cvs-ask-server",
+		"Ask the CVS server for the author, time and subject of the last commit, possibly over the network, rather than only taking the time from the working copy.",
+	).OverrideDefaultFromEnvar("VCSINFO_CVS_ASK_SERVER").Bool()
+	p4AskServer = app.Flag(
+		"p4-ask-server",
+		"Recognize the root of the Perforce client named by $P4CLIENT as a workspace, by asking the server for it, even without a P4CONFIG file.",
+	).OverrideDefaultFromEnvar("VCSINFO_P4_ASK_SERVER").Bool()
+	configPath = app.Flag(
+		"config-file",
+		"The path to the configuration file (defaults to vcsinfo/config.toml in $XDG_CONFIG_HOME).",
+	).OverrideDefaultFromEnvar("VCSINFO_CONFIG").PlaceHolder("PATH").String()
 	noisy = app.Flag(
 		"noisy",
 		"If hard failures are encountered, complain loudly instead of silently outputting nothing.",
 	).Bool()
 
+	socketPath = app.Flag(
+		"socket",
+		"The path to the Unix socket used to communicate with the daemon (defaults to vcsinfo.sock in $XDG_RUNTIME_DIR).",
+	).OverrideDefaultFromEnvar("VCSINFO_SOCKET").PlaceHolder("PATH").String()
+	useDaemon = app.Flag(
+		"use-daemon",
+		"Retrieve VCS information from the daemon if it is running.",
+	).OverrideDefaultFromEnvar("VCSINFO_USE_DAEMON").Bool()
+
+	showCommand = app.Command(
+		"show",
+		"Output the VCS information for a path (the default).",
+	).Default()
+	scanCommand = app.Command(
+		"scan",
+		"Output the VCS information for every repository found within a directory.",
+	)
+	scanPath = scanCommand.Arg(
+		"dir",
+		"The directory to search for repositories (defaults to the current directory).",
+	).String()
+	scanJobs = scanCommand.Flag(
+		"jobs",
+		"The number of repositories to retrieve VCS information for at once.",
+	).Default(strconv.Itoa(runtime.NumCPU())).Int()
+
+	statusCommand = app.Command(
+		"status",
+		"Output the status of each changed file in the repository a path is in.",
+	)
+
+	watchCommand = app.Command(
+		"watch",
+		"Output the VCS information for a path, and again whenever it changes.",
+	)
+
+	configCommand = app.Command(
+		"config",
+		"Inspect the configuration of VCSInfo.",
+	)
+	configShowCommand = configCommand.Command(
+		"show",
+		"Output the effective configuration, and where each setting came from.",
+	)
+
+	daemonCommand = app.Command(
+		"daemon",
+		"Run a background process that caches VCS information for use with --use-daemon.",
+	)
+
 	probeFormats = make(map[string]*string)
 
 	helpFormatText = `
@@ -81,18 +187,93 @@ detected by VCSInfo in its output:
   %%r  Revision ID
   %%v  Short Hash, Revision ID, or Hash (whichever one that is found first is used)
   %%b  Branch
+  %%d  Branch, or a label derived from the nearest tag or short hash if there
+       is no branch checked out (e.g., a detached HEAD)
+  %%w  Workspace (e.g., the Perforce client)
+  %%T  Tags pointing at the current changeset (comma-separated)
+  %%l  Nearest tag in the ancestry of the current changeset
+  %%L  Number of changesets since the nearest tag
+  %%W  Author of the current changeset
+  %%E  Email address of the author of the current changeset
+  %%S  Subject (first line of the message) of the current changeset
+  %%D  Date and time of the current changeset (e.g., 2021-11-05 14:00:00 -0500)
+  %%g  Age of the current changeset (e.g., 3h ago)
+  %%U  Upstream branch
+  %%A  Number of changesets ahead of the upstream (omitted if zero)
+  %%B  Number of changesets behind the upstream (omitted if zero)
+  %%R  Owner and name of the remote repository (e.g., jayclassless/vcsinfo)
+  %%o  Operation in progress (merge, rebase, rebase-interactive, cherry-pick,
+       revert, bisect, am, graft, histedit, unshelve)
   %%u  Untracked files indicator
   %%a  Staged files indicator
   %%m  Modified files indicator
   %%t  Stashed changes indicator
+  %%c  Conflicted files indicator
+  %%F  Number of files changed in the working copy (omitted if zero)
+  %%+  Number of lines added in the working copy (omitted if zero)
+  %%-  Number of lines removed in the working copy (omitted if zero)
   %%P  Repository root directory
   %%p  Relative path to Repository root directory (relative to the analyzed path)
   %%e  Base name of the repository root directory
   %%%%  Literal "%%"
 
-If no format string is specified on the command line or via environment
-variables, then the following strings will be used, depending on which VCS is
-detected:
+Adding # to the %%u, %%a, %%m, %%t, and %%c codes (e.g., %%#m) outputs the number
+of files (or stashes) instead of the indicator string, and nothing if there
+are none.
+
+Parts of a format string can also be made conditional on the information that
+was found:
+
+  %%(...%%)          Group; only output if at least one of the codes within it
+                   has a value
+  %%?x(...%%)        Conditional; only output if code x has a value
+  %%?x(...%%|...%%)   Conditional; outputs the first part if code x has a value,
+                   and the second part otherwise
+
+Groups and conditionals can be nested. In conditionals, the code * can also be
+used to test whether the working copy has any untracked, staged, modified, or
+conflicted files.
+
+Colors and styles can be applied to the output using %%{...} directives that
+contain one or more of the following names, separated by commas:
+
+  black, red, green, yellow, blue, magenta, cyan, white, default
+  bright-<color>     Bright variant of a color
+  bg-<color>         Background color
+  bg-bright-<color>  Bright background color
+  bold, dim, italic, underline
+  reset              Resets all colors and styles
+
+For example, "%%?*(%%{red}%%|%%{green}%%)%%b%%{reset}" outputs the branch in red if
+the working copy has changes, and in green otherwise. The --color option
+controls how the directives are rendered: as raw ANSI escape sequences (ansi),
+as ANSI escape sequences wrapped so that they do not count towards the width of
+bash (bash, using the \001 and \002 markers that readline honors in the output
+of $(vcsinfo) in PS1) or zsh (zsh) prompts, as tmux status line styles (tmux),
+or not at all (none).
+
+For layouts that are beyond what format strings can express, the --template
+option accepts a Go text/template (see https://pkg.go.dev/text/template) that
+is executed against the VCS information. The fields available are those output
+by --json, in their Go form (e.g., {{.Branch}}, {{.HasModified}}), and the
+following functions can be used in addition to the standard ones:
+
+  truncate N S  S shortened to at most N characters
+  default D V   V, or D if V is empty
+  pad N S       S padded with spaces to N characters (right-aligned if N is
+                negative)
+  upper S       S in upper case
+  color SPEC    Applies the comma-separated colors/styles in SPEC (e.g.,
+                "bold,red"), as rendered according to the --color option
+  relpath P     P relative to the repository root
+
+For example:
+
+  {{color "blue"}}{{.Branch | default "?" | truncate 20}}{{if .HasModified}} M{{end}}{{color "reset"}}
+
+If no format string is specified on the command line, via environment
+variables, or in the configuration file, then the following strings will be
+used, depending on which VCS is detected:
 
 %s
 `
@@ -104,6 +285,10 @@ VCSInfo:
     The format string to use to generate output (if not explicitly specified
     via the command line).
 
+  VCSINFO_TEMPLATE
+    The Go text/template to use to generate output (if not explicitly
+    specified via the command line).
+
   VCSINFO_UNTRACKED
     The string to use for the untracked files indicator.
 
@@ -116,19 +301,87 @@ VCSInfo:
   VCSINFO_STASHED
     The string to used for the stashed changes indicator.
 
+  VCSINFO_CONFLICTS
+    The string to use for the conflicted files indicator.
+
   VCSINFO_UNKNOWN
-    The string to use for the %%h/%%s/%%r/%%s/%%v/%%b tokens if they could
-    not be determined. Defaults to "".
+    The string to use for the %%h/%%s/%%r/%%s/%%v/%%b/%%d/%%l/%%W/%%E/%%S/%%D/%%g/
+    %%U/%%R tokens if they could not be determined. Defaults to "".
+
+  VCSINFO_COLOR
+    How colors/styles in the format string are rendered (ansi, bash, zsh,
+    tmux, none). Defaults to "ansi".
+
+  VCSINFO_PROBES
+    The comma-separated names of the probes to use (e.g., git,hg). Names
+    prefixed with - are excluded instead (e.g., -cvs,-darcs). Defaults to all
+    available probes.
+
+  VCSINFO_DIFF_STAT
+    If set to "true", the files and lines changed in the working copy are
+    counted even if the format string doesn't use them (e.g., for --json).
+
+  VCSINFO_TIMEOUT
+    The maximum amount of time to spend retrieving VCS information (e.g.,
+    500ms). Defaults to no limit.
+
+  VCSINFO_GIT_NATIVE
+    If set to "true", the branch, hashes and stash of Git repositories are
+    read directly rather than by invoking the git command (which is still
+    used for the status of the working tree).
+
+  VCSINFO_HG_COMPARE_UPSTREAM
+    If set to "true", the changesets that Mercurial repositories are ahead of
+    and behind their default path by are counted (which contacts the
+    repository it points to, possibly over the network).
+
+  VCSINFO_JJ_IGNORE_WORKING_COPY
+    If set to "true", jj isn't allowed to snapshot the working copy of Jujutsu
+    repositories, so changes made since the last jj command aren't reported.
+
+  VCSINFO_SVN_ASK_SERVER
+    If set to "true", the Subversion server is asked for the subject of the
+    last commit (which can mean a network round trip for every prompt).
+
+  VCSINFO_CVS_ASK_SERVER
+    If set to "true", the CVS server is asked for the author, time and subject
+    of the last commit (which can mean a network round trip for every prompt),
+    rather than only taking the time from the working copy.
+
+  VCSINFO_P4_ASK_SERVER
+    If set to "true", the root of the Perforce client named by $P4CLIENT is
+    recognized as a workspace (by asking the server for it) even if it doesn't
+    contain a P4CONFIG file.
+
+  VCSINFO_USE_DAEMON
+    If set to "true", VCS information is retrieved from the daemon (see
+    "vcsinfo daemon") if it is running.
+
+  VCSINFO_SOCKET
+    The path to the Unix socket used to communicate with the daemon. Defaults
+    to vcsinfo.sock in $XDG_RUNTIME_DIR (or in a private vcsinfo-<uid>
+    directory in the temporary directory).
+
+  VCSINFO_CONFIG
+    The path to the configuration file. Defaults to vcsinfo/config.toml in
+    $XDG_CONFIG_HOME (or ~/.config). Settings in the configuration file are
+    overridden by the environment variables above.
+
+  VCSINFO_PLUGIN_DIR
+    The directory to search for external probes (executables named
+    vcsinfo-probe-*). Defaults to vcsinfo/plugins in $XDG_CONFIG_HOME (or
+    ~/.config).
+
+  VCSINFO_PLUGIN_PATH
+    If set to "true", external probes are also searched for in $PATH.
 
 %s
 `
 )
 
-func determinePath() (string, error) {
-	var path string
+func determinePath(path string) (string, error) {
 	var err error
 
-	path = *targetPath
 	if path == "" {
 		path, err = os.Getwd()
 		if err != nil {
@@ -158,6 +411,18 @@ func determinePath() (string, error) {
 	return path, nil
 }
 
+func makeFormatOptions() vcsinfo.FormatOptions {
+	options := vcsinfo.GetDefaultFormatOptions()
+	options.HasNew = *formatUntracked
+	options.HasModified = *formatModified
+	options.HasStaged = *formatStaged
+	options.HasStashed = *formatStashed
+	options.HasConflicts = *formatConflicts
+	options.Unknown = *formatUnknown
+	options.ColorMode = vcsinfo.ColorMode(*colorMode)
+	return options
+}
+
 func produceOutput(info vcsinfo.VcsInfo, probe vcsinfo.VcsProbe) (string, error) {
 	if *json {
 		return vcsinfo.InfoToJSON(info)
@@ -166,6 +431,16 @@ func produceOutput(info vcsinfo.VcsInfo, probe vcsinfo.VcsProbe) (string, error)
 		return vcsinfo.InfoToXML(info)
 	}
 
+	options := makeFormatOptions()
+
+	if *outputTemplate != "" {
+		return vcsinfo.InfoToTemplate(info, *outputTemplate, options)
+	}
+
+	return vcsinfo.InfoToString(info, determineFormat(probe), options)
+}
+
+func determineFormat(probe vcsinfo.VcsProbe) string {
 	f := *probeFormats[probe.Name()]
 	if f == "" {
 		f = *format
@@ -173,15 +448,57 @@ func produceOutput(info vcsinfo.VcsInfo, probe vcsinfo.VcsProbe) (string, error)
 			f = probe.DefaultFormat()
 		}
 	}
+	return f
+}
 
-	options := vcsinfo.GetDefaultFormatOptions()
-	options.HasNew = *formatUntracked
-	options.HasModified = *formatModified
-	options.HasStaged = *formatStaged
-	options.HasStashed = *formatStashed
-	options.Unknown = *formatUnknown
+// requiredFields determines which of the VcsInfo fields the output for the
+// probe's repositories needs, so the rest don't have to be gathered.
+func requiredFields(probe vcsinfo.VcsProbe) vcsinfo.FieldMask {
+	if *json || *xml {
+		return vcsinfo.FieldAll
+	}
 
-	return vcsinfo.InfoToString(info, f, options)
+	var fields vcsinfo.FieldMask
+	var err error
+	if *outputTemplate != "" {
+		fields, err = vcsinfo.TemplateFields(*outputTemplate)
+	} else {
+		fields, err = vcsinfo.FormatFields(determineFormat(probe))
+	}
+	if err != nil {
+		// Let the rendering report the problem.
+		return vcsinfo.FieldAll
+	}
+	return fields
+}
+
+// wantsDiffStat indicates whether or not the output for the probe's
+// repositories needs the DiffStat, which is too expensive to always gather.
+func wantsDiffStat(probe vcsinfo.VcsProbe) bool {
+	if *diffStat {
+		return true
+	}
+	if *json || *xml {
+		return false
+	}
+
+	return requiredFields(probe)&vcsinfo.FieldDiffStat != 0
+}
+
+// addDiffStat gathers the DiffStat for the VcsInfo if the output needs it and
+// the probe is capable of producing it.
+func addDiffStat(ctx context.Context, probe vcsinfo.VcsProbe, path string, info *vcsinfo.VcsInfo) error {
+	diffProbe, ok := probe.(vcsinfo.DiffStatProbe)
+	if !ok || !wantsDiffStat(probe) {
+		return nil
+	}
+
+	stat, err := diffProbe.DiffStatContext(ctx, path)
+	if err != nil {
+		return err
+	}
+	info.DiffStat = &stat
+	return nil
 }
 
 func failIfError(err error, message string) {
@@ -253,7 +570,7 @@ func main() {
 
 	app.Version(version)
 	app.HelpFlag.Short('h')
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 
 	if *helpFormat {
 		fmt.Println(strings.TrimSpace(
@@ -268,19 +585,161 @@ func main() {
 		os.Exit(0)
 	}
 
-	path, err := determinePath()
-	failIfError(err, "Could not find path to analyze")
+	target := *targetPath
+	if command == scanCommand.FullCommand() {
+		target = *scanPath
+	}
+	target, err = determinePath(target)
+	if err != nil {
+		// Directory-specific settings can't apply; the command will complain
+		// about the path itself.
+		target = ""
+	}
+	sources, err := configureFromFile(target, allProbes)
+	failIfError(err, "Could not load configuration file")
+
+	probes := vcsinfo.SelectProbes(allProbes, vcsinfo.ParseProbeOptions(*probeNames))
+
+	if *gitNative {
+		for idx, probe := range probes {
+			if _, ok := probe.(vcsinfo.GitProbe); ok {
+				probes[idx] = vcsinfo.GitProbe{Native: true}
+			}
+		}
+	}
+
+	if *hgCompareUpstream {
+		for idx, probe := range probes {
+			if _, ok := probe.(vcsinfo.HgProbe); ok {
+				probes[idx] = vcsinfo.HgProbe{CompareUpstream: true}
+			}
+		}
+	}
+
+	if *jjIgnoreWorkingCopy {
+		for idx, probe := range probes {
+			if _, ok := probe.(vcsinfo.JjProbe); ok {
+				probes[idx] = vcsinfo.JjProbe{IgnoreWorkingCopy: true}
+			}
+		}
+	}
+
+	if *svnAskServer {
+		for idx, probe := range probes {
+			if _, ok := probe.(vcsinfo.SvnProbe); ok {
+				probes[idx] = vcsinfo.SvnProbe{AskServer: true}
+			}
+		}
+	}
+
+	if *cvsAskServer {
+		for idx, probe := range probes {
+			if _, ok := probe.(vcsinfo.CvsProbe); ok {
+				probes[idx] = vcsinfo.CvsProbe{AskServer: true}
+			}
+		}
+	}
+
+	if *p4AskServer {
+		for idx, probe := range probes {
+			if _, ok := probe.(vcsinfo.P4Probe); ok {
+				probes[idx] = vcsinfo.P4Probe{AskServer: true, AskServerTimeout: *timeout}
+			}
+		}
+	}
+
+	switch command {
+	case configShowCommand.FullCommand():
+		showConfig(allProbes, probes, sources)
+	case daemonCommand.FullCommand():
+		runDaemon(probes)
+	case watchCommand.FullCommand():
+		watch(probes)
+	case statusCommand.FullCommand():
+		status(probes)
+	case scanCommand.FullCommand():
+		scan(probes)
+	case showCommand.FullCommand():
+		show(probes)
+	}
+}
+
+func gatherInfo(path string, probes []vcsinfo.VcsProbe) (vcsinfo.VcsProbe, vcsinfo.VcsInfo, []error) {
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if *useDaemon {
+		response, err := queryDaemon(determineSocketPath(), path, *timeout)
 
-	probe, err := vcsinfo.FindProbeForPath(path, allProbes)
+		// A response without any information means the daemon couldn't
+		// handle the path (e.g., the repository couldn't be watched), so
+		// the information is gathered here instead.
+		if err == nil && response.Probe != "" && response.Info.VcsName == "" {
+			err = fmt.Errorf("the daemon did not return any information for %s", path)
+		}
+
+		if err == nil {
+			var errs []error
+			for _, msg := range response.Errors {
+				errs = append(errs, errors.New(msg))
+			}
+			if response.TimedOut {
+				errs = append(errs, context.DeadlineExceeded)
+			}
+
+			if response.Probe == "" {
+				return nil, response.Info, errs
+			}
+			for _, probe := range probes {
+				if probe.Name() == response.Probe {
+					if err := addDiffStat(ctx, probe, path, &response.Info); err != nil {
+						errs = append(errs, err)
+					}
+					return probe, response.Info, errs
+				}
+			}
+		}
+	}
+
+	probe, err := vcsinfo.FindProbeForPath(path, probes)
 	failIfError(err, "Failure detecting VCS")
+	if probe == nil {
+		return nil, vcsinfo.VcsInfo{}, nil
+	}
+
+	info, errs := vcsinfo.GatherInfoWithFields(ctx, probe, path, requiredFields(probe))
+	if err := addDiffStat(ctx, probe, path, &info); err != nil {
+		errs = append(errs, err)
+	}
+	return probe, info, errs
+}
+
+func show(allProbes []vcsinfo.VcsProbe) {
+	path, err := determinePath(*targetPath)
+	failIfError(err, "Could not find path to analyze")
 
+	probe, info, errs := gatherInfo(path, allProbes)
 	if probe != nil {
-		info, errs := probe.GatherInfo(path)
 		if *noisy && len(errs) > 0 {
+			failed, timedOut := false, false
 			for _, err := range errs {
+				if err == context.DeadlineExceeded {
+					timedOut = true
+					continue
+				}
 				app.Errorf("%s", err)
+				failed = true
+			}
+			if failed {
+				app.Fatalf("Failure retrieving VCS information")
+			}
+			if timedOut {
+				app.Errorf("Timed out retrieving VCS information; output may be incomplete")
 			}
-			app.Fatalf("Failure retrieving VCS information")
 		}
 
 		output, err := produceOutput(info, probe)
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jayclassless/vcsinfo"
+)
+
+func watch(allProbes []vcsinfo.VcsProbe) {
+	path, err := determinePath(*targetPath)
+	failIfError(err, "Could not find path to analyze")
+
+	probe, err := vcsinfo.FindProbeForPath(path, allProbes)
+	failIfError(err, "Failure detecting VCS")
+	if probe == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	events, err := vcsinfo.WatchProbe(ctx, probe, path)
+	failIfError(err, "Could not watch for changes")
+
+	previous := ""
+	for event := range events {
+		if err := addDiffStat(ctx, probe, path, &event.Info); err != nil {
+			event.Errors = append(event.Errors, err)
+		}
+
+		if *noisy {
+			for _, err := range event.Errors {
+				app.Errorf("%s", err)
+			}
+		}
+
+		output, err := produceOutput(event.Info, probe)
+		failIfError(err, "Failure producing output")
+
+		// Only output something when there's something new to say.
+		if output != previous {
+			fmt.Println(output)
+			previous = output
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package vcsinfo
+
+import (
+	"strconv"
+	"strings"
+)
+
+// style is a single style that can be applied via a %{...} directive, as
+// expressed in the ANSI and tmux dialects.
+type style struct {
+	ansi string
+	tmux string
+}
+
+var colorNames = []string{
+	"black",
+	"red",
+	"green",
+	"yellow",
+	"blue",
+	"magenta",
+	"cyan",
+	"white",
+}
+
+var styles = makeStyles()
+
+func makeStyles() map[string]style {
+	styles := map[string]style{
+		"reset":      {ansi: "0", tmux: "default"},
+		"bold":       {ansi: "1", tmux: "bold"},
+		"dim":        {ansi: "2", tmux: "dim"},
+		"italic":     {ansi: "3", tmux: "italics"},
+		"underline":  {ansi: "4", tmux: "underscore"},
+		"default":    {ansi: "39", tmux: "fg=default"},
+		"bg-default": {ansi: "49", tmux: "bg=default"},
+	}
+
+	for idx, name := range colorNames {
+		styles[name] = style{
+			ansi: strconv.Itoa(30 + idx),
+			tmux: "fg=" + name,
+		}
+		styles["bright-"+name] = style{
+			ansi: strconv.Itoa(90 + idx),
+			tmux: "fg=bright" + name,
+		}
+		styles["bg-"+name] = style{
+			ansi: strconv.Itoa(40 + idx),
+			tmux: "bg=" + name,
+		}
+		styles["bg-bright-"+name] = style{
+			ansi: strconv.Itoa(100 + idx),
+			tmux: "bg=bright" + name,
+		}
+	}
+
+	return styles
+}
+
+func isValidColorMode(mode ColorMode) bool {
+	if mode == "" {
+		return true
+	}
+	for _, valid := range ColorModes {
+		if mode == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// renderStyles produces the escape sequence/directive that applies the
+// specified styles in the specified mode.
+func renderStyles(names []string, mode ColorMode) string {
+	if mode == ColorModeNone {
+		return ""
+	}
+
+	codes := make([]string, len(names))
+	for idx, name := range names {
+		if mode == ColorModeTmux {
+			codes[idx] = styles[name].tmux
+		} else {
+			codes[idx] = styles[name].ansi
+		}
+	}
+
+	if mode == ColorModeTmux {
+		return "#[" + strings.Join(codes, ",") + "]"
+	}
+
+	sequence := "\x1b[" + strings.Join(codes, ";") + "m"
+	switch mode {
+	case ColorModeBash:
+		// Bash only honors \[ and \] when they're written in PS1 itself, not
+		// in the output of commands it runs, so the markers readline uses for
+		// them are output instead.
+		return "\x01" + sequence + "\x02"
+	case ColorModeZsh:
+		return "%{" + sequence + "%}"
+	}
+	return sequence
+}
@@ -1,14 +1,12 @@
 package vcsinfo
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
-	"path"
 	"path/filepath"
-	"strings"
+	"time"
 )
 
 // VcsInfo contains the results of a VcsProbe's examination of a repository.
@@ -35,6 +33,77 @@ type VcsInfo struct {
 	// The current branch.
 	Branch string `json:"branch" xml:"branch"`
 
+	// Indicates whether or not the working copy is not on any branch (e.g.,
+	// a detached HEAD in Git).
+	Detached bool `json:"detached" xml:"detached"`
+
+	// A label for the current changeset when the working copy is detached,
+	// derived from the nearest tag or the short hash.
+	DetachedLabel string `json:"detached_label" xml:"detachedLabel"`
+
+	// The name of the workspace the working copy belongs to, for VCSs that
+	// have such a concept (e.g., the client in Perforce).
+	Workspace string `json:"workspace" xml:"workspace"`
+
+	// The tags that point at the current changeset.
+	Tags []string `json:"tags" xml:"tags>tag"`
+
+	// The nearest tag found in the ancestry of the current changeset.
+	NearestTag string `json:"nearest_tag" xml:"nearestTag"`
+
+	// The number of changesets between the nearest tag and the current
+	// changeset.
+	NearestTagDistance int `json:"nearest_tag_distance" xml:"nearestTagDistance"`
+
+	// The name of the author of the current changeset.
+	CommitAuthor string `json:"commit_author" xml:"commitAuthor"`
+
+	// The email address of the author of the current changeset.
+	CommitEmail string `json:"commit_email" xml:"commitEmail"`
+
+	// When the current changeset was committed.
+	CommitTime time.Time `json:"commit_time" xml:"commitTime"`
+
+	// The first line of the message of the current changeset.
+	CommitSubject string `json:"commit_subject" xml:"commitSubject"`
+
+	// The name of the upstream that the current branch is compared against
+	// (e.g., "origin/master"), if one is configured.
+	Upstream string `json:"upstream" xml:"upstream"`
+
+	// The number of changesets in the current branch that are not in the
+	// upstream.
+	Ahead int `json:"ahead" xml:"ahead"`
+
+	// The number of changesets in the upstream that are not in the current
+	// branch.
+	Behind int `json:"behind" xml:"behind"`
+
+	// The name of the remote repository that this one is associated with
+	// (e.g., the remote the changesets are pushed to and pulled from).
+	RemoteName string `json:"remote_name" xml:"remoteName"`
+
+	// The URL of the remote repository.
+	RemoteURL string `json:"remote_url" xml:"remoteUrl"`
+
+	// The hosting provider the remote repository is on, if it is a known one.
+	// See the Provider* constants.
+	RemoteProvider string `json:"remote_provider" xml:"remoteProvider"`
+
+	// The host the remote repository is on.
+	RemoteHost string `json:"remote_host" xml:"remoteHost"`
+
+	// The owner (e.g., user, organization, or group) of the remote
+	// repository.
+	RemoteOwner string `json:"remote_owner" xml:"remoteOwner"`
+
+	// The name of the remote repository.
+	RemoteRepo string `json:"remote_repo" xml:"remoteRepo"`
+
+	// The multi-step operation (e.g., a merge or rebase) that is currently in
+	// progress in the repository, if any. See the Operation* constants.
+	Operation string `json:"operation" xml:"operation"`
+
 	// Indicates whether or not there are files staged for commit.
 	HasStaged bool `json:"has_staged" xml:"hasStaged"`
 
@@ -46,6 +115,83 @@ type VcsInfo struct {
 
 	// Indicates whether or not there are stashed changes.
 	HasStashed bool `json:"has_stashed" xml:"hasStashed"`
+
+	// Indicates whether or not there are files with unresolved conflicts.
+	HasConflicts bool `json:"has_conflicts" xml:"hasConflicts"`
+
+	// Indicates whether or not the current changeset doesn't change anything,
+	// for VCSs where the working copy is a changeset of its own (e.g., the
+	// working-copy commit in Jujutsu).
+	EmptyChangeset bool `json:"empty_changeset" xml:"emptyChangeset"`
+
+	// The number of files staged for commit.
+	StagedCount int `json:"staged_count" xml:"stagedCount"`
+
+	// The number of added/modified/deleted files.
+	ModifiedCount int `json:"modified_count" xml:"modifiedCount"`
+
+	// The number of untracked files.
+	UntrackedCount int `json:"untracked_count" xml:"untrackedCount"`
+
+	// The number of files with unresolved conflicts.
+	ConflictCount int `json:"conflict_count" xml:"conflictCount"`
+
+	// The number of sets of stashed changes.
+	StashCount int `json:"stash_count" xml:"stashCount"`
+
+	// A summary of the differences between the working copy and the current
+	// changeset. This is only present if it was requested, as it's expensive
+	// to produce (see DiffStatProbe).
+	DiffStat *DiffStat `json:"diff_stat" xml:"diffStat"`
+}
+
+// The operations that can be reported in VcsInfo.Operation.
+const (
+	OperationMerge             = "merge"
+	OperationRebase            = "rebase"
+	OperationRebaseInteractive = "rebase-interactive"
+	OperationCherryPick        = "cherry-pick"
+	OperationRevert            = "revert"
+	OperationBisect            = "bisect"
+	OperationAm                = "am"
+	OperationGraft             = "graft"
+	OperationHistedit          = "histedit"
+	OperationUnshelve          = "unshelve"
+)
+
+// ColorMode determines how the style directives in format strings (e.g.,
+// %{red}) are rendered.
+type ColorMode string
+
+// The modes that can be used in FormatOptions.ColorMode.
+const (
+	// ColorModeANSI renders styles as raw ANSI escape sequences.
+	ColorModeANSI ColorMode = "ansi"
+
+	// ColorModeBash renders styles as ANSI escape sequences wrapped in \001
+	// and \002 (the markers bash's \[ and \] are turned into) so that bash
+	// does not count them towards the width of the prompt when they are
+	// output by a command substitution in PS1.
+	ColorModeBash ColorMode = "bash"
+
+	// ColorModeZsh renders styles as ANSI escape sequences wrapped in %{ %}
+	// so that zsh does not count them towards the width of the prompt.
+	ColorModeZsh ColorMode = "zsh"
+
+	// ColorModeTmux renders styles as tmux #[...] style directives.
+	ColorModeTmux ColorMode = "tmux"
+
+	// ColorModeNone omits styles from the output entirely.
+	ColorModeNone ColorMode = "none"
+)
+
+// ColorModes lists all of the supported ColorMode values.
+var ColorModes = []ColorMode{
+	ColorModeANSI,
+	ColorModeBash,
+	ColorModeZsh,
+	ColorModeTmux,
+	ColorModeNone,
 }
 
 // FormatOptions contains the options that govern how format strings are
@@ -63,9 +209,15 @@ type FormatOptions struct {
 	// The string displayed for the stashed changes indicator.
 	HasStashed string
 
+	// The string displayed for the conflicted files indicator.
+	HasConflicts string
+
 	// The string displayed for hash/rev/branch tokens when the information
 	// they represent could not be found.
 	Unknown string
+
+	// How style directives are rendered. If empty, ColorModeANSI is used.
+	ColorMode ColorMode
 }
 
 // VcsProbe represents a probe that is capable of examining the current state
@@ -89,24 +241,27 @@ type VcsProbe interface {
 	// GatherInfo extracts and returns VCS information for the repository at
 	// the specified path.
 	GatherInfo(path string) (VcsInfo, []error)
+
+	// GatherInfoContext extracts and returns VCS information for the
+	// repository at the specified path, abandoning any outstanding work when
+	// the context expires. The information gathered up to that point is
+	// still returned.
+	GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error)
 }
 
-// GetAvailableProbes returns all probes in the VCSInfo package that can used
-// in the current environment.
+// GetAvailableProbes returns all registered probes that can used in the
+// current environment, followed by the plugins found in PluginDirs.
 func GetAvailableProbes() ([]VcsProbe, error) {
-	allProbes := []VcsProbe{
-		GitProbe{},
-		HgProbe{},
-		SvnProbe{},
-		BzrProbe{},
-		FossilProbe{},
-		DarcsProbe{},
-		CvsProbe{},
-	}
+	return GetAvailableProbesWithOptions(ProbeOptions{})
+}
 
+// GetAvailableProbesWithOptions returns the registered probes that are
+// permitted by the options and can be used in the current environment,
+// followed by the permitted plugins found in PluginDirs.
+func GetAvailableProbesWithOptions(options ProbeOptions) ([]VcsProbe, error) {
 	availableProbes := []VcsProbe{}
 
-	for _, probe := range allProbes {
+	for _, probe := range SelectProbes(Probes(), options) {
 		available, err := probe.IsAvailable()
 		if err != nil {
 			return nil, err
@@ -116,6 +271,11 @@ func GetAvailableProbes() ([]VcsProbe, error) {
 		}
 	}
 
+	if !options.NoPlugins {
+		plugins := SelectProbes(FindPluginProbes(PluginDirs()), options)
+		availableProbes = append(availableProbes, plugins...)
+	}
+
 	return availableProbes, nil
 }
 
@@ -169,107 +329,28 @@ func InfoToXML(info VcsInfo) (string, error) {
 // configuration.
 func GetDefaultFormatOptions() FormatOptions {
 	return FormatOptions{
-		HasStaged:   "*",
-		HasModified: "+",
-		HasNew:      "?",
-		HasStashed:  "@",
-		Unknown:     "",
+		HasStaged:    "*",
+		HasModified:  "+",
+		HasNew:       "?",
+		HasStashed:   "@",
+		HasConflicts: "!",
+		Unknown:      "",
+		ColorMode:    ColorModeANSI,
 	}
 }
 
 // InfoToString renders the VcsInfo according the specified format string and
-// options.
+// options. If the format string is malformed, a *FormatError is returned.
 func InfoToString(info VcsInfo, format string, options FormatOptions) (string, error) {
-	var buf bytes.Buffer
-	var eof rune
-
-	reader := bufio.NewReader(strings.NewReader(format))
-
-	sou := func(str string) string {
-		if str == "" {
-			return options.Unknown
-		}
-		return str
+	if !isValidColorMode(options.ColorMode) {
+		return "", fmt.Errorf("unknown color mode %q", options.ColorMode)
 	}
 
-	for {
-		char, _, _ := reader.ReadRune()
-		if char == eof {
-			break
-		}
-
-		if char != '%' {
-			buf.WriteString(string(char))
-			continue
-		}
-
-		char, _, _ = reader.ReadRune()
-		switch char {
-		case 'n':
-			buf.WriteString(info.VcsName)
-
-		case 'h':
-			buf.WriteString(sou(info.Hash))
-
-		case 's':
-			buf.WriteString(sou(info.ShortHash))
-
-		case 'r':
-			buf.WriteString(sou(info.Revision))
-
-		case 'v':
-			out := options.Unknown
-			if info.ShortHash != "" {
-				buf.WriteString(info.ShortHash)
-			} else if info.Revision != "" {
-				buf.WriteString(info.Revision)
-			} else if info.Hash != "" {
-				buf.WriteString(info.Hash)
-			}
-			buf.WriteString(out)
-
-		case 'b':
-			buf.WriteString(sou(info.Branch))
-
-		case 'u':
-			if info.HasNew {
-				buf.WriteString(options.HasNew)
-			}
-
-		case 'a':
-			if info.HasStaged {
-				buf.WriteString(options.HasStaged)
-			}
-
-		case 'm':
-			if info.HasModified {
-				buf.WriteString(options.HasModified)
-			}
-
-		case 't':
-			if info.HasStashed {
-				buf.WriteString(options.HasStashed)
-			}
-
-		case 'P':
-			buf.WriteString(info.RepositoryRoot)
-
-		case 'p':
-			relPath, err := filepath.Rel(info.RepositoryRoot, info.Path)
-			if err == nil {
-				buf.WriteString(relPath)
-			}
-
-		case 'e':
-			buf.WriteString(path.Base(info.RepositoryRoot))
-
-		case '%':
-			buf.WriteString("%")
-
-		default:
-			return "", fmt.Errorf("unexpected formatting code \"%%%s\"", string(char))
-		}
+	nodes, err := parseFormat(format)
+	if err != nil {
+		return "", err
 	}
 
-	return buf.String(), nil
+	out, _ := renderFormat(nodes, info, options)
+	return out, nil
 }
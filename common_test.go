@@ -1,6 +1,8 @@
 package vcsinfo_test
 
 import (
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -12,7 +14,7 @@ var _ = Describe("Public API", func() {
 		It("returns all probes", func() {
 			probes, err := GetAvailableProbes()
 			Expect(err).To(BeNil())
-			Expect(probes).To(HaveLen(7))
+			Expect(probes).To(HaveLen(10))
 		})
 	})
 
@@ -89,7 +91,17 @@ var _ = Describe("Public API", func() {
 			}
 			actual, err := InfoToJSON(info)
 			Expect(err).To(BeNil())
-			Expect(actual).To(Equal(`{"vcs_name":"fake","path":"/foo/bar","repository_root":"/foo","short_hash":"","hash":"abc123","revision":"","branch":"","has_staged":false,"has_modified":true,"has_new":false,"has_stashed":false}`))
+			Expect(actual).To(Equal(`{"vcs_name":"fake","path":"/foo/bar","repository_root":"/foo","short_hash":"","hash":"abc123","revision":"","branch":"","detached":false,"detached_label":"","workspace":"","tags":null,"nearest_tag":"","nearest_tag_distance":0,"commit_author":"","commit_email":"","commit_time":"0001-01-01T00:00:00Z","commit_subject":"","upstream":"","ahead":0,"behind":0,"remote_name":"","remote_url":"","remote_provider":"","remote_host":"","remote_owner":"","remote_repo":"","operation":"","has_staged":false,"has_modified":true,"has_new":false,"has_stashed":false,"has_conflicts":false,"empty_changeset":false,"staged_count":0,"modified_count":0,"untracked_count":0,"conflict_count":0,"stash_count":0,"diff_stat":null}`))
+		})
+
+		It("renders tags", func() {
+			info := VcsInfo{
+				Tags:       []string{"v1.0", "stable"},
+				NearestTag: "v1.0",
+			}
+			actual, err := InfoToJSON(info)
+			Expect(err).To(BeNil())
+			Expect(actual).To(ContainSubstring(`"tags":["v1.0","stable"],"nearest_tag":"v1.0","nearest_tag_distance":0`))
 		})
 	})
 
@@ -104,7 +116,76 @@ var _ = Describe("Public API", func() {
 			}
 			actual, err := InfoToXML(info)
 			Expect(err).To(BeNil())
-			Expect(actual).To(Equal("<VcsInfo><vcsName>fake</vcsName><path>/foo/bar</path><repositoryRoot>/foo</repositoryRoot><shortHash></shortHash><hash>abc123</hash><revision></revision><branch></branch><hasStaged>false</hasStaged><hasModified>true</hasModified><hasNew>false</hasNew><hasStashed>false</hasStashed></VcsInfo>"))
+			Expect(actual).To(Equal("<VcsInfo><vcsName>fake</vcsName><path>/foo/bar</path><repositoryRoot>/foo</repositoryRoot><shortHash></shortHash><hash>abc123</hash><revision></revision><branch></branch><detached>false</detached><detachedLabel></detachedLabel><workspace></workspace><tags></tags><nearestTag></nearestTag><nearestTagDistance>0</nearestTagDistance><commitAuthor></commitAuthor><commitEmail></commitEmail><commitTime>0001-01-01T00:00:00Z</commitTime><commitSubject></commitSubject><upstream></upstream><ahead>0</ahead><behind>0</behind><remoteName></remoteName><remoteUrl></remoteUrl><remoteProvider></remoteProvider><remoteHost></remoteHost><remoteOwner></remoteOwner><remoteRepo></remoteRepo><operation></operation><hasStaged>false</hasStaged><hasModified>true</hasModified><hasNew>false</hasNew><hasStashed>false</hasStashed><hasConflicts>false</hasConflicts><emptyChangeset>false</emptyChangeset><stagedCount>0</stagedCount><modifiedCount>0</modifiedCount><untrackedCount>0</untrackedCount><conflictCount>0</conflictCount><stashCount>0</stashCount></VcsInfo>"))
+		})
+
+		It("renders tags", func() {
+			info := VcsInfo{
+				Tags:       []string{"v1.0", "stable"},
+				NearestTag: "v1.0",
+			}
+			actual, err := InfoToXML(info)
+			Expect(err).To(BeNil())
+			Expect(actual).To(ContainSubstring("<tags><tag>v1.0</tag><tag>stable</tag></tags><nearestTag>v1.0</nearestTag><nearestTagDistance>0</nearestTagDistance>"))
+		})
+	})
+
+	Describe("FileStatusProbe", func() {
+		It("is implemented by all probes", func() {
+			probes := []VcsProbe{JjProbe{}, SaplingProbe{}, GitProbe{}, HgProbe{}, SvnProbe{}, BzrProbe{}, FossilProbe{}, DarcsProbe{}, CvsProbe{}, P4Probe{}}
+			for _, probe := range probes {
+				_, ok := probe.(FileStatusProbe)
+				Expect(ok).To(BeTrue(), probe.Name())
+			}
+		})
+	})
+
+	Describe("DiffStatProbe", func() {
+		It("is implemented by all probes", func() {
+			probes := []VcsProbe{JjProbe{}, SaplingProbe{}, GitProbe{}, HgProbe{}, SvnProbe{}, BzrProbe{}, FossilProbe{}, DarcsProbe{}, CvsProbe{}, P4Probe{}}
+			for _, probe := range probes {
+				_, ok := probe.(DiffStatProbe)
+				Expect(ok).To(BeTrue(), probe.Name())
+			}
+		})
+	})
+
+	Describe("FieldMaskProbe", func() {
+		It("is implemented by all probes", func() {
+			probes := []VcsProbe{JjProbe{}, SaplingProbe{}, GitProbe{}, HgProbe{}, SvnProbe{}, BzrProbe{}, FossilProbe{}, DarcsProbe{}, CvsProbe{}, P4Probe{}}
+			for _, probe := range probes {
+				_, ok := probe.(FieldMaskProbe)
+				Expect(ok).To(BeTrue(), probe.Name())
+			}
+		})
+	})
+
+	Describe("FilesToJSON", func() {
+		It("renders to string", func() {
+			files := []FileStatus{
+				{Path: "foo", Index: FileUnmodified, Worktree: FileModified},
+				{Path: "bar", Index: FileRenamed, Worktree: FileUnmodified, OriginalPath: "baz"},
+			}
+			actual, err := FilesToJSON(files)
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal(`[{"path":"foo","index":"unmodified","worktree":"modified","original_path":""},{"path":"bar","index":"renamed","worktree":"unmodified","original_path":"baz"}]`))
+		})
+
+		It("renders an empty array", func() {
+			actual, err := FilesToJSON(nil)
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("[]"))
+		})
+	})
+
+	Describe("FilesToXML", func() {
+		It("renders to string", func() {
+			files := []FileStatus{
+				{Path: "foo", Index: FileUnmodified, Worktree: FileModified},
+			}
+			actual, err := FilesToXML(files)
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("<files><file><path>foo</path><index>unmodified</index><worktree>modified</worktree><originalPath></originalPath></file></files>"))
 		})
 	})
 
@@ -118,13 +199,129 @@ var _ = Describe("Public API", func() {
 				ShortHash:      "xyz",
 				Revision:       "42",
 				Branch:         "master",
+				Upstream:       "origin/master",
+				Ahead:          2,
+				Behind:         1,
+				Operation:      "merge",
 				HasModified:    true,
 				HasNew:         true,
 				HasStaged:      true,
+				HasStashed:     true,
+				HasConflicts:   true,
+			}
+			actual, err := InfoToString(info, "%%|%n|%h|%s|%r|%v|%b|%U|%A|%B|%o|%u|%a|%m|%t|%c|%P|%p|%e", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("%|fake|abc123|xyz|42|xyz|master|origin/master|2|1|merge|?|*|+|@|!|/foo|bar|foo"))
+		})
+
+		It("handles the %d fallbacks", func() {
+			info := VcsInfo{
+				Branch:        "master",
+				DetachedLabel: "v1.0-2-gabc123",
+			}
+			actual, err := InfoToString(info, "%d", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("master"))
+
+			info = VcsInfo{
+				Detached:      true,
+				DetachedLabel: "v1.0-2-gabc123",
 			}
-			actual, err := InfoToString(info, "%%|%n|%h|%s|%r|%v|%b|%u|%a|%m|%P|%p|%e", GetDefaultFormatOptions())
+			actual, err = InfoToString(info, "%d", GetDefaultFormatOptions())
 			Expect(err).To(BeNil())
-			Expect(actual).To(Equal("%|fake|abc123|xyz|42|xyz|master|?|*|+|/foo|bar|foo"))
+			Expect(actual).To(Equal("v1.0-2-gabc123"))
+
+			options := GetDefaultFormatOptions()
+			options.Unknown = "dunno"
+			actual, err = InfoToString(VcsInfo{}, "%d", options)
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("dunno"))
+		})
+
+		It("renders tags", func() {
+			info := VcsInfo{
+				Tags:               []string{"v1.0", "stable"},
+				NearestTag:         "v1.0",
+				NearestTagDistance: 3,
+			}
+			actual, err := InfoToString(info, "%T|%l|%L", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("v1.0,stable|v1.0|3"))
+
+			actual, err = InfoToString(VcsInfo{}, "%T|%l|%L", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("||"))
+		})
+
+		It("renders the workspace", func() {
+			actual, err := InfoToString(VcsInfo{Workspace: "my-client"}, "%w", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("my-client"))
+
+			actual, err = InfoToString(VcsInfo{}, "%w", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal(""))
+		})
+
+		It("omits ahead/behind counts of zero", func() {
+			info := VcsInfo{
+				Upstream: "origin/master",
+			}
+			actual, err := InfoToString(info, "%U|%A|%B", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("origin/master||"))
+		})
+
+		It("renders the remote slug", func() {
+			info := VcsInfo{
+				RemoteOwner: "jayclassless",
+				RemoteRepo:  "vcsinfo",
+			}
+			actual, err := InfoToString(info, "%R", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("jayclassless/vcsinfo"))
+
+			options := GetDefaultFormatOptions()
+			options.Unknown = "dunno"
+			actual, err = InfoToString(VcsInfo{RemoteRepo: "vcsinfo"}, "%R", options)
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("dunno"))
+		})
+
+		It("renders the commit metadata", func() {
+			info := VcsInfo{
+				CommitAuthor:  "Jay",
+				CommitEmail:   "jay@example.com",
+				CommitTime:    time.Date(2021, 11, 5, 14, 0, 0, 0, time.FixedZone("", -5*60*60)),
+				CommitSubject: "Fixed things",
+			}
+			actual, err := InfoToString(info, "%W|%E|%S|%D", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("Jay|jay@example.com|Fixed things|2021-11-05 14:00:00 -0500"))
+
+			options := GetDefaultFormatOptions()
+			options.Unknown = "?"
+			actual, err = InfoToString(VcsInfo{}, "%W|%E|%S|%D|%g", options)
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("?|?|?|?|?"))
+		})
+
+		It("renders the age of the commit", func() {
+			ages := map[time.Duration]string{
+				30 * time.Second:         "30s ago",
+				5 * time.Minute:          "5m ago",
+				3 * time.Hour:            "3h ago",
+				50 * time.Hour:           "2d ago",
+				15 * 24 * time.Hour:      "2w ago",
+				65 * 24 * time.Hour:      "2mo ago",
+				2 * 366 * 24 * time.Hour: "2y ago",
+			}
+			for age, expected := range ages {
+				info := VcsInfo{CommitTime: time.Now().Add(-age)}
+				actual, err := InfoToString(info, "%g", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal(expected))
+			}
 		})
 
 		It("handles the %v fallbacks", func() {
@@ -160,26 +357,516 @@ var _ = Describe("Public API", func() {
 
 		It("handles changed options", func() {
 			info := VcsInfo{
-				HasNew:      true,
-				HasModified: true,
-				HasStaged:   true,
+				HasNew:       true,
+				HasModified:  true,
+				HasStaged:    true,
+				HasConflicts: true,
 			}
 			options := GetDefaultFormatOptions()
 			options.Unknown = "dunno"
 			options.HasNew = "@"
 			options.HasModified = "#"
 			options.HasStaged = "$"
+			options.HasConflicts = "X"
 
-			actual, err := InfoToString(info, "%h|%s|%r|%v|%b|%u|%a|%m", options)
+			actual, err := InfoToString(info, "%h|%s|%r|%v|%b|%u|%a|%m|%c", options)
 			Expect(err).To(BeNil())
-			Expect(actual).To(Equal("dunno|dunno|dunno|dunno|dunno|@|$|#"))
+			Expect(actual).To(Equal("dunno|dunno|dunno|dunno|dunno|@|$|#|X"))
+		})
+
+		It("doesn't add the unknown string to found values", func() {
+			info := VcsInfo{
+				ShortHash: "xyz",
+			}
+			options := GetDefaultFormatOptions()
+			options.Unknown = "dunno"
+
+			actual, err := InfoToString(info, "%v", options)
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("xyz"))
 		})
 
 		It("fails on unrecognized codes", func() {
 			info := VcsInfo{}
 			actual, err := InfoToString(info, "%Q", GetDefaultFormatOptions())
 			Expect(actual).To(Equal(""))
-			Expect(err).To(MatchError(`unexpected formatting code "%Q"`))
+			Expect(err).To(MatchError(`unexpected formatting code "%Q" at position 1`))
+
+			_, err = InfoToString(info, "foo%b%Q", GetDefaultFormatOptions())
+			Expect(err).To(MatchError(`unexpected formatting code "%Q" at position 6`))
+			Expect(err).To(BeAssignableToTypeOf(&FormatError{}))
+			Expect(err.(*FormatError).Position).To(Equal(6))
+		})
+
+		It("fails on incomplete codes", func() {
+			_, err := InfoToString(VcsInfo{}, "%b%", GetDefaultFormatOptions())
+			Expect(err).To(MatchError(`incomplete formatting code "%" at position 3`))
+		})
+
+		It("renders the diff stat", func() {
+			info := VcsInfo{
+				DiffStat: &DiffStat{FilesChanged: 2, Insertions: 10, Deletions: 3},
+			}
+			actual, err := InfoToString(info, "%F|%+|%-", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("2|10|3"))
+
+			actual, err = InfoToString(VcsInfo{}, "%(%F files%)|%+|%-", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("||"))
+		})
+
+		Describe("counts", func() {
+			It("renders counts instead of indicators", func() {
+				info := VcsInfo{
+					HasStaged:      true,
+					StagedCount:    2,
+					HasModified:    true,
+					ModifiedCount:  14,
+					HasNew:         true,
+					UntrackedCount: 3,
+					HasConflicts:   true,
+					ConflictCount:  1,
+					HasStashed:     true,
+					StashCount:     4,
+				}
+				actual, err := InfoToString(info, "%#a|%#m|%#u|%#c|%#t|%a%m%u%c%t", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("2|14|3|1|4|*+?!@"))
+			})
+
+			It("omits counts of zero", func() {
+				actual, err := InfoToString(VcsInfo{}, "%(x%#m%)|%#u", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("|"))
+			})
+
+			It("fails on codes without counts", func() {
+				_, err := InfoToString(VcsInfo{}, "%b%#b", GetDefaultFormatOptions())
+				Expect(err).To(MatchError(`unexpected formatting code "%#b" at position 3`))
+
+				_, err = InfoToString(VcsInfo{}, "%b%#", GetDefaultFormatOptions())
+				Expect(err).To(MatchError(`incomplete formatting code "%#" at position 3`))
+			})
+		})
+
+		Describe("groups", func() {
+			It("renders groups with values", func() {
+				info := VcsInfo{
+					VcsName:     "fake",
+					Branch:      "master",
+					HasModified: true,
+				}
+				actual, err := InfoToString(info, "%n%([%b%m%u]%)", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("fake[master+]"))
+
+				info = VcsInfo{
+					VcsName:     "fake",
+					HasModified: true,
+				}
+				actual, err = InfoToString(info, "%n%([%b%m%u]%)", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("fake[+]"))
+			})
+
+			It("omits groups without values", func() {
+				info := VcsInfo{
+					VcsName: "fake",
+				}
+				actual, err := InfoToString(info, "%n%([%b%m%u]%)", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("fake"))
+
+				options := GetDefaultFormatOptions()
+				options.Unknown = "dunno"
+				actual, err = InfoToString(info, "%n%([%b]%)", options)
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("fake"))
+			})
+
+			It("handles nesting", func() {
+				info := VcsInfo{
+					Branch: "master",
+				}
+				actual, err := InfoToString(info, "%([%b%( (%m)%)]%)", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("[master]"))
+
+				info.HasModified = true
+				actual, err = InfoToString(info, "%([%b%( (%m)%)]%)", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("[master (+)]"))
+			})
+
+			It("fails on unclosed groups", func() {
+				_, err := InfoToString(VcsInfo{}, "%n%([%b", GetDefaultFormatOptions())
+				Expect(err).To(MatchError(`unclosed group "%(" at position 3`))
+			})
+
+			It("fails on unopened groups", func() {
+				_, err := InfoToString(VcsInfo{}, "%n]%)", GetDefaultFormatOptions())
+				Expect(err).To(MatchError(`unexpected "%)" at position 4`))
+			})
+		})
+
+		Describe("conditionals", func() {
+			It("renders the first branch when set", func() {
+				info := VcsInfo{
+					HasModified: true,
+				}
+				actual, err := InfoToString(info, "%?m( (dirty)%)", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal(" (dirty)"))
+
+				actual, err = InfoToString(info, "%?m(dirty%|clean%)", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("dirty"))
+			})
+
+			It("renders the second branch when unset", func() {
+				info := VcsInfo{}
+				actual, err := InfoToString(info, "%?m( (dirty)%)", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal(""))
+
+				actual, err = InfoToString(info, "%?m(dirty%|clean%)", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("clean"))
+			})
+
+			It("uses the underlying value rather than the output", func() {
+				info := VcsInfo{
+					HasModified: true,
+				}
+				options := GetDefaultFormatOptions()
+				options.HasModified = ""
+				actual, err := InfoToString(info, "%?m(dirty%|clean%)", options)
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("dirty"))
+
+				options.Unknown = "dunno"
+				actual, err = InfoToString(VcsInfo{}, "%?b(%b%|none%)", options)
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("none"))
+			})
+
+			It("handles nesting", func() {
+				info := VcsInfo{
+					Branch:   "master",
+					HasNew:   true,
+					Detached: false,
+				}
+				actual, err := InfoToString(info, "%?b(%b%?u(%?m( dirty%| new%)%)%|?%)", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("master new"))
+			})
+
+			It("counts as a value within groups", func() {
+				actual, err := InfoToString(VcsInfo{}, "%([%?m(dirty%|clean%)]%)", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("[clean]"))
+			})
+
+			It("fails on unknown codes", func() {
+				_, err := InfoToString(VcsInfo{}, "%?Q(foo%)", GetDefaultFormatOptions())
+				Expect(err).To(MatchError(`unexpected formatting code "%Q" in conditional at position 3`))
+			})
+
+			It("fails on missing branches", func() {
+				_, err := InfoToString(VcsInfo{}, "%?m dirty", GetDefaultFormatOptions())
+				Expect(err).To(MatchError(`expected "(" after conditional "%?m" at position 4`))
+			})
+
+			It("fails on unclosed conditionals", func() {
+				_, err := InfoToString(VcsInfo{}, "ab%?m(dirty%|clean", GetDefaultFormatOptions())
+				Expect(err).To(MatchError(`unclosed conditional "%?m(" at position 3`))
+			})
+
+			It("fails on extra branches", func() {
+				_, err := InfoToString(VcsInfo{}, "%?m(a%|b%|c%)", GetDefaultFormatOptions())
+				Expect(err).To(MatchError(`unexpected "%|" in conditional at position 9`))
+			})
+
+			It("fails on branches outside of conditionals", func() {
+				_, err := InfoToString(VcsInfo{}, "%(a%|b%)", GetDefaultFormatOptions())
+				Expect(err).To(MatchError(`unexpected "%|" in group at position 4`))
+
+				_, err = InfoToString(VcsInfo{}, "a%|b", GetDefaultFormatOptions())
+				Expect(err).To(MatchError(`unexpected "%|" at position 2`))
+			})
+
+			It("handles the dirty condition", func() {
+				format := "%?*(dirty%|clean%)"
+
+				actual, err := InfoToString(VcsInfo{}, format, GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("clean"))
+
+				for _, info := range []VcsInfo{
+					{HasNew: true},
+					{HasStaged: true},
+					{HasModified: true},
+					{HasConflicts: true},
+				} {
+					actual, err = InfoToString(info, format, GetDefaultFormatOptions())
+					Expect(err).To(BeNil())
+					Expect(actual).To(Equal("dirty"))
+				}
+
+				info := VcsInfo{HasStashed: true}
+				actual, err = InfoToString(info, format, GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("clean"))
+			})
+
+			It("doesn't allow the dirty condition outside of conditionals", func() {
+				_, err := InfoToString(VcsInfo{}, "%*", GetDefaultFormatOptions())
+				Expect(err).To(MatchError(`unexpected formatting code "%*" at position 1`))
+			})
+		})
+
+		Describe("styles", func() {
+			info := VcsInfo{
+				Branch:      "master",
+				HasModified: true,
+			}
+			format := "%{bold,red}%b%{reset}"
+
+			It("renders ANSI", func() {
+				options := GetDefaultFormatOptions()
+				actual, err := InfoToString(info, format, options)
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("\x1b[1;31mmaster\x1b[0m"))
+
+				options.ColorMode = ""
+				actual, err = InfoToString(info, format, options)
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("\x1b[1;31mmaster\x1b[0m"))
+			})
+
+			It("renders bash", func() {
+				options := GetDefaultFormatOptions()
+				options.ColorMode = ColorModeBash
+				actual, err := InfoToString(info, format, options)
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("\x01\x1b[1;31m\x02master\x01\x1b[0m\x02"))
+
+				// Bash only recognizes the raw \001 and \002 bytes in the output of
+				// commands, not the \[ and \] escapes.
+				Expect([]byte(actual)).To(Equal(append(append(
+					[]byte{0x01, 0x1b, '[', '1', ';', '3', '1', 'm', 0x02},
+					"master"...),
+					0x01, 0x1b, '[', '0', 'm', 0x02,
+				)))
+			})
+
+			It("renders zsh", func() {
+				options := GetDefaultFormatOptions()
+				options.ColorMode = ColorModeZsh
+				actual, err := InfoToString(info, format, options)
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("%{\x1b[1;31m%}master%{\x1b[0m%}"))
+			})
+
+			It("renders tmux", func() {
+				options := GetDefaultFormatOptions()
+				options.ColorMode = ColorModeTmux
+				actual, err := InfoToString(info, format, options)
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("#[bold,fg=red]master#[default]"))
+			})
+
+			It("renders nothing", func() {
+				options := GetDefaultFormatOptions()
+				options.ColorMode = ColorModeNone
+				actual, err := InfoToString(info, format, options)
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("master"))
+			})
+
+			It("handles all the colors", func() {
+				options := GetDefaultFormatOptions()
+				actual, err := InfoToString(info, "%{black}%{white}%{bright-cyan}%{bg-blue}%{bg-bright-green}%{default}%{bg-default}", options)
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("\x1b[30m\x1b[37m\x1b[96m\x1b[44m\x1b[102m\x1b[39m\x1b[49m"))
+
+				options.ColorMode = ColorModeTmux
+				actual, err = InfoToString(info, "%{black}%{white}%{bright-cyan}%{bg-blue}%{bg-bright-green}%{default}%{bg-default}", options)
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("#[fg=black]#[fg=white]#[fg=brightcyan]#[bg=blue]#[bg=brightgreen]#[fg=default]#[bg=default]"))
+			})
+
+			It("depends on the dirty state", func() {
+				format := "%?*(%{red}%|%{green}%)%b"
+				actual, err := InfoToString(info, format, GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("\x1b[31mmaster"))
+
+				actual, err = InfoToString(VcsInfo{Branch: "master"}, format, GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal("\x1b[32mmaster"))
+			})
+
+			It("doesn't count as a value within groups", func() {
+				actual, err := InfoToString(VcsInfo{}, "%([%{red}%b%?*(%{red}%|%{green}%)]%)", GetDefaultFormatOptions())
+				Expect(err).To(BeNil())
+				Expect(actual).To(Equal(""))
+			})
+
+			It("fails on unknown styles", func() {
+				_, err := InfoToString(info, "%b%{bold, purple}", GetDefaultFormatOptions())
+				Expect(err).To(MatchError(`unknown style "purple" at position 3`))
+			})
+
+			It("fails on unclosed styles", func() {
+				_, err := InfoToString(info, "%b%{bold", GetDefaultFormatOptions())
+				Expect(err).To(MatchError(`unclosed style "%{" at position 3`))
+			})
+
+			It("fails on unknown color modes", func() {
+				options := GetDefaultFormatOptions()
+				options.ColorMode = "foo"
+				_, err := InfoToString(info, format, options)
+				Expect(err).To(MatchError(`unknown color mode "foo"`))
+			})
+		})
+	})
+
+	Describe("FormatUsesDiffStat", func() {
+		It("finds the diff stat codes", func() {
+			for _, format := range []string{"%F", "%b%+", "%(%-%)", "%?F(x%)", "%?b(%|%+%)"} {
+				Expect(FormatUsesDiffStat(format)).To(BeTrue(), format)
+			}
+		})
+
+		It("ignores other codes", func() {
+			for _, format := range []string{"", "%b%m", "%%F", "%#m", "%(%u%)"} {
+				Expect(FormatUsesDiffStat(format)).To(BeFalse(), format)
+			}
+		})
+
+		It("fails on invalid formats", func() {
+			_, err := FormatUsesDiffStat("%Q")
+			Expect(err).To(MatchError(`unexpected formatting code "%Q" at position 1`))
+		})
+	})
+
+	Describe("FormatFields", func() {
+		It("finds the fields the codes need", func() {
+			Expect(FormatFields("%n %p %P %%")).To(Equal(FieldMask(0)))
+			Expect(FormatFields("%b")).To(Equal(FieldBranch))
+			Expect(FormatFields("%w")).To(Equal(FieldBranch))
+			Expect(FormatFields("%v")).To(Equal(FieldHash | FieldRevision))
+			Expect(FormatFields("%m%u")).To(Equal(FieldStatus | FieldUntracked))
+			Expect(FormatFields("%F%?+(%+%)")).To(Equal(FieldDiffStat))
+			Expect(FormatFields("%(%#m%t%)")).To(Equal(FieldStatus | FieldStash))
+			Expect(FormatFields("%?U(%A%|%R%)")).To(Equal(FieldUpstream | FieldRemote))
+			Expect(FormatFields("%?*(%{red}%|%S%)")).To(Equal(FieldStatus | FieldUntracked | FieldCommit))
+		})
+
+		It("fails on invalid formats", func() {
+			_, err := FormatFields("%Q")
+			Expect(err).To(MatchError(`unexpected formatting code "%Q" at position 1`))
+		})
+	})
+
+	Describe("TemplateFields", func() {
+		It("finds the fields the template uses", func() {
+			Expect(TemplateFields("{{.VcsName}} {{.Path}}")).To(Equal(FieldMask(0)))
+			Expect(TemplateFields("{{.Branch}}")).To(Equal(FieldBranch))
+			Expect(TemplateFields("{{if .HasNew}}{{.ShortHash}}{{else}}{{$.Revision}}{{end}}")).To(Equal(FieldUntracked | FieldHash | FieldRevision))
+			Expect(TemplateFields("{{$b := .Branch}}{{$b}}")).To(Equal(FieldBranch))
+			Expect(TemplateFields("{{.DiffStat.Insertions}}")).To(Equal(FieldDiffStat))
+		})
+
+		It("needs everything when the template can't be analyzed", func() {
+			Expect(TemplateFields("{{.}}")).To(Equal(FieldAll))
+			Expect(TemplateFields("{{range .Tags}}{{.}}{{end}}")).To(Equal(FieldAll))
+			Expect(TemplateFields("{{.Bogus}}")).To(Equal(FieldAll))
+		})
+
+		It("fails on invalid templates", func() {
+			_, err := TemplateFields("{{.Branch")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("InfoToTemplate", func() {
+		info := VcsInfo{
+			VcsName:        "fake",
+			Path:           "/foo/bar/baz",
+			RepositoryRoot: "/foo",
+			Hash:           "abc123def456",
+			Branch:         "feature/something-long",
+			Tags:           []string{"v1.0", "latest"},
+			HasModified:    true,
+		}
+
+		It("renders fields", func() {
+			actual, err := InfoToTemplate(info, "{{.VcsName}}[{{.Branch}}{{if .HasModified}}+{{end}}]", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("fake[feature/something-long+]"))
+		})
+
+		It("handles truncate", func() {
+			actual, err := InfoToTemplate(info, "{{.Hash | truncate 6}}|{{truncate 40 .Hash}}", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("abc123|abc123def456"))
+		})
+
+		It("handles default", func() {
+			actual, err := InfoToTemplate(info, `{{.Branch | default "none"}}|{{.Revision | default "none"}}|{{.Ahead | default "-"}}|{{.Tags | default "notags"}}|{{.Operation | default .ShortHash | default "?"}}`, GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("feature/something-long|none|-|[v1.0 latest]|?"))
+
+			actual, err = InfoToTemplate(VcsInfo{Tags: []string{}}, `{{.Tags | default "notags"}}`, GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("notags"))
+		})
+
+		It("handles pad", func() {
+			actual, err := InfoToTemplate(info, "[{{pad 6 .VcsName}}][{{.VcsName | pad -6}}][{{pad 2 .VcsName}}]", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("[fake  ][  fake][fake]"))
+		})
+
+		It("handles upper", func() {
+			actual, err := InfoToTemplate(info, "{{upper .VcsName}}", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("FAKE"))
+		})
+
+		It("handles color", func() {
+			format := `{{color "bold, red"}}{{.VcsName}}{{color "reset"}}`
+
+			actual, err := InfoToTemplate(info, format, GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("\x1b[1;31mfake\x1b[0m"))
+
+			options := GetDefaultFormatOptions()
+			options.ColorMode = ColorModeZsh
+			actual, err = InfoToTemplate(info, format, options)
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("%{\x1b[1;31m%}fake%{\x1b[0m%}"))
+
+			_, err = InfoToTemplate(info, `{{color "purple"}}`, GetDefaultFormatOptions())
+			Expect(err).To(MatchError(ContainSubstring(`unknown style "purple"`)))
+		})
+
+		It("handles relpath", func() {
+			actual, err := InfoToTemplate(info, "{{relpath .Path}}|{{relpath .RepositoryRoot}}", GetDefaultFormatOptions())
+			Expect(err).To(BeNil())
+			Expect(actual).To(Equal("bar/baz|."))
+		})
+
+		It("fails on malformed templates", func() {
+			actual, err := InfoToTemplate(info, "{{.VcsName", GetDefaultFormatOptions())
+			Expect(actual).To(Equal(""))
+			Expect(err).ToNot(BeNil())
+
+			_, err = InfoToTemplate(info, "{{.Foo}}", GetDefaultFormatOptions())
+			Expect(err).ToNot(BeNil())
 		})
 	})
 })
@@ -1,12 +1,33 @@
 package vcsinfo
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// cvsDateLayouts are the layouts of the dates reported by "cvs log"; which is
+// used depends on the version of CVS.
+var cvsDateLayouts = []string{
+	"2006-01-02 15:04:05 -0700",
+	"2006/01/02 15:04:05",
+}
+
+// cvsEntryLayout is the layout of the timestamps recorded in CVS/Entries.
+const cvsEntryLayout = "Mon Jan _2 15:04:05 2006"
+
 // CvsProbe is a probe for extracting information out of a CVS  repository.
-type CvsProbe struct{}
+type CvsProbe struct {
+	// AskServer causes the probe to retrieve the author, time, and subject
+	// of the most recent revision by running cvs log. That contacts the
+	// server (possibly over the network) and examines every file, so it
+	// isn't done by default; instead, the time is taken from the working
+	// copy's CVS/Entries files, and the author and subject are left empty.
+	AskServer bool
+}
 
 // Name returns the human-facing name of the probe.
 func (probe CvsProbe) Name() string {
@@ -15,7 +36,7 @@ func (probe CvsProbe) Name() string {
 
 // DefaultFormat returns the default format string to use for CVS repositories.
 func (probe CvsProbe) DefaultFormat() string {
-	return "%n[%e%m%u]"
+	return "%n[%e%c%m%u]"
 }
 
 // IsAvailable indicates whether or not this probe has the tools/environment
@@ -40,10 +61,10 @@ func (probe CvsProbe) IsRepositoryRoot(path string) (bool, error) {
 	return true, nil
 }
 
-func (probe CvsProbe) extractStatus(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "cvs", "status")
+func (probe CvsProbe) extractStatus(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "cvs", "status")
 	if err != nil {
-		if len(out) > 0 {
+		if len(out) > 0 && getExitCode(err) > 0 {
 			// We're likely in a new directory that hasn't been added yet
 			if strings.HasPrefix(out[0], "cvs status: No CVSROOT specified!") {
 				return nil
@@ -58,16 +79,21 @@ func (probe CvsProbe) extractStatus(path string, info *VcsInfo) error {
 			strings.HasSuffix(line, "Locally Removed") ||
 			strings.HasSuffix(line, "Needs Checkout") {
 			info.HasModified = true
+			info.ModifiedCount++
+		} else if strings.HasSuffix(line, "Unresolved Conflict") ||
+			strings.HasSuffix(line, "File had conflicts on merge") {
+			info.HasConflicts = true
+			info.ConflictCount++
 		}
 	}
 
 	return nil
 }
 
-func (probe CvsProbe) extractNew(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "cvs", "-qn", "update")
+func (probe CvsProbe) extractNew(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "cvs", "-qn", "update")
 	if err != nil {
-		if len(out) > 0 {
+		if len(out) > 0 && getExitCode(err) > 0 {
 			// We're likely in a new directory that hasn't been added yet
 			if strings.HasPrefix(out[0], "cvs update: No CVSROOT specified!") {
 				return nil
@@ -79,16 +105,236 @@ func (probe CvsProbe) extractNew(path string, info *VcsInfo) error {
 	for _, line := range out {
 		if strings.HasPrefix(line, "?") {
 			info.HasNew = true
+			info.UntrackedCount++
+		}
+	}
+
+	return nil
+}
+
+func (probe CvsProbe) readStickyTag(root string, info *VcsInfo) error {
+	content, err := ioutil.ReadFile(filepath.Join(root, "CVS", "Tag"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// There's no sticky tag.
 			return nil
 		}
+		return err
+	}
+
+	tag := strings.TrimSpace(string(content))
+	if len(tag) < 2 {
+		return nil
+	}
+
+	switch tag[0] {
+	case 'T':
+		// This is a branch tag.
+		info.Branch = tag[1:]
+	case 'N':
+		// This is a non-branch tag, which is always exactly where the
+		// working copy is.
+		info.Tags = []string{tag[1:]}
+		info.NearestTag = tag[1:]
+	}
+
+	return nil
+}
+
+func parseCvsDate(value string) (time.Time, error) {
+	var err error
+	for _, layout := range cvsDateLayouts {
+		var parsed time.Time
+		parsed, err = time.Parse(layout, value)
+		if err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// readEntriesTime finds the most recent timestamp recorded in the CVS/Entries
+// files of the directory and the directories within it. The timestamps are
+// when the files were last checked out or updated, which is the closest thing
+// to the time of the last commit that's available without asking the server.
+func (probe CvsProbe) readEntriesTime(dir string, info *VcsInfo) error {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "CVS", "Entries"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		// Each file is listed as "/NAME/REVISION/TIMESTAMP/OPTIONS/TAG", and
+		// each directory as "D/NAME////".
+		parts := strings.Split(line, "/")
+		if len(parts) < 4 {
+			continue
+		}
+		if parts[0] == "D" {
+			if parts[1] != "" {
+				err = probe.readEntriesTime(filepath.Join(dir, parts[1]), info)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// Merged files have the timestamp prefixed with "Result of merge+",
+		// and newly added files don't have one at all.
+		stamp := parts[3]
+		if idx := strings.LastIndex(stamp, "+"); idx >= 0 {
+			stamp = stamp[idx+1:]
+		}
+		entryTime, err := time.Parse(cvsEntryLayout, stamp)
+		if err == nil && entryTime.After(info.CommitTime) {
+			info.CommitTime = entryTime
+		}
 	}
 
 	return nil
 }
 
+func (probe CvsProbe) extractLastCommit(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "cvs", "-q", "log", "-N", "-r")
+	if err != nil {
+		if len(out) > 0 && getExitCode(err) > 0 {
+			// We're likely in a new directory that hasn't been added yet
+			if strings.HasPrefix(out[0], "cvs log: No CVSROOT specified!") {
+				return nil
+			}
+		}
+		return err
+	}
+
+	// CVS doesn't have changesets, so the most recent revision of any file is
+	// the closest thing to the last commit.
+	inRevision := false
+	for _, line := range out {
+		if strings.HasPrefix(line, "date: ") {
+			inRevision = false
+
+			fields := map[string]string{}
+			for _, field := range strings.Split(line, ";") {
+				parts := strings.SplitN(strings.TrimSpace(field), ": ", 2)
+				if len(parts) == 2 {
+					fields[parts[0]] = parts[1]
+				}
+			}
+
+			commitTime, err := parseCvsDate(fields["date"])
+			if err != nil {
+				return err
+			}
+			if commitTime.After(info.CommitTime) {
+				info.CommitTime = commitTime
+				info.CommitAuthor = fields["author"]
+				info.CommitSubject = ""
+				inRevision = true
+			}
+			continue
+		}
+
+		if inRevision && !strings.HasPrefix(line, "branches: ") {
+			info.CommitSubject = strings.TrimSpace(line)
+			inRevision = false
+		}
+	}
+
+	return nil
+}
+
+// parseCvsRoot splits a CVSROOT (e.g., ":pserver:user@host:/cvsroot") into
+// the host the repository is on and the path to it on that host. The host is
+// empty for local repositories.
+func parseCvsRoot(cvsRoot string) (string, string) {
+	if strings.HasPrefix(cvsRoot, ":") {
+		// Strip the access method (and any options that go with it).
+		parts := strings.SplitN(cvsRoot[1:], ":", 2)
+		if len(parts) != 2 {
+			return "", ""
+		}
+		cvsRoot = parts[1]
+	}
+
+	match := scpLikeURL.FindStringSubmatch(cvsRoot)
+	if match == nil {
+		return "", cvsRoot
+	}
+
+	// The path may be preceded by a port number.
+	return match[1], strings.TrimLeft(match[2], "0123456789")
+}
+
+// redactCvsRoot removes the password (e.g., the "secret" in
+// ":pserver:user:secret@host:/cvsroot") from a CVSROOT, so that it doesn't end
+// up in the output.
+func redactCvsRoot(cvsRoot string) string {
+	at := strings.LastIndex(cvsRoot, "@")
+	if !strings.HasPrefix(cvsRoot, ":") || at < 0 {
+		return cvsRoot
+	}
+
+	// The user and password follow the access method (and its options).
+	parts := strings.SplitN(cvsRoot[1:at], ":", 3)
+	if len(parts) < 3 {
+		return cvsRoot
+	}
+	return ":" + parts[0] + ":" + parts[1] + cvsRoot[at:]
+}
+
+func (probe CvsProbe) readRoot(root string, info *VcsInfo) error {
+	content, err := ioutil.ReadFile(filepath.Join(root, "CVS", "Root"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	cvsRoot := strings.TrimSpace(string(content))
+	if cvsRoot == "" {
+		return nil
+	}
+	info.RemoteURL = redactCvsRoot(cvsRoot)
+
+	host, path := parseCvsRoot(cvsRoot)
+
+	// The repository is the module within the CVSROOT that was checked out.
+	content, err = ioutil.ReadFile(filepath.Join(root, "CVS", "Repository"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	module := strings.SplitN(strings.TrimSpace(string(content)), "/", 2)[0]
+	if module != "" && module != "." {
+		path = path + "/" + module
+	}
+
+	setRemoteLocation(info, host, path)
+	return nil
+}
+
 // GatherInfo extracts and returns VCS information for the CVS repository at
 // the specified path.
 func (probe CvsProbe) GatherInfo(path string) (VcsInfo, []error) {
+	return probe.GatherInfoContext(context.Background(), path)
+}
+
+// GatherInfoContext extracts and returns VCS information for the CVS
+// repository at the specified path. If the context expires before all
+// information could be gathered, the fields collected so far are returned.
+func (probe CvsProbe) GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error) {
+	return probe.GatherInfoFields(ctx, path, FieldAll)
+}
+
+// GatherInfoFields extracts and returns the requested VCS information for the
+// CVS repository at the specified path, skipping the work needed for the
+// other fields. If the context expires before all information could be
+// gathered, the fields collected so far are returned.
+func (probe CvsProbe) GatherInfoFields(ctx context.Context, path string, fields FieldMask) (VcsInfo, []error) {
 	info := VcsInfo{
 		VcsName: probe.Name(),
 		Path:    path,
@@ -101,14 +347,104 @@ func (probe CvsProbe) GatherInfo(path string) (VcsInfo, []error) {
 	info.RepositoryRoot = root
 
 	errors := waitGroup(
-		func() error {
-			return probe.extractStatus(path, &info)
-		},
+		fields.when(FieldStatus, func() error {
+			return probe.extractStatus(ctx, path, &info)
+		}),
+
+		fields.when(FieldUntracked, func() error {
+			return probe.extractNew(ctx, path, &info)
+		}),
 
-		func() error {
-			return probe.extractNew(path, &info)
-		},
+		fields.when(FieldCommit, func() error {
+			if !probe.AskServer {
+				return probe.readEntriesTime(path, &info)
+			}
+			return probe.extractLastCommit(ctx, path, &info)
+		}),
+
+		fields.when(FieldBranch|FieldTags, func() error {
+			return probe.readStickyTag(root, &info)
+		}),
+
+		fields.when(FieldRemote, func() error {
+			return probe.readRoot(root, &info)
+		}),
 	)
 
 	return info, errors
 }
+
+// cvsFileStates maps the codes used by "cvs update" to FileStates.
+var cvsFileStates = map[string]FileState{
+	"M": FileModified,
+	"A": FileAdded,
+	"R": FileDeleted,
+	"?": FileUntracked,
+	"C": FileConflicted,
+}
+
+// FileStatus returns the status of each file in the CVS working copy at the
+// specified path that differs from the repository.
+func (probe CvsProbe) FileStatus(path string) ([]FileStatus, error) {
+	return probe.FileStatusContext(context.Background(), path)
+}
+
+// FileStatusContext returns the status of each file in the CVS working copy at
+// the specified path that differs from the repository, abandoning the work
+// when the context expires.
+func (probe CvsProbe) FileStatusContext(ctx context.Context, path string) ([]FileStatus, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := runCommand(ctx, root, "cvs", "-qn", "update")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []FileStatus{}
+	for _, line := range out {
+		if strings.HasPrefix(line, "cvs update: warning: ") && strings.HasSuffix(line, " was lost") {
+			name := strings.TrimSuffix(strings.TrimPrefix(line, "cvs update: warning: "), " was lost")
+			files = append(files, worktreeStatus(strings.Trim(name, "`'"), FileMissing))
+			continue
+		}
+
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		state, ok := cvsFileStates[parts[0]]
+		if !ok {
+			continue
+		}
+		files = append(files, worktreeStatus(parts[1], state))
+	}
+
+	return files, nil
+}
+
+// DiffStat summarizes the differences between the CVS working copy at the
+// specified path and the repository.
+func (probe CvsProbe) DiffStat(path string) (DiffStat, error) {
+	return probe.DiffStatContext(context.Background(), path)
+}
+
+// DiffStatContext summarizes the differences between the CVS working copy at
+// the specified path and the repository, abandoning the work when the context
+// expires.
+func (probe CvsProbe) DiffStatContext(ctx context.Context, path string) (DiffStat, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	out, err := runCommand(ctx, root, "cvs", "-q", "diff", "-u")
+	if err != nil && getExitCode(err) != 1 {
+		// An exit code of 1 just means there were differences.
+		return DiffStat{}, err
+	}
+
+	return parseUnifiedDiff(out), nil
+}
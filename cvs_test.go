@@ -1,6 +1,9 @@
 package vcsinfo_test
 
 import (
+	"context"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gstruct"
@@ -182,10 +185,210 @@ var _ = Describe("CVS", func() {
 			}))
 		})
 
+		It("sees conflicts", func() {
+			cvs(dir, "checkout", "dummy", ".")
+			writeFile(dir, "foo", "bar")
+			cvs(dir, "add", "foo")
+			cvs(dir, "commit", "-m", "blah")
+
+			other := tmpdir()
+			defer rmdir(other)
+			cvs(other, "checkout", "dummy", ".")
+			writeFile(other, "foo", "baz")
+			cvs(other, "commit", "-m", "other")
+
+			writeFile(dir, "foo", "qux")
+			runIgnoringFailure(dir, "cvs", "-d", repoDir, "update")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasConflicts": BeTrue(),
+			}))
+		})
+
+		It("sees sticky tags", func() {
+			cvs(dir, "checkout", "dummy", ".")
+			writeFile(dir, "foo", "bar")
+			cvs(dir, "add", "foo")
+			cvs(dir, "commit", "-m", "blah")
+			cvs(dir, "tag", "release_1")
+			cvs(dir, "update", "-r", "release_1")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Tags":       ConsistOf("release_1"),
+				"NearestTag": Equal("release_1"),
+			}))
+		})
+
+		It("sees sticky branches", func() {
+			cvs(dir, "checkout", "dummy", ".")
+			writeFile(dir, "foo", "bar")
+			cvs(dir, "add", "foo")
+			cvs(dir, "commit", "-m", "blah")
+			cvs(dir, "tag", "-b", "mybranch")
+			cvs(dir, "update", "-r", "mybranch")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Branch": Equal("mybranch"),
+				"Tags":   BeEmpty(),
+			}))
+		})
+
+		It("sees remotes", func() {
+			cvs(dir, "checkout", "dummy", ".")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"RemoteURL":  Equal(repoDir),
+				"RemoteHost": Equal(""),
+			}))
+		})
+
+		It("sees commit metadata", func() {
+			cvs(dir, "checkout", "dummy", ".")
+			writeFile(dir, "foo", "bar")
+			cvs(dir, "add", "foo")
+			cvs(dir, "commit", "-m", "blah")
+			info, err := CvsProbe{AskServer: true}.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"CommitAuthor":  Not(BeEmpty()),
+				"CommitTime":    BeTemporally("~", time.Now(), time.Minute),
+				"CommitSubject": Equal("blah"),
+			}))
+		})
+
+		It("only asks the server for commit metadata when told to", func() {
+			cvs(dir, "checkout", "dummy", ".")
+			writeFile(dir, "foo", "bar")
+			cvs(dir, "add", "foo")
+			cvs(dir, "commit", "-m", "blah")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"CommitAuthor":  BeEmpty(),
+				"CommitTime":    BeTemporally("~", time.Now(), time.Minute),
+				"CommitSubject": BeEmpty(),
+			}))
+		})
+
+		It("returns partial info when the context is cancelled", func() {
+			cvs(dir, "checkout", "dummy", ".")
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			info, err := probe.GatherInfoContext(ctx, dir)
+			Expect(err).To(ContainElement(context.Canceled))
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName":        Equal("cvs"),
+				"Path":           Equal(dir),
+				"RepositoryRoot": Equal(dir),
+			}))
+		})
+
 		It("doesnt crash when in VCS special dir", func() {
 			cvs(dir, "checkout", "dummy", ".")
 			_, err := probe.GatherInfo(dir + "/CVS")
 			Expect(err).To(BeEmpty())
 		})
 	})
+
+	Describe("FileStatus", func() {
+		var dir, repoDir string
+
+		cvs := func(targetDir string, command ...string) {
+			cmd := append([]string{"cvs", "-d", repoDir}, command...)
+			run(targetDir, cmd...)
+		}
+
+		BeforeEach(func() {
+			dir = tmpdir()
+
+			repoDir = tmpdir()
+			cvs(repoDir, "init")
+
+			dummy := mkdir(dir, "dummy")
+			cvs(dir, "import", "-m", "Initial import", "dummy", "mycompany", "init")
+			rmdir(dummy)
+
+			cvs(dir, "checkout", "dummy", ".")
+			writeFile(dir, "foo", "bar")
+			cvs(dir, "add", "foo")
+			cvs(dir, "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+			rmdir(repoDir)
+			repoDir = ""
+		})
+
+		It("sees changed files", func() {
+			writeFile(dir, "foo", "changed")
+			writeFile(dir, "added", "file")
+			cvs(dir, "add", "added")
+			writeFile(dir, "new", "file")
+			files, err := probe.FileStatus(dir)
+			Expect(err).To(BeNil())
+
+			Expect(files).To(ConsistOf(
+				FileStatus{Path: "foo", Index: FileUnmodified, Worktree: FileModified},
+				FileStatus{Path: "added", Index: FileUnmodified, Worktree: FileAdded},
+				FileStatus{Path: "new", Index: FileUnmodified, Worktree: FileUntracked},
+			))
+		})
+	})
+
+	Describe("DiffStat", func() {
+		var dir, repoDir string
+
+		cvs := func(targetDir string, command ...string) {
+			cmd := append([]string{"cvs", "-d", repoDir}, command...)
+			run(targetDir, cmd...)
+		}
+
+		BeforeEach(func() {
+			dir = tmpdir()
+
+			repoDir = tmpdir()
+			cvs(repoDir, "init")
+
+			dummy := mkdir(dir, "dummy")
+			cvs(dir, "import", "-m", "Initial import", "dummy", "mycompany", "init")
+			rmdir(dummy)
+
+			cvs(dir, "checkout", "dummy", ".")
+			writeFile(dir, "foo", "one\ntwo\nthree\n")
+			cvs(dir, "add", "foo")
+			cvs(dir, "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+			rmdir(repoDir)
+			repoDir = ""
+		})
+
+		It("counts changed files and lines", func() {
+			writeFile(dir, "foo", "one\nTWO\nthree\nfour\n")
+			stat, err := probe.DiffStat(dir)
+			Expect(err).To(BeNil())
+
+			Expect(stat).To(Equal(DiffStat{
+				FilesChanged: 1,
+				Insertions:   2,
+				Deletions:    1,
+			}))
+		})
+	})
 })
@@ -1,14 +1,23 @@
 package vcsinfo
 
 import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"os"
 	pth "path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // DarcsProbe is a probe for extracting information out of a DARCS repository.
 type DarcsProbe struct{}
 
+// darcsConflictMarker is the line DARCS inserts at the start of each conflict
+// it marks in a file.
+const darcsConflictMarker = "v v v v v v v"
+
 // Name returns the human-facing name of the probe.
 func (probe DarcsProbe) Name() string {
 	return "darcs"
@@ -17,7 +26,7 @@ func (probe DarcsProbe) Name() string {
 // DefaultFormat returns the default format string to use for DARCS
 // repositories.
 func (probe DarcsProbe) DefaultFormat() string {
-	return "%n[%b%m%u]"
+	return "%n[%b%c%m%u]"
 }
 
 // IsAvailable indicates whether or not this probe has the tools/environment
@@ -32,10 +41,10 @@ func (probe DarcsProbe) IsRepositoryRoot(path string) (bool, error) {
 	return dirExists(filepath.Join(path, "_darcs"))
 }
 
-func (probe DarcsProbe) extractStatus(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "darcs", "whatsnew", "--look-for-adds", "--summary")
+func (probe DarcsProbe) extractStatus(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "darcs", "whatsnew", "--look-for-adds", "--summary")
 	if err != nil {
-		if len(out) > 0 {
+		if len(out) > 0 && getExitCode(err) > 0 {
 			if out[0] == "No changes!" {
 				return nil
 			}
@@ -48,16 +57,56 @@ func (probe DarcsProbe) extractStatus(path string, info *VcsInfo) error {
 
 		if flag == "a" {
 			info.HasNew = true
-		} else {
-			info.HasModified = true
+			info.UntrackedCount++
+			continue
+		}
+
+		if flag == "M" {
+			parts := strings.Fields(line)
+			if len(parts) > 1 {
+				conflicted, err := probe.hasConflictMarkers(filepath.Join(info.RepositoryRoot, parts[1]))
+				if err != nil {
+					return err
+				}
+				if conflicted {
+					info.HasConflicts = true
+					info.ConflictCount++
+					continue
+				}
+			}
 		}
+
+		info.HasModified = true
+		info.ModifiedCount++
 	}
 
 	return nil
 }
 
-func (probe DarcsProbe) extractHash(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "darcs", "log", "--last", "1")
+// DARCS doesn't track conflicts once they've been marked in the working copy,
+// so the only way to find them is to look for the markers it left behind.
+func (probe DarcsProbe) hasConflictMarkers(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if scanner.Text() == darcsConflictMarker {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+func (probe DarcsProbe) extractHash(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "darcs", "log", "--last", "1")
 	if err != nil {
 		return err
 	}
@@ -65,6 +114,83 @@ func (probe DarcsProbe) extractHash(path string, info *VcsInfo) error {
 	for _, line := range out {
 		if strings.HasPrefix(line, "patch ") {
 			info.Hash = line[6:]
+
+		} else if strings.HasPrefix(line, "Author:") {
+			info.CommitAuthor, info.CommitEmail = splitAuthor(strings.TrimSpace(line[7:]))
+
+		} else if strings.HasPrefix(line, "Date:") {
+			commitTime, err := time.Parse(time.UnixDate, strings.TrimSpace(line[5:]))
+			if err != nil {
+				return err
+			}
+			info.CommitTime = commitTime
+
+		} else if strings.HasPrefix(line, "  * ") && info.CommitSubject == "" {
+			info.CommitSubject = line[4:]
+		}
+	}
+
+	return nil
+}
+
+func (probe DarcsProbe) readDefaultRepo(root string, info *VcsInfo) error {
+	content, err := ioutil.ReadFile(filepath.Join(root, "_darcs", "prefs", "defaultrepo"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// There's no default remote repository.
+			return nil
+		}
+		return err
+	}
+
+	if remote := strings.TrimSpace(string(content)); remote != "" {
+		setRemote(info, "", remote)
+	}
+
+	return nil
+}
+
+func (probe DarcsProbe) extractTags(ctx context.Context, path string, info *VcsInfo) error {
+	// Asking for the log starting from the most recent tag (matched by the
+	// "." pattern) lets us walk the patches between it and the current state.
+	out, err := runCommand(ctx, path, "darcs", "log", "--from-tag", ".")
+	if err != nil {
+		if getExitCode(err) > 0 {
+			// This generally means there aren't any tags to be found.
+			return nil
+		}
+		return err
+	}
+
+	distance := 0
+	inHeader := false
+	for _, line := range out {
+		if strings.HasPrefix(line, "patch ") {
+			inHeader = true
+			continue
+		}
+		if !inHeader || !strings.HasPrefix(line, "  ") {
+			continue
+		}
+
+		// This is the name of the patch.
+		inHeader = false
+		name := strings.TrimSpace(line)
+		if !strings.HasPrefix(name, "tagged ") {
+			if info.NearestTag != "" {
+				break
+			}
+			distance++
+			continue
+		}
+
+		tag := name[7:]
+		if distance == 0 {
+			info.Tags = append(info.Tags, tag)
+		}
+		if info.NearestTag == "" {
+			info.NearestTag = tag
+			info.NearestTagDistance = distance
 		}
 	}
 
@@ -74,6 +200,21 @@ func (probe DarcsProbe) extractHash(path string, info *VcsInfo) error {
 // GatherInfo extracts and returns VCS information for the DARCS repository at
 // the specified path.
 func (probe DarcsProbe) GatherInfo(path string) (VcsInfo, []error) {
+	return probe.GatherInfoContext(context.Background(), path)
+}
+
+// GatherInfoContext extracts and returns VCS information for the DARCS
+// repository at the specified path. If the context expires before all
+// information could be gathered, the fields collected so far are returned.
+func (probe DarcsProbe) GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error) {
+	return probe.GatherInfoFields(ctx, path, FieldAll)
+}
+
+// GatherInfoFields extracts and returns the requested VCS information for the
+// DARCS repository at the specified path, skipping the work needed for the
+// other fields. If the context expires before all information could be
+// gathered, the fields collected so far are returned.
+func (probe DarcsProbe) GatherInfoFields(ctx context.Context, path string, fields FieldMask) (VcsInfo, []error) {
 	info := VcsInfo{
 		VcsName: probe.Name(),
 		Path:    path,
@@ -87,14 +228,120 @@ func (probe DarcsProbe) GatherInfo(path string) (VcsInfo, []error) {
 	info.Branch = pth.Base(root)
 
 	errors := waitGroup(
-		func() error {
-			return probe.extractStatus(path, &info)
-		},
+		fields.when(FieldStatus|FieldUntracked, func() error {
+			return probe.extractStatus(ctx, path, &info)
+		}),
+
+		fields.when(FieldHash|FieldCommit, func() error {
+			return probe.extractHash(ctx, path, &info)
+		}),
 
-		func() error {
-			return probe.extractHash(path, &info)
-		},
+		fields.when(FieldTags, func() error {
+			return probe.extractTags(ctx, path, &info)
+		}),
+
+		fields.when(FieldRemote, func() error {
+			return probe.readDefaultRepo(root, &info)
+		}),
 	)
 
 	return info, errors
 }
+
+// darcsFileStates maps the codes used by "darcs whatsnew --summary" to
+// FileStates.
+var darcsFileStates = map[string]FileState{
+	"M": FileModified,
+	"A": FileAdded,
+	"R": FileDeleted,
+	"a": FileUntracked,
+}
+
+// darcsPath converts the paths DARCS reports (e.g., "./foo/bar") to be relative
+// to the repository root.
+func darcsPath(path string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(path, "./"), "/")
+}
+
+// FileStatus returns the status of each file in the DARCS repository at the
+// specified path that differs from the recorded patches.
+func (probe DarcsProbe) FileStatus(path string) ([]FileStatus, error) {
+	return probe.FileStatusContext(context.Background(), path)
+}
+
+// FileStatusContext returns the status of each file in the DARCS repository at
+// the specified path that differs from the recorded patches, abandoning the
+// work when the context expires.
+func (probe DarcsProbe) FileStatusContext(ctx context.Context, path string) ([]FileStatus, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []FileStatus{}
+
+	out, err := runCommand(ctx, root, "darcs", "whatsnew", "--look-for-adds", "--summary")
+	if err != nil {
+		if len(out) > 0 && getExitCode(err) > 0 && out[0] == "No changes!" {
+			return files, nil
+		}
+		return nil, err
+	}
+
+	for _, line := range out {
+		parts := strings.Fields(line)
+		if len(parts) < 2 {
+			continue
+		}
+
+		if len(parts) == 3 && parts[1] == "->" {
+			file := worktreeStatus(darcsPath(parts[2]), FileRenamed)
+			file.OriginalPath = darcsPath(parts[0])
+			files = append(files, file)
+			continue
+		}
+
+		state, ok := darcsFileStates[parts[0]]
+		if !ok {
+			continue
+		}
+		name := darcsPath(parts[1])
+
+		if state == FileModified {
+			conflicted, err := probe.hasConflictMarkers(filepath.Join(root, name))
+			if err != nil {
+				return nil, err
+			}
+			if conflicted {
+				state = FileConflicted
+			}
+		}
+
+		files = append(files, worktreeStatus(name, state))
+	}
+
+	return files, nil
+}
+
+// DiffStat summarizes the differences between the DARCS repository at the
+// specified path and the recorded patches.
+func (probe DarcsProbe) DiffStat(path string) (DiffStat, error) {
+	return probe.DiffStatContext(context.Background(), path)
+}
+
+// DiffStatContext summarizes the differences between the DARCS repository at
+// the specified path and the recorded patches, abandoning the work when the
+// context expires.
+func (probe DarcsProbe) DiffStatContext(ctx context.Context, path string) (DiffStat, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	out, err := runCommand(ctx, root, "darcs", "diff")
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	return parseUnifiedDiff(out), nil
+}
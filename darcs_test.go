@@ -1,6 +1,7 @@
 package vcsinfo_test
 
 import (
+	"context"
 	"path"
 	"time"
 
@@ -154,9 +155,177 @@ var _ = Describe("Darcs", func() {
 			}))
 		})
 
+		It("sees conflicts", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "darcs", "add", "foo")
+			run(dir, "darcs", "record", "--author", "fake@example.com", "--no-interactive", "-m", "blah")
+
+			other := tmpdir()
+			defer rmdir(other)
+			run(other, "darcs", "clone", dir, "copy")
+			clone := other + "/copy"
+
+			// Darcs "file has been updated" detection is poor. If we update the file too quickly, it doesn't see it as a change
+			time.Sleep(1001 * time.Millisecond)
+			writeFile(clone, "foo", "baz")
+			run(clone, "darcs", "record", "--author", "fake@example.com", "--no-interactive", "-m", "other")
+			writeFile(dir, "foo", "qux")
+			run(dir, "darcs", "record", "--author", "fake@example.com", "--no-interactive", "-m", "mine")
+
+			runIgnoringFailure(dir, "darcs", "pull", "--all", "--mark-conflicts", clone)
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasConflicts": BeTrue(),
+			}))
+		})
+
+		It("sees tags", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "darcs", "add", "foo")
+			run(dir, "darcs", "record", "--author", "fake@example.com", "--no-interactive", "-m", "blah")
+			run(dir, "darcs", "tag", "--author", "fake@example.com", "v1.0")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Tags":               ConsistOf("v1.0"),
+				"NearestTag":         Equal("v1.0"),
+				"NearestTagDistance": Equal(0),
+			}))
+
+			time.Sleep(1001 * time.Millisecond)
+			writeFile(dir, "foo", "baz")
+			run(dir, "darcs", "record", "--author", "fake@example.com", "--no-interactive", "-m", "blah")
+			info, err = probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Tags":               BeEmpty(),
+				"NearestTag":         Equal("v1.0"),
+				"NearestTagDistance": Equal(1),
+			}))
+		})
+
+		It("sees remotes", func() {
+			other := tmpdir()
+			defer rmdir(other)
+			run(other, "darcs", "clone", dir, "clone")
+
+			info, err := probe.GatherInfo(other + "/clone")
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"RemoteURL":  Equal(dir),
+				"RemoteHost": Equal(""),
+			}))
+		})
+
+		It("sees no remotes", func() {
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"RemoteURL": Equal(""),
+			}))
+		})
+
+		It("sees commit metadata", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "darcs", "add", "foo")
+			run(dir, "darcs", "record", "--author", "Jay <jay@example.com>", "--no-interactive", "-m", "blah")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"CommitAuthor":  Equal("Jay"),
+				"CommitEmail":   Equal("jay@example.com"),
+				"CommitTime":    BeTemporally("~", time.Now(), time.Minute),
+				"CommitSubject": Equal("blah"),
+			}))
+		})
+
+		It("returns partial info when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			info, err := probe.GatherInfoContext(ctx, dir)
+			Expect(err).To(ContainElement(context.Canceled))
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName":        Equal("darcs"),
+				"Path":           Equal(dir),
+				"RepositoryRoot": Equal(dir),
+			}))
+		})
+
 		It("doesnt crash when in VCS special dir", func() {
 			_, err := probe.GatherInfo(dir + "/_darcs")
 			Expect(err).To(BeEmpty())
 		})
 	})
+
+	Describe("FileStatus", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			run(dir, "darcs", "init")
+			writeFile(dir, "foo", "bar")
+			run(dir, "darcs", "add", "foo")
+			run(dir, "darcs", "record", "--author", "fake@example.com", "--no-interactive", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("sees nothing in a clean repository", func() {
+			files, err := probe.FileStatus(dir)
+			Expect(err).To(BeNil())
+			Expect(files).To(BeEmpty())
+		})
+
+		It("sees changed files", func() {
+			writeFile(dir, "foo", "changed")
+			writeFile(dir, "new", "file")
+			files, err := probe.FileStatus(dir)
+			Expect(err).To(BeNil())
+
+			Expect(files).To(ConsistOf(
+				FileStatus{Path: "foo", Index: FileUnmodified, Worktree: FileModified},
+				FileStatus{Path: "new", Index: FileUnmodified, Worktree: FileUntracked},
+			))
+		})
+	})
+
+	Describe("DiffStat", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			run(dir, "darcs", "init")
+			writeFile(dir, "foo", "one\ntwo\nthree\n")
+			run(dir, "darcs", "add", "foo")
+			run(dir, "darcs", "record", "--author", "fake@example.com", "--no-interactive", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("counts changed files and lines", func() {
+			writeFile(dir, "foo", "one\nTWO\nthree\nfour\n")
+			stat, err := probe.DiffStat(dir)
+			Expect(err).To(BeNil())
+
+			Expect(stat).To(Equal(DiffStat{
+				FilesChanged: 1,
+				Insertions:   2,
+				Deletions:    1,
+			}))
+		})
+	})
 })
@@ -0,0 +1,117 @@
+package vcsinfo
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DiffStat summarizes the differences between the working copy and the current
+// changeset.
+type DiffStat struct {
+	// The number of files that differ.
+	FilesChanged int `json:"files_changed" xml:"filesChanged"`
+
+	// The number of lines that have been added.
+	Insertions int `json:"insertions" xml:"insertions"`
+
+	// The number of lines that have been removed.
+	Deletions int `json:"deletions" xml:"deletions"`
+}
+
+// DiffStatProbe is a VcsProbe that is also capable of summarizing the
+// differences in a working copy. Doing so generally requires diffing every
+// changed file, so it is considerably more expensive than GatherInfo, and is
+// therefore not part of the information it gathers.
+type DiffStatProbe interface {
+	VcsProbe
+
+	// DiffStat summarizes the differences between the working copy of the
+	// repository at the specified path and the current changeset.
+	DiffStat(path string) (DiffStat, error)
+
+	// DiffStatContext summarizes the differences between the working copy of
+	// the repository at the specified path and the current changeset,
+	// abandoning the work when the context expires.
+	DiffStatContext(ctx context.Context, path string) (DiffStat, error)
+}
+
+// FormatUsesDiffStat indicates whether or not the format string contains codes
+// that render the DiffStat, meaning it needs to be gathered before the format
+// string is rendered.
+func FormatUsesDiffStat(format string) (bool, error) {
+	fields, err := FormatFields(format)
+	if err != nil {
+		return false, err
+	}
+	return fields&FieldDiffStat != 0, nil
+}
+
+// diffStatSummary matches the summary line produced by "diff --stat" (e.g.,
+// "2 files changed, 3 insertions(+), 1 deletion(-)").
+var diffStatSummary = regexp.MustCompile(`(\d+) files? changed(?:, (\d+) insertions?\(\+\))?(?:, (\d+) deletions?\(-\))?`)
+
+// parseDiffStatSummary extracts the DiffStat from the summary line of
+// "diff --stat" output.
+func parseDiffStatSummary(lines []string) DiffStat {
+	stat := DiffStat{}
+	for _, line := range lines {
+		match := diffStatSummary.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		stat.FilesChanged, _ = strconv.Atoi(match[1])
+		stat.Insertions, _ = strconv.Atoi(match[2])
+		stat.Deletions, _ = strconv.Atoi(match[3])
+	}
+	return stat
+}
+
+// hunkHeader matches the header of a hunk in a unified diff, capturing the
+// number of lines it covers in the old and new files.
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+
+func hunkLength(length string) int {
+	if length == "" {
+		// The length is omitted when it's one line.
+		return 1
+	}
+	value, _ := strconv.Atoi(length)
+	return value
+}
+
+// parseUnifiedDiff tallies the files and lines changed in a unified diff.
+func parseUnifiedDiff(lines []string) DiffStat {
+	stat := DiffStat{}
+	oldLeft, newLeft := 0, 0
+
+	for _, line := range lines {
+		if oldLeft > 0 || newLeft > 0 {
+			// Within a hunk, every line is part of the diff, even ones that
+			// look like file headers.
+			switch {
+			case strings.HasPrefix(line, "+"):
+				stat.Insertions++
+				newLeft--
+			case strings.HasPrefix(line, "-"):
+				stat.Deletions++
+				oldLeft--
+			case strings.HasPrefix(line, `\`):
+				// "\ No newline at end of file"
+			default:
+				oldLeft--
+				newLeft--
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "+++ ") {
+			stat.FilesChanged++
+		} else if match := hunkHeader.FindStringSubmatch(line); match != nil {
+			oldLeft, newLeft = hunkLength(match[1]), hunkLength(match[2])
+		}
+	}
+
+	return stat
+}
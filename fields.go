@@ -0,0 +1,279 @@
+package vcsinfo
+
+import (
+	"context"
+	"text/template"
+	"text/template/parse"
+)
+
+// FieldMask identifies groups of VcsInfo fields, so that probes can skip the
+// work of gathering information that isn't needed. The VcsName, Path, and
+// RepositoryRoot fields are always gathered.
+type FieldMask uint
+
+// The groups of VcsInfo fields that can be requested in a FieldMask.
+const (
+	// HasStaged, HasModified, HasConflicts, their counts, and
+	// EmptyChangeset.
+	FieldStatus FieldMask = 1 << iota
+
+	// HasStashed and StashCount.
+	FieldStash
+
+	// Branch, Detached, DetachedLabel, and Workspace.
+	FieldBranch
+
+	// Hash and ShortHash.
+	FieldHash
+
+	// Revision.
+	FieldRevision
+
+	// Tags, NearestTag, and NearestTagDistance.
+	FieldTags
+
+	// CommitAuthor, CommitEmail, CommitTime, and CommitSubject.
+	FieldCommit
+
+	// Upstream, Ahead, and Behind.
+	FieldUpstream
+
+	// RemoteName, RemoteURL, RemoteProvider, RemoteHost, RemoteOwner, and
+	// RemoteRepo.
+	FieldRemote
+
+	// Operation.
+	FieldOperation
+
+	// HasNew and UntrackedCount. Finding the untracked files can be a lot
+	// more work than finding the changed ones (e.g., for Perforce).
+	FieldUntracked
+
+	// DiffStat. Probes don't gather it themselves; it's retrieved separately
+	// from DiffStatProbes when needed.
+	FieldDiffStat
+
+	// FieldAll requests every field.
+	FieldAll FieldMask = 1<<iota - 1
+)
+
+// fieldMasks maps the names of the VcsInfo fields to the groups they are
+// gathered in.
+var fieldMasks = map[string]FieldMask{
+	"VcsName":            0,
+	"Path":               0,
+	"RepositoryRoot":     0,
+	"ShortHash":          FieldHash,
+	"Hash":               FieldHash,
+	"Revision":           FieldRevision,
+	"Branch":             FieldBranch,
+	"Detached":           FieldBranch,
+	"DetachedLabel":      FieldBranch,
+	"Workspace":          FieldBranch,
+	"Tags":               FieldTags,
+	"NearestTag":         FieldTags,
+	"NearestTagDistance": FieldTags,
+	"CommitAuthor":       FieldCommit,
+	"CommitEmail":        FieldCommit,
+	"CommitTime":         FieldCommit,
+	"CommitSubject":      FieldCommit,
+	"Upstream":           FieldUpstream,
+	"Ahead":              FieldUpstream,
+	"Behind":             FieldUpstream,
+	"RemoteName":         FieldRemote,
+	"RemoteURL":          FieldRemote,
+	"RemoteProvider":     FieldRemote,
+	"RemoteHost":         FieldRemote,
+	"RemoteOwner":        FieldRemote,
+	"RemoteRepo":         FieldRemote,
+	"Operation":          FieldOperation,
+	"HasStaged":          FieldStatus,
+	"HasModified":        FieldStatus,
+	"HasNew":             FieldUntracked,
+	"HasStashed":         FieldStash,
+	"HasConflicts":       FieldStatus,
+	"EmptyChangeset":     FieldStatus,
+	"StagedCount":        FieldStatus,
+	"ModifiedCount":      FieldStatus,
+	"UntrackedCount":     FieldUntracked,
+	"ConflictCount":      FieldStatus,
+	"StashCount":         FieldStash,
+	"DiffStat":           FieldDiffStat,
+}
+
+// codeFields maps the format codes to the groups of fields they render.
+var codeFields = map[rune]FieldMask{
+	'h': FieldHash,
+	's': FieldHash,
+	'r': FieldRevision,
+	'v': FieldHash | FieldRevision,
+	'b': FieldBranch,
+	'd': FieldBranch,
+	'w': FieldBranch,
+	'T': FieldTags,
+	'l': FieldTags,
+	'L': FieldTags,
+	'W': FieldCommit,
+	'E': FieldCommit,
+	'S': FieldCommit,
+	'D': FieldCommit,
+	'g': FieldCommit,
+	'U': FieldUpstream,
+	'A': FieldUpstream,
+	'B': FieldUpstream,
+	'R': FieldRemote,
+	'o': FieldOperation,
+	'u': FieldUntracked,
+	'a': FieldStatus,
+	'm': FieldStatus,
+	'c': FieldStatus,
+	't': FieldStash,
+	'*': FieldStatus | FieldUntracked,
+	'F': FieldDiffStat,
+	'+': FieldDiffStat,
+	'-': FieldDiffStat,
+}
+
+// FieldMaskProbe is a VcsProbe that is capable of only gathering some of the
+// VCS information, which can considerably reduce the number of VCS commands
+// it has to run.
+type FieldMaskProbe interface {
+	VcsProbe
+
+	// GatherInfoFields extracts and returns the requested VCS information for
+	// the repository at the specified path, abandoning any outstanding work
+	// when the context expires. Fields outside of the mask may still be
+	// populated if they came along with the requested ones.
+	GatherInfoFields(ctx context.Context, path string, fields FieldMask) (VcsInfo, []error)
+}
+
+// GatherInfoWithFields extracts and returns the requested VCS information for
+// the repository at the specified path. If the probe isn't a FieldMaskProbe,
+// all of the information is gathered.
+func GatherInfoWithFields(ctx context.Context, probe VcsProbe, path string, fields FieldMask) (VcsInfo, []error) {
+	if maskProbe, ok := probe.(FieldMaskProbe); ok {
+		return maskProbe.GatherInfoFields(ctx, path, fields)
+	}
+	return probe.GatherInfoContext(ctx, path)
+}
+
+// when returns the routine if any of the specified fields are in the mask, and
+// nil (which waitGroup skips) otherwise.
+func (mask FieldMask) when(fields FieldMask, routine func() error) func() error {
+	if mask&fields == 0 {
+		return nil
+	}
+	return routine
+}
+
+// FormatFields returns the fields that are needed to render the format string.
+func FormatFields(format string) (FieldMask, error) {
+	nodes, err := parseFormat(format)
+	if err != nil {
+		return 0, err
+	}
+	return formatNodeFields(nodes), nil
+}
+
+func formatNodeFields(nodes []formatNode) FieldMask {
+	var fields FieldMask
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case codeNode:
+			fields |= codeFields[node.code]
+		case groupNode:
+			fields |= formatNodeFields(node.children)
+		case conditionalNode:
+			fields |= codeFields[node.code]
+			fields |= formatNodeFields(node.then)
+			fields |= formatNodeFields(node.otherwise)
+		}
+	}
+	return fields
+}
+
+// TemplateFields returns the fields that are needed to render the Go template.
+// If the template uses the VcsInfo in a way that can't be analyzed (e.g.,
+// passing it to a function), all fields are needed.
+func TemplateFields(tmpl string) (FieldMask, error) {
+	parsed, err := template.New("vcsinfo").
+		Funcs(makeTemplateFuncs(VcsInfo{}, FormatOptions{})).
+		Parse(tmpl)
+	if err != nil {
+		return 0, err
+	}
+
+	var fields FieldMask
+	for _, defined := range parsed.Templates() {
+		if defined.Tree != nil {
+			fields |= templateNodeFields(defined.Tree.Root)
+		}
+	}
+	return fields, nil
+}
+
+func fieldNameMask(name string) FieldMask {
+	if mask, ok := fieldMasks[name]; ok {
+		return mask
+	}
+	return FieldAll
+}
+
+func templateNodeFields(node parse.Node) FieldMask {
+	var fields FieldMask
+
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node != nil {
+			for _, child := range node.Nodes {
+				fields |= templateNodeFields(child)
+			}
+		}
+	case *parse.ActionNode:
+		fields |= templateNodeFields(node.Pipe)
+	case *parse.IfNode:
+		fields |= templateBranchFields(&node.BranchNode)
+	case *parse.RangeNode:
+		fields |= templateBranchFields(&node.BranchNode)
+	case *parse.WithNode:
+		fields |= templateBranchFields(&node.BranchNode)
+	case *parse.TemplateNode:
+		fields |= templateNodeFields(node.Pipe)
+	case *parse.PipeNode:
+		if node != nil {
+			for _, cmd := range node.Cmds {
+				fields |= templateNodeFields(cmd)
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			fields |= templateNodeFields(arg)
+		}
+	case *parse.FieldNode:
+		fields |= fieldNameMask(node.Ident[0])
+	case *parse.ChainNode:
+		fields |= templateNodeFields(node.Node)
+	case *parse.VariableNode:
+		if node.Ident[0] != "$" {
+			// Variables hold values that have already been accounted for.
+			break
+		}
+		if len(node.Ident) > 1 {
+			fields |= fieldNameMask(node.Ident[1])
+		} else {
+			fields |= FieldAll
+		}
+	case *parse.DotNode:
+		// This is either the VcsInfo itself, or (within range/with) one of
+		// its fields. Either way, there's no telling what's needed.
+		fields |= FieldAll
+	}
+
+	return fields
+}
+
+func templateBranchFields(node *parse.BranchNode) FieldMask {
+	return templateNodeFields(node.Pipe) |
+		templateNodeFields(node.List) |
+		templateNodeFields(node.ElseList)
+}
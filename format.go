@@ -0,0 +1,511 @@
+package vcsinfo
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatError describes a problem encountered while parsing a format string.
+type FormatError struct {
+	// The position (in characters, starting at 1) within the format string
+	// where the problem was found.
+	Position int
+
+	// A description of the problem.
+	Message string
+}
+
+func (err *FormatError) Error() string {
+	return fmt.Sprintf("%s at position %d", err.Message, err.Position)
+}
+
+// formatCode renders a piece of the VcsInfo, returning the text to output and
+// whether or not the information it represents was actually present.
+type formatCode func(info VcsInfo, options FormatOptions) (string, bool)
+
+func unknownOr(value string, options FormatOptions) (string, bool) {
+	if value == "" {
+		return options.Unknown, false
+	}
+	return value, true
+}
+
+func indicator(isSet bool, value string) (string, bool) {
+	if isSet {
+		return value, true
+	}
+	return "", false
+}
+
+// relativeAge describes how long ago the time was (e.g., "3h ago").
+func relativeAge(then time.Time) string {
+	age := time.Since(then)
+	if age < 0 {
+		age = 0
+	}
+
+	units := []struct {
+		size   time.Duration
+		suffix string
+	}{
+		{365 * 24 * time.Hour, "y"},
+		{30 * 24 * time.Hour, "mo"},
+		{7 * 24 * time.Hour, "w"},
+		{24 * time.Hour, "d"},
+		{time.Hour, "h"},
+		{time.Minute, "m"},
+	}
+	for _, unit := range units {
+		if age >= unit.size {
+			return fmt.Sprintf("%d%s ago", age/unit.size, unit.suffix)
+		}
+	}
+
+	return fmt.Sprintf("%ds ago", age/time.Second)
+}
+
+func count(value int) (string, bool) {
+	if value > 0 {
+		return strconv.Itoa(value), true
+	}
+	return "", false
+}
+
+var formatCodes = map[rune]formatCode{
+	'n': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return info.VcsName, info.VcsName != ""
+	},
+	'h': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return unknownOr(info.Hash, options)
+	},
+	's': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return unknownOr(info.ShortHash, options)
+	},
+	'r': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return unknownOr(info.Revision, options)
+	},
+	'v': func(info VcsInfo, options FormatOptions) (string, bool) {
+		if info.ShortHash != "" {
+			return info.ShortHash, true
+		} else if info.Revision != "" {
+			return info.Revision, true
+		}
+		return unknownOr(info.Hash, options)
+	},
+	'b': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return unknownOr(info.Branch, options)
+	},
+	'd': func(info VcsInfo, options FormatOptions) (string, bool) {
+		if info.Branch != "" {
+			return info.Branch, true
+		}
+		return unknownOr(info.DetachedLabel, options)
+	},
+	'w': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return unknownOr(info.Workspace, options)
+	},
+	'T': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return strings.Join(info.Tags, ","), len(info.Tags) > 0
+	},
+	'l': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return unknownOr(info.NearestTag, options)
+	},
+	'L': func(info VcsInfo, options FormatOptions) (string, bool) {
+		if info.NearestTag != "" {
+			return strconv.Itoa(info.NearestTagDistance), true
+		}
+		return "", false
+	},
+	'W': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return unknownOr(info.CommitAuthor, options)
+	},
+	'E': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return unknownOr(info.CommitEmail, options)
+	},
+	'S': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return unknownOr(info.CommitSubject, options)
+	},
+	'D': func(info VcsInfo, options FormatOptions) (string, bool) {
+		if info.CommitTime.IsZero() {
+			return options.Unknown, false
+		}
+		return info.CommitTime.Format("2006-01-02 15:04:05 -0700"), true
+	},
+	'g': func(info VcsInfo, options FormatOptions) (string, bool) {
+		if info.CommitTime.IsZero() {
+			return options.Unknown, false
+		}
+		return relativeAge(info.CommitTime), true
+	},
+	'U': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return unknownOr(info.Upstream, options)
+	},
+	'A': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return count(info.Ahead)
+	},
+	'B': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return count(info.Behind)
+	},
+	'R': func(info VcsInfo, options FormatOptions) (string, bool) {
+		if info.RemoteOwner != "" && info.RemoteRepo != "" {
+			return info.RemoteOwner + "/" + info.RemoteRepo, true
+		}
+		return options.Unknown, false
+	},
+	'o': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return info.Operation, info.Operation != ""
+	},
+	'u': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return indicator(info.HasNew, options.HasNew)
+	},
+	'a': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return indicator(info.HasStaged, options.HasStaged)
+	},
+	'm': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return indicator(info.HasModified, options.HasModified)
+	},
+	't': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return indicator(info.HasStashed, options.HasStashed)
+	},
+	'c': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return indicator(info.HasConflicts, options.HasConflicts)
+	},
+	'F': func(info VcsInfo, options FormatOptions) (string, bool) {
+		if info.DiffStat == nil {
+			return "", false
+		}
+		return count(info.DiffStat.FilesChanged)
+	},
+	'+': func(info VcsInfo, options FormatOptions) (string, bool) {
+		if info.DiffStat == nil {
+			return "", false
+		}
+		return count(info.DiffStat.Insertions)
+	},
+	'-': func(info VcsInfo, options FormatOptions) (string, bool) {
+		if info.DiffStat == nil {
+			return "", false
+		}
+		return count(info.DiffStat.Deletions)
+	},
+	'P': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return info.RepositoryRoot, info.RepositoryRoot != ""
+	},
+	'p': func(info VcsInfo, options FormatOptions) (string, bool) {
+		relPath, err := filepath.Rel(info.RepositoryRoot, info.Path)
+		if err != nil {
+			return "", false
+		}
+		return relPath, true
+	},
+	'e': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return path.Base(info.RepositoryRoot), info.RepositoryRoot != ""
+	},
+}
+
+// countCodes are the codes whose indicators can be replaced with the number of
+// files/stashes they represent by using the "#" modifier (e.g., %#m).
+var countCodes = map[rune]formatCode{
+	'u': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return count(info.UntrackedCount)
+	},
+	'a': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return count(info.StagedCount)
+	},
+	'm': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return count(info.ModifiedCount)
+	},
+	't': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return count(info.StashCount)
+	},
+	'c': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return count(info.ConflictCount)
+	},
+}
+
+// conditions are the additional codes that can only be used in conditionals.
+var conditions = map[rune]formatCode{
+	// Whether or not the working copy has any changes.
+	'*': func(info VcsInfo, options FormatOptions) (string, bool) {
+		return "", info.HasNew || info.HasStaged || info.HasModified || info.HasConflicts
+	},
+}
+
+type formatNode interface{}
+
+// textNode is literal text to output as-is.
+type textNode string
+
+// codeNode is a %x code, or a %#x count.
+type codeNode struct {
+	code    rune
+	counted bool
+}
+
+// styleNode is a %{...} directive that applies colors/styles to the output
+// that follows it.
+type styleNode struct {
+	names []string
+}
+
+// groupNode is a %(...%) group, which is only output if at least one of the
+// codes within it has a value.
+type groupNode struct {
+	children []formatNode
+}
+
+// conditionalNode is a %?x(...%|...%) conditional, which outputs its first
+// branch if code x has a value, and its second branch otherwise.
+type conditionalNode struct {
+	code      rune
+	condition formatCode
+	then      []formatNode
+	otherwise []formatNode
+}
+
+type formatParser struct {
+	runes []rune
+	pos   int
+}
+
+func (parser *formatParser) fail(pos int, message string, args ...interface{}) error {
+	return &FormatError{
+		Position: pos + 1,
+		Message:  fmt.Sprintf(message, args...),
+	}
+}
+
+// parseSequence parses nodes until it reaches the end of the format string, or
+// a "%)" or "%|" that closes the enclosing group or conditional. It returns the
+// nodes, along with the closing character encountered (or 0 at the end of the
+// string) and its position.
+func (parser *formatParser) parseSequence() ([]formatNode, rune, int, error) {
+	nodes := []formatNode{}
+	var text bytes.Buffer
+
+	flushText := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, textNode(text.String()))
+			text.Reset()
+		}
+	}
+
+	for parser.pos < len(parser.runes) {
+		start := parser.pos
+		char := parser.runes[parser.pos]
+		parser.pos++
+
+		if char != '%' {
+			text.WriteRune(char)
+			continue
+		}
+
+		if parser.pos >= len(parser.runes) {
+			return nil, 0, 0, parser.fail(start, "incomplete formatting code \"%%\"")
+		}
+		char = parser.runes[parser.pos]
+		parser.pos++
+
+		switch char {
+		case '%':
+			text.WriteRune('%')
+
+		case ')', '|':
+			flushText()
+			return nodes, char, start, nil
+
+		case '(':
+			flushText()
+			children, closer, closerPos, err := parser.parseSequence()
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			if closer == 0 {
+				return nil, 0, 0, parser.fail(start, "unclosed group \"%%(\"")
+			}
+			if closer != ')' {
+				return nil, 0, 0, parser.fail(closerPos, "unexpected \"%%%c\" in group", closer)
+			}
+			nodes = append(nodes, groupNode{children: children})
+
+		case '{':
+			flushText()
+			node, err := parser.parseStyle(start)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			nodes = append(nodes, node)
+
+		case '#':
+			if parser.pos >= len(parser.runes) {
+				return nil, 0, 0, parser.fail(start, "incomplete formatting code \"%%#\"")
+			}
+			code := parser.runes[parser.pos]
+			if _, ok := countCodes[code]; !ok {
+				return nil, 0, 0, parser.fail(start, "unexpected formatting code \"%%#%c\"", code)
+			}
+			parser.pos++
+			flushText()
+			nodes = append(nodes, codeNode{code: code, counted: true})
+
+		case '?':
+			flushText()
+			node, err := parser.parseConditional(start)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			nodes = append(nodes, node)
+
+		default:
+			if _, ok := formatCodes[char]; !ok {
+				return nil, 0, 0, parser.fail(start, "unexpected formatting code \"%%%c\"", char)
+			}
+			flushText()
+			nodes = append(nodes, codeNode{code: char})
+		}
+	}
+
+	flushText()
+	return nodes, 0, 0, nil
+}
+
+func (parser *formatParser) parseConditional(start int) (formatNode, error) {
+	if parser.pos >= len(parser.runes) {
+		return nil, parser.fail(start, "incomplete conditional \"%%?\"")
+	}
+	code := parser.runes[parser.pos]
+	condition, ok := formatCodes[code]
+	if !ok {
+		condition, ok = conditions[code]
+	}
+	if !ok {
+		return nil, parser.fail(parser.pos, "unexpected formatting code \"%%%c\" in conditional", code)
+	}
+	parser.pos++
+
+	if parser.pos >= len(parser.runes) || parser.runes[parser.pos] != '(' {
+		return nil, parser.fail(parser.pos, "expected \"(\" after conditional \"%%?%c\"", code)
+	}
+	parser.pos++
+
+	node := conditionalNode{code: code, condition: condition}
+
+	then, closer, _, err := parser.parseSequence()
+	if err != nil {
+		return nil, err
+	}
+	node.then = then
+
+	if closer == '|' {
+		otherwise, elseCloser, elseCloserPos, err := parser.parseSequence()
+		if err != nil {
+			return nil, err
+		}
+		if elseCloser == '|' {
+			return nil, parser.fail(elseCloserPos, "unexpected \"%%|\" in conditional")
+		}
+		node.otherwise = otherwise
+		closer = elseCloser
+	}
+
+	if closer == 0 {
+		return nil, parser.fail(start, "unclosed conditional \"%%?%c(\"", code)
+	}
+
+	return node, nil
+}
+
+func (parser *formatParser) parseStyle(start int) (formatNode, error) {
+	end := parser.pos
+	for end < len(parser.runes) && parser.runes[end] != '}' {
+		end++
+	}
+	if end >= len(parser.runes) {
+		return nil, parser.fail(start, "unclosed style \"%%{\"")
+	}
+
+	node := styleNode{}
+	for _, name := range strings.Split(string(parser.runes[parser.pos:end]), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := styles[name]; !ok {
+			return nil, parser.fail(start, "unknown style %q", name)
+		}
+		node.names = append(node.names, name)
+	}
+
+	parser.pos = end + 1
+	return node, nil
+}
+
+func parseFormat(format string) ([]formatNode, error) {
+	parser := formatParser{runes: []rune(format)}
+
+	nodes, closer, closerPos, err := parser.parseSequence()
+	if err != nil {
+		return nil, err
+	}
+	if closer != 0 {
+		return nil, parser.fail(closerPos, "unexpected \"%%%c\"", closer)
+	}
+
+	return nodes, nil
+}
+
+// renderFormat produces the output for the nodes, along with whether or not
+// any of the codes within them had a value.
+func renderFormat(nodes []formatNode, info VcsInfo, options FormatOptions) (string, bool) {
+	var buf bytes.Buffer
+	anySet := false
+
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case textNode:
+			buf.WriteString(string(node))
+
+		case codeNode:
+			render := formatCodes[node.code]
+			if node.counted {
+				render = countCodes[node.code]
+			}
+			out, isSet := render(info, options)
+			buf.WriteString(out)
+			anySet = anySet || isSet
+
+		case styleNode:
+			buf.WriteString(renderStyles(node.names, options.ColorMode))
+
+		case groupNode:
+			out, isSet := renderFormat(node.children, info, options)
+			if isSet {
+				buf.WriteString(out)
+				anySet = true
+			}
+
+		case conditionalNode:
+			branch := node.otherwise
+			if _, isSet := node.condition(info, options); isSet {
+				branch = node.then
+			}
+			out, isSet := renderFormat(branch, info, options)
+			buf.WriteString(out)
+			anySet = anySet || isSet || hasText(branch)
+		}
+	}
+
+	return buf.String(), anySet
+}
+
+// hasText indicates whether or not the nodes include any literal text.
+func hasText(nodes []formatNode) bool {
+	for _, node := range nodes {
+		if text, ok := node.(textNode); ok && text != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,21 @@
 package vcsinfo
 
 import (
+	"context"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// fossilDateLayout is the layout of the timestamps reported by "fossil info".
+const fossilDateLayout = "2006-01-02 15:04:05 MST"
+
+// fossilCommentPattern splits the comment reported by "fossil info" into the
+// check-in comment and the user who made it.
+var fossilCommentPattern = regexp.MustCompile(`^(.*) \(user: ([^)]*)\)`)
+
 // FossilProbe is a probe for extracting information out of a Fossil repository.
 type FossilProbe struct{}
 
@@ -16,7 +27,7 @@ func (probe FossilProbe) Name() string {
 // DefaultFormat returns the default format string to use for Fossil
 // repositories.
 func (probe FossilProbe) DefaultFormat() string {
-	return "%n[%b%m%u]"
+	return "%n[%b%c%m%u]"
 }
 
 // IsAvailable indicates whether or not this probe has the tools/environment
@@ -31,13 +42,22 @@ func (probe FossilProbe) IsRepositoryRoot(path string) (bool, error) {
 	return fileExists(filepath.Join(path, ".fslckout"))
 }
 
-func (probe FossilProbe) extractInfo(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "fossil", "info")
+func (probe FossilProbe) extractInfo(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "fossil", "info")
 	if err != nil {
 		return err
 	}
 
+	comment, inComment := "", false
+
 	for _, line := range out {
+		// Long comments are wrapped onto indented lines.
+		if inComment && strings.HasPrefix(line, " ") {
+			comment += " " + strings.TrimSpace(line)
+			continue
+		}
+		inComment = false
+
 		parts := strings.SplitN(line, ":", 2)
 		if len(parts) != 2 {
 			continue
@@ -51,40 +71,100 @@ func (probe FossilProbe) extractInfo(path string, info *VcsInfo) error {
 		} else if field == "checkout" {
 			subparts := strings.SplitN(value, " ", 2)
 			info.Hash = subparts[0]
+			if len(subparts) == 2 {
+				commitTime, err := time.Parse(fossilDateLayout, subparts[1])
+				if err != nil {
+					return err
+				}
+				info.CommitTime = commitTime
+			}
+
+		} else if field == "comment" {
+			comment, inComment = value, true
 
 		} else if field == "tags" {
-			subparts := strings.SplitN(value, ", ", 2)
+			subparts := strings.Split(value, ", ")
 			if len(subparts) == 0 {
 				continue
 			}
 			info.Branch = subparts[0]
+			if len(subparts) > 1 {
+				info.Tags = subparts[1:]
+			}
 		}
 	}
 
+	if match := fossilCommentPattern.FindStringSubmatch(comment); match != nil {
+		info.CommitSubject = match[1]
+		info.CommitAuthor = match[2]
+	}
+
 	return nil
 }
 
-func (probe FossilProbe) extractChanges(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "fossil", "changes")
+func (probe FossilProbe) extractNearestTag(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "fossil", "describe", "--long")
 	if err != nil {
+		if getExitCode(err) > 0 {
+			// Either there aren't any tags to be found, or this version of
+			// Fossil predates the describe command.
+			return nil
+		}
 		return err
 	}
+	if len(out) == 0 {
+		return nil
+	}
 
-	if len(out) > 0 {
-		info.HasModified = true
+	tag, distance, ok := parseDescription(out[0])
+	if ok {
+		info.NearestTag = tag
+		info.NearestTagDistance = distance
+	}
+	return nil
+}
+
+func (probe FossilProbe) extractRemote(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "fossil", "remote-url")
+	if err != nil {
+		return err
+	}
+
+	if len(out) > 0 && out[0] != "off" {
+		setRemote(info, "", out[0])
 	}
 
 	return nil
 }
 
-func (probe FossilProbe) extractExtras(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "fossil", "extras")
+func (probe FossilProbe) extractChanges(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "fossil", "changes")
+	if err != nil {
+		return err
+	}
+
+	for _, line := range out {
+		if strings.HasPrefix(line, "CONFLICT") {
+			info.HasConflicts = true
+			info.ConflictCount++
+		} else {
+			info.HasModified = true
+			info.ModifiedCount++
+		}
+	}
+
+	return nil
+}
+
+func (probe FossilProbe) extractExtras(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "fossil", "extras")
 	if err != nil {
 		return err
 	}
 
 	if len(out) > 0 {
 		info.HasNew = true
+		info.UntrackedCount = len(out)
 	}
 
 	return nil
@@ -93,6 +173,21 @@ func (probe FossilProbe) extractExtras(path string, info *VcsInfo) error {
 // GatherInfo extracts and returns VCS information for the Fossil repository at
 // the specified path.
 func (probe FossilProbe) GatherInfo(path string) (VcsInfo, []error) {
+	return probe.GatherInfoContext(context.Background(), path)
+}
+
+// GatherInfoContext extracts and returns VCS information for the Fossil
+// repository at the specified path. If the context expires before all
+// information could be gathered, the fields collected so far are returned.
+func (probe FossilProbe) GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error) {
+	return probe.GatherInfoFields(ctx, path, FieldAll)
+}
+
+// GatherInfoFields extracts and returns the requested VCS information for the
+// Fossil repository at the specified path, skipping the work needed for the
+// other fields. If the context expires before all information could be
+// gathered, the fields collected so far are returned.
+func (probe FossilProbe) GatherInfoFields(ctx context.Context, path string, fields FieldMask) (VcsInfo, []error) {
 	info := VcsInfo{
 		VcsName: probe.Name(),
 		Path:    path,
@@ -100,17 +195,135 @@ func (probe FossilProbe) GatherInfo(path string) (VcsInfo, []error) {
 
 	errors := waitGroup(
 		func() error {
-			return probe.extractInfo(path, &info)
+			return probe.extractInfo(ctx, path, &info)
 		},
 
-		func() error {
-			return probe.extractChanges(path, &info)
-		},
+		fields.when(FieldTags, func() error {
+			return probe.extractNearestTag(ctx, path, &info)
+		}),
 
-		func() error {
-			return probe.extractExtras(path, &info)
-		},
+		fields.when(FieldStatus, func() error {
+			return probe.extractChanges(ctx, path, &info)
+		}),
+
+		fields.when(FieldUntracked, func() error {
+			return probe.extractExtras(ctx, path, &info)
+		}),
+
+		fields.when(FieldRemote, func() error {
+			return probe.extractRemote(ctx, path, &info)
+		}),
 	)
 
 	return info, errors
 }
+
+// fossilFileStates maps the states used by "fossil changes" to FileStates.
+var fossilFileStates = map[string]FileState{
+	"EDITED":               FileModified,
+	"UPDATED_BY_MERGE":     FileModified,
+	"UPDATED_BY_INTEGRATE": FileModified,
+	"EXECUTABLE":           FileModified,
+	"UNEXEC":               FileModified,
+	"SYMLINK":              FileModified,
+	"UNLINK":               FileModified,
+	"ADDED":                FileAdded,
+	"ADDED_BY_MERGE":       FileAdded,
+	"ADDED_BY_INTEGRATE":   FileAdded,
+	"DELETED":              FileDeleted,
+	"MISSING":              FileMissing,
+	"RENAMED":              FileRenamed,
+	"CONFLICT":             FileConflicted,
+}
+
+// FileStatus returns the status of each file in the Fossil checkout at the
+// specified path that differs from the current check-in.
+func (probe FossilProbe) FileStatus(path string) ([]FileStatus, error) {
+	return probe.FileStatusContext(context.Background(), path)
+}
+
+// FileStatusContext returns the status of each file in the Fossil checkout at
+// the specified path that differs from the current check-in, abandoning the
+// work when the context expires.
+func (probe FossilProbe) FileStatusContext(ctx context.Context, path string) ([]FileStatus, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := runCommand(ctx, root, "fossil", "changes")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []FileStatus{}
+	for _, line := range out {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		state, ok := fossilFileStates[parts[0]]
+		if !ok {
+			continue
+		}
+		files = append(files, worktreeStatus(strings.TrimSpace(parts[1]), state))
+	}
+
+	extras, err := runCommand(ctx, root, "fossil", "extras")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range extras {
+		if line != "" {
+			files = append(files, worktreeStatus(line, FileUntracked))
+		}
+	}
+
+	return files, nil
+}
+
+// DiffStat summarizes the differences between the Fossil checkout at the
+// specified path and the current check-in.
+func (probe FossilProbe) DiffStat(path string) (DiffStat, error) {
+	return probe.DiffStatContext(context.Background(), path)
+}
+
+// DiffStatContext summarizes the differences between the Fossil checkout at
+// the specified path and the current check-in, abandoning the work when the
+// context expires.
+func (probe FossilProbe) DiffStatContext(ctx context.Context, path string) (DiffStat, error) {
+	stat := DiffStat{}
+
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return stat, err
+	}
+
+	out, err := runCommand(ctx, root, "fossil", "diff", "--numstat")
+	if err != nil {
+		return stat, err
+	}
+
+	for _, line := range out {
+		// Each file is listed as "INSERTIONS DELETIONS PATH", followed by a
+		// line with the totals.
+		parts := strings.Fields(line)
+		if len(parts) < 3 || parts[2] == "TOTAL" {
+			continue
+		}
+		insertions, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		deletions, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+
+		stat.FilesChanged++
+		stat.Insertions += insertions
+		stat.Deletions += deletions
+	}
+
+	return stat, nil
+}
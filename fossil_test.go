@@ -1,6 +1,7 @@
 package vcsinfo_test
 
 import (
+	"context"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gstruct"
@@ -166,6 +167,40 @@ var _ = Describe("Fossil", func() {
 			}))
 		})
 
+		It("sees conflicts", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "fossil", "add", "foo")
+			run(dir, "fossil", "commit", "-m", "blah")
+
+			// Fossil "file has been updated" detection is poor. If we update the file too quickly, it doesn't see it as a change
+			time.Sleep(1001 * time.Millisecond)
+			writeFile(dir, "foo", "baz")
+			run(dir, "fossil", "commit", "-m", "other", "--branch", "other")
+			run(dir, "fossil", "update", "trunk")
+
+			time.Sleep(1001 * time.Millisecond)
+			writeFile(dir, "foo", "qux")
+			run(dir, "fossil", "commit", "-m", "trunk")
+			runIgnoringFailure(dir, "fossil", "merge", "other")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasConflicts": BeTrue(),
+			}))
+		})
+
+		It("sees tags", func() {
+			run(dir, "fossil", "tag", "add", "v1.0", "current")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Tags":   ConsistOf("v1.0"),
+				"Branch": Equal("trunk"),
+			}))
+		})
+
 		It("sees branches", func() {
 			writeFile(dir, "bar", "baz")
 			run(dir, "fossil", "add", "bar")
@@ -177,5 +212,122 @@ var _ = Describe("Fossil", func() {
 				"Branch": Equal("foobranch"),
 			}))
 		})
+
+		It("sees remotes", func() {
+			run(dir, "fossil", "remote-url", "https://fossil.example.com/owner/repo")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"RemoteURL":   Equal("https://fossil.example.com/owner/repo"),
+				"RemoteHost":  Equal("fossil.example.com"),
+				"RemoteOwner": Equal("owner"),
+				"RemoteRepo":  Equal("repo"),
+			}))
+		})
+
+		It("sees no remotes", func() {
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"RemoteURL": Equal(""),
+			}))
+		})
+
+		It("sees commit metadata", func() {
+			writeFile(dir, "bar", "baz")
+			run(dir, "fossil", "add", "bar")
+			run(dir, "fossil", "commit", "-m", "blah", "--user-override", "jay")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"CommitAuthor":  Equal("jay"),
+				"CommitTime":    BeTemporally("~", time.Now(), time.Minute),
+				"CommitSubject": Equal("blah"),
+			}))
+		})
+
+		It("returns partial info when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			info, err := probe.GatherInfoContext(ctx, dir)
+			Expect(err).To(ContainElement(context.Canceled))
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName": Equal("fossil"),
+				"Path":    Equal(dir),
+			}))
+		})
+	})
+
+	Describe("FileStatus", func() {
+		var dir, repoDir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			repoDir = tmpdir()
+			run(repoDir, "fossil", "init", "foorepo")
+			run(dir, "fossil", "open", repoDir+"/foorepo")
+			writeFile(dir, "foo", "bar")
+			run(dir, "fossil", "add", "foo")
+			run(dir, "fossil", "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			rmdir(repoDir)
+			dir = ""
+			repoDir = ""
+		})
+
+		It("sees changed files", func() {
+			writeFile(dir, "foo", "changed")
+			writeFile(dir, "added", "file")
+			run(dir, "fossil", "add", "added")
+			writeFile(dir, "new", "file")
+			files, err := probe.FileStatus(dir)
+			Expect(err).To(BeNil())
+
+			Expect(files).To(ConsistOf(
+				FileStatus{Path: "foo", Index: FileUnmodified, Worktree: FileModified},
+				FileStatus{Path: "added", Index: FileUnmodified, Worktree: FileAdded},
+				FileStatus{Path: "new", Index: FileUnmodified, Worktree: FileUntracked},
+			))
+		})
+	})
+
+	Describe("DiffStat", func() {
+		var dir, repoDir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			repoDir = tmpdir()
+			run(repoDir, "fossil", "init", "foorepo")
+			run(dir, "fossil", "open", repoDir+"/foorepo")
+			writeFile(dir, "foo", "one\ntwo\nthree\n")
+			run(dir, "fossil", "add", "foo")
+			run(dir, "fossil", "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			rmdir(repoDir)
+			dir = ""
+			repoDir = ""
+		})
+
+		It("counts changed files and lines", func() {
+			writeFile(dir, "foo", "one\nTWO\nthree\nfour\n")
+			stat, err := probe.DiffStat(dir)
+			Expect(err).To(BeNil())
+
+			Expect(stat).To(Equal(DiffStat{
+				FilesChanged: 1,
+				Insertions:   2,
+				Deletions:    1,
+			}))
+		})
 	})
 })
@@ -1,12 +1,37 @@
 package vcsinfo
 
 import (
+	"context"
+	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // GitProbe is a probe for extracting information out of a Git repository.
-type GitProbe struct{}
+type GitProbe struct {
+	// Native causes the probe to read the branch, hashes and stash directly
+	// from the repository's .git directory instead of running the git command
+	// for each of them. The git command is still used to determine the status
+	// of the working tree and its relationship to the upstream branch, as the
+	// native reader doesn't read the index.
+	//
+	// The native reader honours a numeric core.abbrev setting when producing
+	// the short hash, but it doesn't lengthen the short hash to keep it
+	// unique the way the git command does when core.abbrev is unset or
+	// "auto", so the two can differ in large repositories.
+	Native bool
+}
+
+// gitShortHashLength is the length of the short hashes reported by the native
+// reader when core.abbrev doesn't specify one (the default used by Git for
+// repositories of modest size).
+const gitShortHashLength = 7
+
+// gitMinShortHashLength is the shortest length that Git accepts for
+// core.abbrev.
+const gitMinShortHashLength = 4
 
 // Name returns the human-facing name of the probe.
 func (probe GitProbe) Name() string {
@@ -15,7 +40,7 @@ func (probe GitProbe) Name() string {
 
 // DefaultFormat returns the default format string to use for Git repositories.
 func (probe GitProbe) DefaultFormat() string {
-	return "%n[%b%a%m%u%t]"
+	return "%n[%d%c%a%m%u%t]"
 }
 
 // IsAvailable indicates whether or not this probe has the tools/environment
@@ -30,8 +55,8 @@ func (probe GitProbe) IsRepositoryRoot(path string) (bool, error) {
 	return dirExists(filepath.Join(path, ".git"))
 }
 
-func (probe GitProbe) extractStatus(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "git", "status", "--porcelain")
+func (probe GitProbe) extractStatus(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "git", "status", "--porcelain")
 	if err != nil {
 		exitCode := getExitCode(err)
 		if exitCode == 128 && strings.Contains(out[0], "must be run in a work tree") {
@@ -45,12 +70,19 @@ func (probe GitProbe) extractStatus(path string, info *VcsInfo) error {
 
 		if index == "?" || work == "?" {
 			info.HasNew = true
+			info.UntrackedCount++
+		} else if index == "U" || work == "U" || (index == "A" && work == "A") || (index == "D" && work == "D") {
+			// These combinations denote unmerged paths.
+			info.HasConflicts = true
+			info.ConflictCount++
 		} else {
 			if index != " " {
 				info.HasStaged = true
+				info.StagedCount++
 			}
 			if work != " " {
 				info.HasModified = true
+				info.ModifiedCount++
 			}
 		}
 	}
@@ -58,9 +90,14 @@ func (probe GitProbe) extractStatus(path string, info *VcsInfo) error {
 	return nil
 }
 
-func (probe GitProbe) extractBranch(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "git", "symbolic-ref", "--short", "HEAD")
+func (probe GitProbe) extractBranch(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "git", "symbolic-ref", "--quiet", "--short", "HEAD")
 	if err != nil {
+		exitCode := getExitCode(err)
+		if exitCode == 1 {
+			// HEAD isn't pointing at a branch.
+			return probe.extractDetachedLabel(ctx, path, info)
+		}
 		return err
 	}
 
@@ -68,8 +105,25 @@ func (probe GitProbe) extractBranch(path string, info *VcsInfo) error {
 	return nil
 }
 
-func (probe GitProbe) extractShortHash(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "git", "rev-parse", "--short", "HEAD")
+func (probe GitProbe) extractDetachedLabel(ctx context.Context, path string, info *VcsInfo) error {
+	info.Detached = true
+
+	out, err := runCommand(ctx, path, "git", "describe", "--tags", "--always")
+	if err != nil {
+		exitCode := getExitCode(err)
+		if exitCode == 128 {
+			// This generally means the repo doesn't have a commit yet.
+			return nil
+		}
+		return err
+	}
+
+	info.DetachedLabel = out[0]
+	return nil
+}
+
+func (probe GitProbe) extractShortHash(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "git", "rev-parse", "--short", "HEAD")
 	if err != nil {
 		exitCode := getExitCode(err)
 		if exitCode == 128 {
@@ -83,8 +137,8 @@ func (probe GitProbe) extractShortHash(path string, info *VcsInfo) error {
 	return nil
 }
 
-func (probe GitProbe) extractHash(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "git", "rev-parse", "HEAD")
+func (probe GitProbe) extractHash(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "git", "rev-parse", "HEAD")
 	if err != nil {
 		exitCode := getExitCode(err)
 		if exitCode == 128 {
@@ -98,8 +152,36 @@ func (probe GitProbe) extractHash(path string, info *VcsInfo) error {
 	return nil
 }
 
-func (probe GitProbe) extractStashed(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "git", "stash", "list")
+func (probe GitProbe) extractCommitMetadata(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "git", "log", "-1", "--format=%an%n%ae%n%cI%n%s")
+	if err != nil {
+		exitCode := getExitCode(err)
+		if exitCode == 128 {
+			// This generally means the repo doesn't have a commit yet.
+			return nil
+		}
+		return err
+	}
+	if len(out) < 3 {
+		return fmt.Errorf("unexpected output from git log: %s", out)
+	}
+
+	commitTime, err := time.Parse(time.RFC3339, out[2])
+	if err != nil {
+		return err
+	}
+
+	info.CommitAuthor = out[0]
+	info.CommitEmail = out[1]
+	info.CommitTime = commitTime
+	if len(out) > 3 {
+		info.CommitSubject = out[3]
+	}
+	return nil
+}
+
+func (probe GitProbe) extractStashed(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "git", "stash", "list")
 	if err != nil {
 		exitCode := getExitCode(err)
 		if exitCode == 1 && strings.Contains(out[0], "without a working tree") {
@@ -113,12 +195,263 @@ func (probe GitProbe) extractStashed(path string, info *VcsInfo) error {
 	}
 
 	info.HasStashed = len(out) > 0
+	info.StashCount = len(out)
+	return nil
+}
+
+func (probe GitProbe) extractTags(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "git", "tag", "--points-at", "HEAD")
+	if err != nil {
+		exitCode := getExitCode(err)
+		if exitCode == 129 {
+			// This generally means the repo doesn't have a commit yet.
+			return nil
+		}
+		return err
+	}
+	info.Tags = out
+
+	out, err = runCommand(ctx, path, "git", "describe", "--tags", "--long")
+	if err != nil {
+		exitCode := getExitCode(err)
+		if exitCode == 128 {
+			// This generally means there aren't any tags to be found.
+			return nil
+		}
+		return err
+	}
+
+	tag, distance, ok := parseDescription(out[0])
+	if ok {
+		info.NearestTag = tag
+		info.NearestTagDistance = distance
+	}
+	return nil
+}
+
+// gitPreferredRemotes are the remotes considered to be where a repository
+// comes from, in order of preference.
+var gitPreferredRemotes = []string{"origin", "upstream"}
+
+func (probe GitProbe) extractRemote(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "git", "config", "--get-regexp", `^remote\..*\.url$`)
+	if err != nil {
+		exitCode := getExitCode(err)
+		if exitCode == 1 {
+			// This means there aren't any remotes.
+			return nil
+		}
+		return err
+	}
+
+	names := []string{}
+	urls := make(map[string]string)
+	for _, line := range out {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(parts[0], "remote."), ".url")
+		names = append(names, name)
+		urls[name] = parts[1]
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	name := names[0]
+	for _, preferred := range gitPreferredRemotes {
+		if _, ok := urls[preferred]; ok {
+			name = preferred
+			break
+		}
+	}
+
+	setRemote(info, name, urls[name])
+	return nil
+}
+
+func (probe GitProbe) extractUpstream(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "git", "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{upstream}")
+	if err != nil {
+		exitCode := getExitCode(err)
+		if exitCode == 128 {
+			// This generally means there's no upstream configured, or there
+			// isn't a branch to have one.
+			return nil
+		}
+		return err
+	}
+
+	upstream := out[0]
+
+	out, err = runCommand(ctx, path, "git", "rev-list", "--left-right", "--count", "HEAD...@{upstream}")
+	if err != nil {
+		exitCode := getExitCode(err)
+		if exitCode == 128 {
+			// This generally means the upstream hasn't been fetched yet.
+			info.Upstream = upstream
+			return nil
+		}
+		return err
+	}
+
+	counts := strings.Fields(out[0])
+	if len(counts) != 2 {
+		return fmt.Errorf("unexpected output from git rev-list: %s", out[0])
+	}
+
+	ahead, err := strconv.Atoi(counts[0])
+	if err != nil {
+		return err
+	}
+	behind, err := strconv.Atoi(counts[1])
+	if err != nil {
+		return err
+	}
+
+	info.Upstream = upstream
+	info.Ahead = ahead
+	info.Behind = behind
+	return nil
+}
+
+func (probe GitProbe) readOperation(dir gitDir, info *VcsInfo) error {
+	for _, rebaseDir := range []string{"rebase-merge", "rebase-apply"} {
+		exists, err := dir.exists(rebaseDir)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+
+		info.Operation = OperationRebase
+		if rebaseDir == "rebase-merge" {
+			interactive, err := dir.exists("rebase-merge/interactive")
+			if err != nil {
+				return err
+			}
+			if interactive {
+				info.Operation = OperationRebaseInteractive
+			}
+		} else {
+			applying, err := dir.exists("rebase-apply/applying")
+			if err != nil {
+				return err
+			}
+			if applying {
+				info.Operation = OperationAm
+			}
+		}
+
+		// HEAD is detached during a rebase, so use the name of the branch
+		// being rebased instead.
+		headName, err := dir.readFile(rebaseDir + "/head-name")
+		if err == nil && strings.HasPrefix(headName, "refs/heads/") {
+			info.Branch = strings.TrimPrefix(headName, "refs/heads/")
+		}
+
+		return nil
+	}
+
+	stateFiles := []struct {
+		file      string
+		operation string
+	}{
+		{"MERGE_HEAD", OperationMerge},
+		{"CHERRY_PICK_HEAD", OperationCherryPick},
+		{"REVERT_HEAD", OperationRevert},
+		{"BISECT_LOG", OperationBisect},
+	}
+	for _, state := range stateFiles {
+		exists, err := dir.exists(state.file)
+		if err != nil {
+			return err
+		}
+		if exists {
+			info.Operation = state.operation
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (probe GitProbe) readBranch(ctx context.Context, path string, dir gitDir, info *VcsInfo) error {
+	ref, err := dir.symbolicRef("HEAD")
+	if err != nil {
+		return err
+	}
+	if ref == "" {
+		// HEAD isn't pointing at a branch. Finding the nearest tag means
+		// walking the history, so leave that to git.
+		return probe.extractDetachedLabel(ctx, path, info)
+	}
+
+	info.Branch = strings.TrimPrefix(ref, "refs/heads/")
+	return nil
+}
+
+func (probe GitProbe) readHash(dir gitDir, info *VcsInfo) error {
+	hash, err := dir.resolveRef("HEAD")
+	if err != nil || hash == "" {
+		// An empty hash generally means the repo doesn't have a commit yet.
+		return err
+	}
+
+	length, err := dir.shortHashLength()
+	if err != nil {
+		return err
+	}
+
+	info.Hash = hash
+	if length > 0 && len(hash) > length {
+		info.ShortHash = hash[0:length]
+	} else {
+		info.ShortHash = hash
+	}
+	return nil
+}
+
+func (probe GitProbe) readStashed(dir gitDir, info *VcsInfo) error {
+	exists, err := dir.refExists("refs/stash")
+	if err != nil {
+		return err
+	}
+
+	info.HasStashed = exists
+	if exists {
+		// Each stash is an entry in the reflog of the stash ref.
+		info.StashCount, err = dir.reflogLength("refs/stash")
+		if err != nil {
+			return err
+		}
+		if info.StashCount == 0 {
+			info.StashCount = 1
+		}
+	}
 	return nil
 }
 
 // GatherInfo extracts and returns VCS information for the Git repository at
 // the specified path.
 func (probe GitProbe) GatherInfo(path string) (VcsInfo, []error) {
+	return probe.GatherInfoContext(context.Background(), path)
+}
+
+// GatherInfoContext extracts and returns VCS information for the Git
+// repository at the specified path. If the context expires before all
+// information could be gathered, the fields collected so far are returned.
+func (probe GitProbe) GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error) {
+	return probe.GatherInfoFields(ctx, path, FieldAll)
+}
+
+// GatherInfoFields extracts and returns the requested VCS information for the
+// Git repository at the specified path, skipping the work needed for the
+// other fields. If the context expires before all information could be
+// gathered, the fields collected so far are returned.
+func (probe GitProbe) GatherInfoFields(ctx context.Context, path string, fields FieldMask) (VcsInfo, []error) {
 	info := VcsInfo{
 		VcsName: probe.Name(),
 		Path:    path,
@@ -130,27 +463,201 @@ func (probe GitProbe) GatherInfo(path string) (VcsInfo, []error) {
 	}
 	info.RepositoryRoot = root
 
-	errors := waitGroup(
-		func() error {
-			return probe.extractStatus(path, &info)
-		},
+	// This is checked before anything else, as it can tell the other
+	// extractors what to expect of HEAD.
+	dir := gitDir(filepath.Join(root, ".git"))
+	err = probe.readOperation(dir, &info)
+	if err != nil {
+		return info, []error{err}
+	}
+
+	extractors := []func() error{
+		fields.when(FieldStatus|FieldUntracked, func() error {
+			return probe.extractStatus(ctx, path, &info)
+		}),
 
-		func() error {
-			return probe.extractBranch(path, &info)
-		},
+		fields.when(FieldUpstream, func() error {
+			return probe.extractUpstream(ctx, path, &info)
+		}),
 
-		func() error {
-			return probe.extractHash(path, &info)
-		},
+		fields.when(FieldRemote, func() error {
+			return probe.extractRemote(ctx, path, &info)
+		}),
 
-		func() error {
-			return probe.extractShortHash(path, &info)
-		},
+		fields.when(FieldCommit, func() error {
+			return probe.extractCommitMetadata(ctx, path, &info)
+		}),
 
-		func() error {
-			return probe.extractStashed(path, &info)
-		},
-	)
+		fields.when(FieldTags, func() error {
+			return probe.extractTags(ctx, path, &info)
+		}),
+	}
+
+	native := probe.Native
+	if native {
+		// Fall back to the git command for ref formats we can't read.
+		reftable, err := dir.usesReftable()
+		if err != nil {
+			return info, []error{err}
+		}
+		native = !reftable
+	}
+
+	if native {
+		extractors = append(
+			extractors,
+
+			fields.when(FieldBranch, func() error {
+				return probe.readBranch(ctx, path, dir, &info)
+			}),
+
+			fields.when(FieldHash, func() error {
+				return probe.readHash(dir, &info)
+			}),
+
+			fields.when(FieldStash, func() error {
+				return probe.readStashed(dir, &info)
+			}),
+		)
+	} else {
+		extractors = append(
+			extractors,
+
+			fields.when(FieldBranch, func() error {
+				return probe.extractBranch(ctx, path, &info)
+			}),
+
+			fields.when(FieldHash, func() error {
+				return probe.extractHash(ctx, path, &info)
+			}),
+
+			fields.when(FieldHash, func() error {
+				return probe.extractShortHash(ctx, path, &info)
+			}),
+
+			fields.when(FieldStash, func() error {
+				return probe.extractStashed(ctx, path, &info)
+			}),
+		)
+	}
+
+	errors := waitGroup(extractors...)
 
 	return info, errors
 }
+
+// gitFileStates maps the codes used by "git status --porcelain" to FileStates.
+var gitFileStates = map[byte]FileState{
+	' ': FileUnmodified,
+	'M': FileModified,
+	'T': FileModified,
+	'A': FileAdded,
+	'D': FileDeleted,
+	'R': FileRenamed,
+	'C': FileCopied,
+}
+
+// unquoteGitPath decodes the paths that git quotes because they contain
+// unusual characters.
+func unquoteGitPath(path string) string {
+	if strings.HasPrefix(path, `"`) {
+		if unquoted, err := strconv.Unquote(path); err == nil {
+			return unquoted
+		}
+	}
+	return path
+}
+
+// FileStatus returns the status of each file in the working copy of the Git
+// repository at the specified path that differs from the current changeset.
+func (probe GitProbe) FileStatus(path string) ([]FileStatus, error) {
+	return probe.FileStatusContext(context.Background(), path)
+}
+
+// FileStatusContext returns the status of each file in the working copy of the
+// Git repository at the specified path that differs from the current
+// changeset, abandoning the work when the context expires.
+func (probe GitProbe) FileStatusContext(ctx context.Context, path string) ([]FileStatus, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := runCommand(ctx, root, "git", "status", "--porcelain", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []FileStatus{}
+	for _, line := range out {
+		if len(line) < 4 {
+			continue
+		}
+		index, work, name := line[0], line[1], line[3:]
+
+		file := FileStatus{}
+		if index == '?' {
+			file.Index, file.Worktree = FileUnmodified, FileUntracked
+		} else if index == 'U' || work == 'U' || (index == 'A' && work == 'A') || (index == 'D' && work == 'D') {
+			// These combinations denote unmerged paths.
+			file.Index, file.Worktree = FileConflicted, FileConflicted
+		} else {
+			file.Index, file.Worktree = gitFileStates[index], gitFileStates[work]
+		}
+
+		if index == 'R' || index == 'C' {
+			if parts := strings.SplitN(name, " -> ", 2); len(parts) == 2 {
+				file.OriginalPath = unquoteGitPath(parts[0])
+				name = parts[1]
+			}
+		}
+		file.Path = unquoteGitPath(name)
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// DiffStat summarizes the differences between the working copy of the Git
+// repository at the specified path and the current changeset.
+func (probe GitProbe) DiffStat(path string) (DiffStat, error) {
+	return probe.DiffStatContext(context.Background(), path)
+}
+
+// DiffStatContext summarizes the differences between the working copy of the
+// Git repository at the specified path and the current changeset, abandoning
+// the work when the context expires.
+func (probe GitProbe) DiffStatContext(ctx context.Context, path string) (DiffStat, error) {
+	stat := DiffStat{}
+
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return stat, err
+	}
+
+	out, err := runCommand(ctx, root, "git", "diff", "HEAD", "--numstat")
+	if err != nil {
+		if getExitCode(err) == 128 {
+			// This generally means the repo doesn't have a commit yet.
+			return stat, nil
+		}
+		return stat, err
+	}
+
+	for _, line := range out {
+		parts := strings.SplitN(line, "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		stat.FilesChanged++
+
+		// Binary files are reported as "-" rather than line counts.
+		insertions, _ := strconv.Atoi(parts[0])
+		deletions, _ := strconv.Atoi(parts[1])
+		stat.Insertions += insertions
+		stat.Deletions += deletions
+	}
+
+	return stat, nil
+}
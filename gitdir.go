@@ -0,0 +1,243 @@
+package vcsinfo
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// gitDir provides read-only access to the metadata stored in a Git
+// repository's .git directory, without needing to invoke the git command.
+type gitDir string
+
+// maxSymrefDepth is the number of symbolic references that will be followed
+// before giving up on resolving a ref (mirrors the limit used by Git itself).
+const maxSymrefDepth = 5
+
+func (dir gitDir) readFile(name string) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(string(dir), filepath.FromSlash(name)))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+// usesReftable indicates whether or not the repository stores its refs using
+// the reftable format, which this reader does not understand.
+func (dir gitDir) usesReftable() (bool, error) {
+	return dirExists(filepath.Join(string(dir), "reftable"))
+}
+
+// packedRef looks up the specified ref in the packed-refs file, returning an
+// empty string if it is not there.
+func (dir gitDir) packedRef(name string) (string, error) {
+	file, err := os.Open(filepath.Join(string(dir), "packed-refs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) == 2 && parts[1] == name {
+			return parts[0], nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+// symbolicRef returns the name of the ref that the specified symbolic ref
+// (e.g., "HEAD") points to, or an empty string if it is not symbolic.
+func (dir gitDir) symbolicRef(name string) (string, error) {
+	content, err := dir.readFile(name)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(content, "ref: ") {
+		return strings.TrimSpace(content[5:]), nil
+	}
+
+	return "", nil
+}
+
+// resolveRef returns the object hash the specified ref ultimately points to,
+// or an empty string if the ref does not exist (e.g., HEAD in a repository
+// without any commits).
+func (dir gitDir) resolveRef(name string) (string, error) {
+	for depth := 0; depth < maxSymrefDepth; depth++ {
+		content, err := dir.readFile(name)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return "", err
+			}
+			return dir.packedRef(name)
+		}
+
+		if !strings.HasPrefix(content, "ref: ") {
+			return content, nil
+		}
+		name = strings.TrimSpace(content[5:])
+	}
+
+	return "", fmt.Errorf("too many levels of symbolic refs at %s", name)
+}
+
+// refExists indicates whether or not the specified ref exists, either as a
+// loose ref or in the packed-refs file.
+func (dir gitDir) refExists(name string) (bool, error) {
+	hash, err := dir.resolveRef(name)
+	return hash != "", err
+}
+
+// reflogLength returns the number of entries in the reflog of the specified
+// ref, or zero if it doesn't have one.
+func (dir gitDir) reflogLength(name string) (int, error) {
+	file, err := os.Open(filepath.Join(string(dir), "logs", filepath.FromSlash(name)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if scanner.Text() != "" {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+// exists indicates whether or not the specified file or directory exists
+// within the .git directory.
+func (dir gitDir) exists(name string) (bool, error) {
+	return fileExists(filepath.Join(string(dir), filepath.FromSlash(name)))
+}
+
+// globalConfigPaths returns the paths of the user's global Git config files,
+// in the order in which they take precedence.
+func globalConfigPaths() []string {
+	paths := []string{}
+
+	home, err := os.UserHomeDir()
+	if err == nil {
+		paths = append(paths, filepath.Join(home, ".gitconfig"))
+	}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" && home != "" {
+		configHome = filepath.Join(home, ".config")
+	}
+	if configHome != "" {
+		paths = append(paths, filepath.Join(configHome, "git", "config"))
+	}
+
+	return paths
+}
+
+// readConfigFile looks up the specified key within a section that has no
+// subsection (e.g., "abbrev" in "core") in a Git config file, indicating
+// whether or not it was set.
+func readConfigFile(path string, section string, key string) (string, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return "", false, err
+	}
+	defer file.Close()
+
+	current, value, found := "", "", false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				continue
+			}
+			current = strings.TrimSpace(line[1:end])
+			line = strings.TrimSpace(line[end+1:])
+		}
+		if line == "" || line[0] == '#' || line[0] == ';' || !strings.EqualFold(current, section) {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if !strings.EqualFold(strings.TrimSpace(parts[0]), key) {
+			continue
+		}
+
+		// Later settings override earlier ones, and a key without a value
+		// is a boolean that is switched on.
+		found = true
+		value = "true"
+		if len(parts) == 2 {
+			value = parts[1]
+			if end := strings.IndexAny(value, "#;"); end >= 0 {
+				value = value[0:end]
+			}
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+
+	return value, found, scanner.Err()
+}
+
+// configValue looks up the specified key within a section that has no
+// subsection in the repository's config file and then the user's global ones,
+// returning an empty string if it isn't set. The system-wide config and any
+// included files are not consulted.
+func (dir gitDir) configValue(section string, key string) (string, error) {
+	paths := append([]string{filepath.Join(string(dir), "config")}, globalConfigPaths()...)
+	for _, path := range paths {
+		value, found, err := readConfigFile(path, section, key)
+		if err != nil || found {
+			return value, err
+		}
+	}
+
+	return "", nil
+}
+
+// shortHashLength returns the length of the short hashes requested by the
+// core.abbrev setting, or zero if hashes shouldn't be abbreviated.
+func (dir gitDir) shortHashLength() (int, error) {
+	value, err := dir.configValue("core", "abbrev")
+	if err != nil {
+		return 0, err
+	}
+
+	switch strings.ToLower(value) {
+	case "", "auto":
+		return gitShortHashLength, nil
+	case "no", "false", "off", "0":
+		return 0, nil
+	}
+
+	length, err := strconv.Atoi(value)
+	if err != nil || length < gitMinShortHashLength {
+		return 0, fmt.Errorf("invalid core.abbrev setting: %s", value)
+	}
+	return length, nil
+}
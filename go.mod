@@ -3,6 +3,8 @@ module github.com/jayclassless/vcsinfo
 go 1.17
 
 require (
+	github.com/BurntSushi/toml v1.2.1
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/onsi/ginkgo v1.16.5
 	github.com/onsi/gomega v1.16.0
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
@@ -11,7 +13,6 @@ require (
 require (
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751 // indirect
 	github.com/alecthomas/units v0.0.0-20210927113745-59d0afb8317a // indirect
-	github.com/fsnotify/fsnotify v1.4.9 // indirect
 	github.com/go-task/slim-sprig v0.0.0-20210107165309-348f09dbbbc0 // indirect
 	github.com/mattn/goveralls v0.0.11 // indirect
 	github.com/nxadm/tail v1.4.8 // indirect
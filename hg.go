@@ -1,12 +1,30 @@
 package vcsinfo
 
 import (
+	"context"
+	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // HgProbe is a probe for extracting information out of a Mercurial repository.
-type HgProbe struct{}
+type HgProbe struct {
+	// CompareUpstream causes the probe to count the changesets ahead of and
+	// behind the default path by running hg outgoing and hg incoming. Both
+	// contact the repository the path points to, which may be over the
+	// network, so the counts are left at zero unless this is set.
+	CompareUpstream bool
+}
+
+// hgUpstreamPath is the name of the path that the ahead/behind counts of a
+// Mercurial repository are calculated against.
+const hgUpstreamPath = "default"
+
+// hgCommitTemplate is the template used to retrieve the metadata of the
+// current changeset, in the form parseHgCommitMetadata expects.
+const hgCommitTemplate = "{node}\n{date|hgdate}\n{author}\n{desc|firstline}\n"
 
 // Name returns the human-facing name of the probe.
 func (probe HgProbe) Name() string {
@@ -16,7 +34,7 @@ func (probe HgProbe) Name() string {
 // DefaultFormat returns the default format string to use for Mercurial
 // repositories.
 func (probe HgProbe) DefaultFormat() string {
-	return "%n[%b%m%u%t]"
+	return "%n[%b%c%m%u%t]"
 }
 
 // IsAvailable indicates whether or not this probe has the tools/environment
@@ -31,8 +49,8 @@ func (probe HgProbe) IsRepositoryRoot(path string) (bool, error) {
 	return dirExists(filepath.Join(path, ".hg"))
 }
 
-func runHgCommand(workingDir string, command ...string) ([]string, error) {
-	out, err := runCommand(workingDir, append([]string{"hg"}, command[0:]...)...)
+func runHgCommand(ctx context.Context, workingDir string, command ...string) ([]string, error) {
+	out, err := runCommand(ctx, workingDir, append([]string{"hg"}, command[0:]...)...)
 
 	filtered := make([]string, 0)
 	for _, line := range out {
@@ -44,8 +62,9 @@ func runHgCommand(workingDir string, command ...string) ([]string, error) {
 	return filtered, err
 }
 
-func (probe HgProbe) extractStatus(path string, info *VcsInfo) error {
+func (probe HgProbe) extractStatus(ctx context.Context, path string, info *VcsInfo) error {
 	out, err := runHgCommand(
+		ctx,
 		path,
 		"status",
 		"--modified", "--added", "--removed", "--unknown", "--removed", "--deleted",
@@ -54,19 +73,46 @@ func (probe HgProbe) extractStatus(path string, info *VcsInfo) error {
 		return err
 	}
 
+	parseHgStatus(out, info)
+	return nil
+}
+
+// parseHgStatus tallies the files listed by "hg status" (or "sl status").
+func parseHgStatus(out []string, info *VcsInfo) {
 	for _, line := range out {
 		if strings.HasPrefix(line, "?") {
 			info.HasNew = true
+			info.UntrackedCount++
 		} else {
 			info.HasModified = true
+			info.ModifiedCount++
 		}
 	}
+}
+
+func (probe HgProbe) extractConflicts(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runHgCommand(ctx, path, "resolve", "--list")
+	if err != nil {
+		return err
+	}
 
+	parseHgConflicts(out, info)
 	return nil
 }
 
-func (probe HgProbe) extractCommitInfo(path string, info *VcsInfo) error {
-	out, err := runHgCommand(path, "identify", "--branch", "--num", "--id", "--debug")
+// parseHgConflicts tallies the unresolved files listed by "hg resolve --list"
+// (or "sl resolve --list").
+func parseHgConflicts(out []string, info *VcsInfo) {
+	for _, line := range out {
+		if strings.HasPrefix(line, "U ") {
+			info.HasConflicts = true
+			info.ConflictCount++
+		}
+	}
+}
+
+func (probe HgProbe) extractCommitInfo(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runHgCommand(ctx, path, "identify", "--branch", "--num", "--id", "--debug")
 	if err != nil || len(out) == 0 {
 		return err
 	}
@@ -102,8 +148,177 @@ func (probe HgProbe) extractCommitInfo(path string, info *VcsInfo) error {
 	return nil
 }
 
-func (probe HgProbe) extractShelved(path string, info *VcsInfo) error {
-	out, err := runHgCommand(path, "shelve", "--list")
+func (probe HgProbe) extractCommitMetadata(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runHgCommand(ctx, path, "log", "--rev", ".", "--template", hgCommitTemplate)
+	if err != nil {
+		return err
+	}
+
+	return parseHgCommitMetadata(out, info)
+}
+
+// parseHgCommitMetadata extracts the metadata of the current changeset from
+// the output of "hg log" (or "sl log") with hgCommitTemplate.
+func parseHgCommitMetadata(out []string, info *VcsInfo) error {
+	if len(out) < 3 {
+		return nil
+	}
+
+	if strings.HasPrefix(out[0], "0000000000000000000000000000000000000000") {
+		// There aren't any changesets yet.
+		return nil
+	}
+
+	// The date is the number of seconds since the epoch, followed by the
+	// offset of the timezone (in seconds west of UTC).
+	parts := strings.Fields(out[1])
+	if len(parts) != 2 {
+		return fmt.Errorf("unexpected date from hg log: %s", out[1])
+	}
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	offset, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return err
+	}
+	info.CommitTime = time.Unix(seconds, 0).In(time.FixedZone("", -offset))
+
+	info.CommitAuthor, info.CommitEmail = splitAuthor(out[2])
+	if len(out) > 3 {
+		info.CommitSubject = out[3]
+	}
+
+	return nil
+}
+
+func (probe HgProbe) countChangesets(ctx context.Context, path string, command string) (int, error) {
+	// Run non-interactively so that a remote asking for credentials fails
+	// rather than waiting on a prompt nobody will answer.
+	out, err := runHgCommand(ctx, path, "--noninteractive", command, "--quiet", "--template", "{node}\n", hgUpstreamPath)
+	if err != nil {
+		exitCode := getExitCode(err)
+		if exitCode == 1 {
+			// This means there weren't any changesets to report.
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	count := 0
+	for _, line := range out {
+		if line != "" {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// extractRemote reads the default path from the repository's configuration,
+// which is both the remote and the upstream the changesets are compared
+// against.
+func (probe HgProbe) extractRemote(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runHgCommand(ctx, path, "paths", hgUpstreamPath)
+	if err != nil {
+		exitCode := getExitCode(err)
+		if exitCode == 1 {
+			// This means there's no default path configured.
+			return nil
+		}
+		return err
+	}
+	if len(out) > 0 {
+		setRemote(info, hgUpstreamPath, out[0])
+		info.Upstream = hgUpstreamPath
+	}
+
+	return nil
+}
+
+func (probe HgProbe) extractUpstream(ctx context.Context, path string, info *VcsInfo) error {
+	ahead, err := probe.countChangesets(ctx, path, "outgoing")
+	if err != nil {
+		return err
+	}
+
+	behind, err := probe.countChangesets(ctx, path, "incoming")
+	if err != nil {
+		return err
+	}
+
+	info.Ahead = ahead
+	info.Behind = behind
+	return nil
+}
+
+func (probe HgProbe) readOperation(root string, info *VcsInfo) error {
+	stateFiles := []struct {
+		file      string
+		operation string
+	}{
+		{"rebasestate", OperationRebase},
+		{"histedit-state", OperationHistedit},
+		{"graftstate", OperationGraft},
+		{"shelvedstate", OperationUnshelve},
+		{"bisect.state", OperationBisect},
+
+		// These are checked last, as the other operations also record
+		// merge state while they are in progress.
+		{"merge/state2", OperationMerge},
+		{"merge/state", OperationMerge},
+	}
+
+	for _, state := range stateFiles {
+		exists, err := fileExists(filepath.Join(root, ".hg", filepath.FromSlash(state.file)))
+		if err != nil {
+			return err
+		}
+		if exists {
+			info.Operation = state.operation
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (probe HgProbe) extractTags(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runHgCommand(
+		ctx,
+		path,
+		"log", "--rev", ".",
+		"--template", "{tags}\n{latesttag}\n{latesttagdistance}\n",
+	)
+	if err != nil || len(out) < 3 {
+		return err
+	}
+
+	tags := []string{}
+	for _, tag := range strings.Fields(out[0]) {
+		if tag != "tip" {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > 0 {
+		info.Tags = tags
+	}
+
+	if out[1] != "null" && out[1] != "" {
+		distance, err := strconv.Atoi(out[2])
+		if err != nil {
+			return err
+		}
+		info.NearestTag = out[1]
+		info.NearestTagDistance = distance
+	}
+
+	return nil
+}
+
+func (probe HgProbe) extractShelved(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runHgCommand(ctx, path, "shelve", "--list")
 	if err != nil {
 		exitCode := getExitCode(err)
 		if exitCode == 255 {
@@ -114,12 +329,28 @@ func (probe HgProbe) extractShelved(path string, info *VcsInfo) error {
 	}
 
 	info.HasStashed = len(out) > 0
+	info.StashCount = len(out)
 	return nil
 }
 
 // GatherInfo extracts and returns VCS information for the Mercurial repository
 // at the specified path.
 func (probe HgProbe) GatherInfo(path string) (VcsInfo, []error) {
+	return probe.GatherInfoContext(context.Background(), path)
+}
+
+// GatherInfoContext extracts and returns VCS information for the Mercurial
+// repository at the specified path. If the context expires before all
+// information could be gathered, the fields collected so far are returned.
+func (probe HgProbe) GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error) {
+	return probe.GatherInfoFields(ctx, path, FieldAll)
+}
+
+// GatherInfoFields extracts and returns the requested VCS information for the
+// Mercurial repository at the specified path, skipping the work needed for the
+// other fields. If the context expires before all information could be
+// gathered, the fields collected so far are returned.
+func (probe HgProbe) GatherInfoFields(ctx context.Context, path string, fields FieldMask) (VcsInfo, []error) {
 	info := VcsInfo{
 		VcsName: probe.Name(),
 		Path:    path,
@@ -132,18 +363,174 @@ func (probe HgProbe) GatherInfo(path string) (VcsInfo, []error) {
 	info.RepositoryRoot = root
 
 	errors := waitGroup(
-		func() error {
-			return probe.extractStatus(path, &info)
-		},
+		fields.when(FieldStatus|FieldUntracked, func() error {
+			return probe.extractStatus(ctx, path, &info)
+		}),
+
+		fields.when(FieldStatus, func() error {
+			return probe.extractConflicts(ctx, path, &info)
+		}),
+
+		fields.when(FieldOperation, func() error {
+			return probe.readOperation(root, &info)
+		}),
+
+		fields.when(FieldBranch|FieldHash|FieldRevision, func() error {
+			return probe.extractCommitInfo(ctx, path, &info)
+		}),
+
+		fields.when(FieldCommit, func() error {
+			return probe.extractCommitMetadata(ctx, path, &info)
+		}),
+
+		fields.when(FieldStash, func() error {
+			return probe.extractShelved(ctx, path, &info)
+		}),
 
-		func() error {
-			return probe.extractCommitInfo(path, &info)
-		},
+		fields.when(FieldUpstream|FieldRemote, func() error {
+			err := probe.extractRemote(ctx, path, &info)
+			if err != nil || info.Upstream == "" || !probe.CompareUpstream || fields&FieldUpstream == 0 {
+				return err
+			}
+			return probe.extractUpstream(ctx, path, &info)
+		}),
 
-		func() error {
-			return probe.extractShelved(path, &info)
-		},
+		fields.when(FieldTags, func() error {
+			return probe.extractTags(ctx, path, &info)
+		}),
 	)
 
 	return info, errors
 }
+
+// hgFileStates maps the codes used by "hg status" to FileStates.
+var hgFileStates = map[string]FileState{
+	"M": FileModified,
+	"A": FileAdded,
+	"R": FileDeleted,
+	"!": FileMissing,
+	"?": FileUntracked,
+}
+
+// FileStatus returns the status of each file in the working copy of the
+// Mercurial repository at the specified path that differs from the current
+// changeset.
+func (probe HgProbe) FileStatus(path string) ([]FileStatus, error) {
+	return probe.FileStatusContext(context.Background(), path)
+}
+
+// FileStatusContext returns the status of each file in the working copy of the
+// Mercurial repository at the specified path that differs from the current
+// changeset, abandoning the work when the context expires.
+func (probe HgProbe) FileStatusContext(ctx context.Context, path string) ([]FileStatus, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := runHgCommand(
+		ctx,
+		root,
+		"status",
+		"--modified", "--added", "--removed", "--deleted", "--unknown", "--copies",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts, err := runHgCommand(ctx, root, "resolve", "--list")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseHgFileStatus(out, conflicts), nil
+}
+
+// parseHgFileStatus combines the output of "hg status --copies" and "hg
+// resolve --list" (or their sl equivalents) into the status of each file.
+func parseHgFileStatus(out []string, conflicts []string) []FileStatus {
+	files := []FileStatus{}
+	removed := map[string]bool{}
+	for _, line := range out {
+		if strings.HasPrefix(line, "  ") {
+			// This is the source of the copy listed before it.
+			if len(files) > 0 {
+				files[len(files)-1].Worktree = FileCopied
+				files[len(files)-1].OriginalPath = filepath.ToSlash(line[2:])
+			}
+			continue
+		}
+		if len(line) < 3 {
+			continue
+		}
+
+		state, ok := hgFileStates[line[0:1]]
+		if !ok {
+			continue
+		}
+		file := worktreeStatus(line[2:], state)
+		if state == FileDeleted {
+			removed[file.Path] = true
+		}
+		files = append(files, file)
+	}
+
+	// A copy whose source has been removed is a rename, in which case the
+	// source shouldn't be listed on its own.
+	renamed := map[string]bool{}
+	for idx, file := range files {
+		if file.Worktree == FileCopied && removed[file.OriginalPath] {
+			files[idx].Worktree = FileRenamed
+			renamed[file.OriginalPath] = true
+		}
+	}
+
+	conflicted := map[string]bool{}
+	for _, line := range conflicts {
+		if strings.HasPrefix(line, "U ") {
+			conflicted[filepath.ToSlash(line[2:])] = true
+		}
+	}
+
+	result := []FileStatus{}
+	for _, file := range files {
+		if file.Worktree == FileDeleted && renamed[file.Path] {
+			continue
+		}
+		if conflicted[file.Path] {
+			file.Worktree = FileConflicted
+			delete(conflicted, file.Path)
+		}
+		result = append(result, file)
+	}
+	for _, line := range conflicts {
+		if strings.HasPrefix(line, "U ") && conflicted[filepath.ToSlash(line[2:])] {
+			result = append(result, worktreeStatus(line[2:], FileConflicted))
+		}
+	}
+
+	return result
+}
+
+// DiffStat summarizes the differences between the working copy of the
+// Mercurial repository at the specified path and the current changeset.
+func (probe HgProbe) DiffStat(path string) (DiffStat, error) {
+	return probe.DiffStatContext(context.Background(), path)
+}
+
+// DiffStatContext summarizes the differences between the working copy of the
+// Mercurial repository at the specified path and the current changeset,
+// abandoning the work when the context expires.
+func (probe HgProbe) DiffStatContext(ctx context.Context, path string) (DiffStat, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	out, err := runHgCommand(ctx, root, "diff", "--stat")
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	return parseDiffStatSummary(out), nil
+}
@@ -1,6 +1,9 @@
 package vcsinfo_test
 
 import (
+	"context"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gstruct"
@@ -169,6 +172,23 @@ var _ = Describe("Mercurial", func() {
 			}))
 		})
 
+		It("counts changes", func() {
+			writeFile(dir, "foo", "bar")
+			writeFile(dir, "bar", "baz")
+			run(dir, "hg", "add", "foo", "bar")
+			run(dir, "hg", "commit", "-m", "blah")
+			writeFile(dir, "foo", "modified")
+			writeFile(dir, "bar", "modified")
+			writeFile(dir, "new", "file")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"ModifiedCount":  Equal(2),
+				"UntrackedCount": Equal(1),
+			}))
+		})
+
 		It("sees branches", func() {
 			run(dir, "hg", "branch", "foo")
 			info, err := probe.GatherInfo(dir)
@@ -179,9 +199,292 @@ var _ = Describe("Mercurial", func() {
 			}))
 		})
 
+		It("sees ahead/behind counts", func() {
+			comparingProbe := HgProbe{CompareUpstream: true}
+			writeFile(dir, "foo", "bar")
+			run(dir, "hg", "add", "foo")
+			run(dir, "hg", "commit", "-m", "blah")
+
+			clone := tmpdir()
+			defer rmdir(clone)
+			run(clone, "hg", "clone", dir, ".")
+
+			info, err := comparingProbe.GatherInfo(clone)
+			Expect(err).To(BeEmpty())
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Upstream": Equal("default"),
+				"Ahead":    Equal(0),
+				"Behind":   Equal(0),
+			}))
+
+			writeFile(clone, "foo", "baz")
+			run(clone, "hg", "commit", "-m", "ahead")
+			writeFile(dir, "bar", "baz")
+			run(dir, "hg", "add", "bar")
+			run(dir, "hg", "commit", "-m", "behind")
+
+			info, err = comparingProbe.GatherInfo(clone)
+			Expect(err).To(BeEmpty())
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Upstream": Equal("default"),
+				"Ahead":    Equal(1),
+				"Behind":   Equal(1),
+			}))
+		})
+
+		It("only compares against the upstream when asked", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "hg", "add", "foo")
+			run(dir, "hg", "commit", "-m", "blah")
+
+			clone := tmpdir()
+			defer rmdir(clone)
+			run(clone, "hg", "clone", dir, ".")
+			writeFile(clone, "foo", "baz")
+			run(clone, "hg", "commit", "-m", "ahead")
+
+			info, err := probe.GatherInfo(clone)
+			Expect(err).To(BeEmpty())
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Upstream":   Equal("default"),
+				"RemoteName": Equal("default"),
+				"Ahead":      Equal(0),
+				"Behind":     Equal(0),
+			}))
+		})
+
+		It("sees no upstream", func() {
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Upstream": Equal(""),
+				"Ahead":    Equal(0),
+				"Behind":   Equal(0),
+			}))
+		})
+
+		Describe("operations", func() {
+			BeforeEach(func() {
+				writeFile(dir, "foo", "bar")
+				run(dir, "hg", "add", "foo")
+				run(dir, "hg", "commit", "-m", "base")
+				run(dir, "hg", "branch", "other")
+				writeFile(dir, "foo", "baz")
+				run(dir, "hg", "commit", "-m", "other")
+				run(dir, "hg", "update", "default")
+				writeFile(dir, "foo", "qux")
+				run(dir, "hg", "commit", "-m", "default")
+			})
+
+			It("sees nothing when idle", func() {
+				info, err := probe.GatherInfo(dir)
+				Expect(err).To(BeEmpty())
+
+				Expect(info).To(MatchFields(IgnoreExtras, Fields{
+					"Operation": Equal(""),
+				}))
+			})
+
+			It("sees merges", func() {
+				runIgnoringFailure(dir, "hg", "merge", "--tool", "internal:fail", "other")
+				info, err := probe.GatherInfo(dir)
+				Expect(err).To(BeEmpty())
+
+				Expect(info).To(MatchFields(IgnoreExtras, Fields{
+					"Operation": Equal("merge"),
+					"Branch":    Equal("default"),
+				}))
+			})
+
+			It("sees conflicts", func() {
+				runIgnoringFailure(dir, "hg", "merge", "--tool", "internal:fail", "other")
+				info, err := probe.GatherInfo(dir)
+				Expect(err).To(BeEmpty())
+
+				Expect(info).To(MatchFields(IgnoreExtras, Fields{
+					"HasConflicts": BeTrue(),
+				}))
+			})
+
+			It("sees grafts", func() {
+				runIgnoringFailure(dir, "hg", "graft", "--tool", "internal:fail", "-r", "other")
+				info, err := probe.GatherInfo(dir)
+				Expect(err).To(BeEmpty())
+
+				Expect(info).To(MatchFields(IgnoreExtras, Fields{
+					"Operation": Equal("graft"),
+					"Branch":    Equal("default"),
+				}))
+			})
+
+			It("sees bisects", func() {
+				run(dir, "hg", "bisect", "--good", "0")
+				info, err := probe.GatherInfo(dir)
+				Expect(err).To(BeEmpty())
+
+				Expect(info).To(MatchFields(IgnoreExtras, Fields{
+					"Operation": Equal("bisect"),
+				}))
+			})
+		})
+
+		It("sees tags", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "hg", "add", "foo")
+			run(dir, "hg", "commit", "-m", "blah")
+			run(dir, "hg", "tag", "v1.0")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			// Tagging in Mercurial creates a new changeset.
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Tags":               BeEmpty(),
+				"NearestTag":         Equal("v1.0"),
+				"NearestTagDistance": Equal(1),
+			}))
+
+			run(dir, "hg", "update", "v1.0")
+			info, err = probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Tags":               ConsistOf("v1.0"),
+				"NearestTag":         Equal("v1.0"),
+				"NearestTagDistance": Equal(0),
+			}))
+		})
+
+		It("sees remotes", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "hg", "add", "foo")
+			run(dir, "hg", "commit", "-m", "blah")
+
+			clone := tmpdir()
+			defer rmdir(clone)
+			run(clone, "hg", "clone", dir, ".")
+
+			info, err := probe.GatherInfo(clone)
+			Expect(err).To(BeEmpty())
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"RemoteName": Equal("default"),
+				"RemoteURL":  Equal(dir),
+				"RemoteHost": Equal(""),
+			}))
+		})
+
+		It("sees no remotes", func() {
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"RemoteName": Equal(""),
+				"RemoteURL":  Equal(""),
+			}))
+		})
+
+		It("sees commit metadata", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "hg", "add", "foo")
+			run(dir, "hg", "commit", "--user", "Jay <jay@example.com>", "-m", "First line\n\nMore details")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"CommitAuthor":  Equal("Jay"),
+				"CommitEmail":   Equal("jay@example.com"),
+				"CommitTime":    BeTemporally("~", time.Now(), time.Minute),
+				"CommitSubject": Equal("First line"),
+			}))
+		})
+
+		It("sees no commit metadata before the first commit", func() {
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"CommitAuthor": Equal(""),
+				"CommitTime":   BeZero(),
+			}))
+		})
+
+		It("returns partial info when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			info, err := probe.GatherInfoContext(ctx, dir)
+			Expect(err).To(ContainElement(context.Canceled))
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName":        Equal("hg"),
+				"Path":           Equal(dir),
+				"RepositoryRoot": Equal(dir),
+			}))
+		})
+
 		It("doesnt crash when in VCS special dir", func() {
 			_, err := probe.GatherInfo(dir + "/.hg")
 			Expect(err).To(BeEmpty())
 		})
 	})
+
+	Describe("FileStatus", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			run(dir, "hg", "init")
+			writeFile(dir, "foo", "bar")
+			writeFile(dir, "baz", "qux")
+			run(dir, "hg", "add", "foo", "baz")
+			run(dir, "hg", "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("sees changed files", func() {
+			writeFile(dir, "foo", "changed")
+			writeFile(dir, "new", "file")
+			run(dir, "hg", "mv", "baz", "moved")
+			files, err := probe.FileStatus(dir)
+			Expect(err).To(BeNil())
+
+			Expect(files).To(ConsistOf(
+				FileStatus{Path: "foo", Index: FileUnmodified, Worktree: FileModified},
+				FileStatus{Path: "new", Index: FileUnmodified, Worktree: FileUntracked},
+				FileStatus{Path: "moved", Index: FileUnmodified, Worktree: FileRenamed, OriginalPath: "baz"},
+			))
+		})
+	})
+
+	Describe("DiffStat", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			run(dir, "hg", "init")
+			writeFile(dir, "foo", "one\ntwo\nthree\n")
+			run(dir, "hg", "add", "foo")
+			run(dir, "hg", "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("counts changed files and lines", func() {
+			writeFile(dir, "foo", "one\nTWO\nthree\nfour\n")
+			stat, err := probe.DiffStat(dir)
+			Expect(err).To(BeNil())
+
+			Expect(stat).To(Equal(DiffStat{
+				FilesChanged: 1,
+				Insertions:   2,
+				Deletions:    1,
+			}))
+		})
+	})
 })
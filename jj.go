@@ -0,0 +1,408 @@
+package vcsinfo
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JjProbe is a probe for extracting information out of a Jujutsu repository.
+// Jujutsu repositories that are colocated with a Git repository (i.e., that
+// have both .jj and .git directories) are identified as Jujutsu, as the Git
+// view of them is always a detached HEAD.
+type JjProbe struct {
+	// IgnoreWorkingCopy stops the probe from letting jj snapshot the working
+	// copy before gathering information. Snapshotting can be expensive in
+	// large repositories and updates the .jj directory, but without it, the
+	// information reflects the working copy as of the last jj command that
+	// snapshotted it, so changes made since then aren't reported.
+	IgnoreWorkingCopy bool
+}
+
+// jjLogTemplate is the template used to retrieve most of the information
+// about the working-copy commit in a single line, with tab-separated fields.
+// The description is last, as it's the only field that might contain tabs.
+const jjLogTemplate = `"vcsinfo\t" ++ change_id.short() ++ "\t" ++ commit_id ++ "\t" ++ commit_id.short() ++ "\t" ++ ` +
+	`local_bookmarks.map(|b| b.name()).join(",") ++ "\t" ++ tags.map(|t| t.name()).join(",") ++ "\t" ++ ` +
+	`if(conflict, "true", "false") ++ "\t" ++ if(empty, "true", "false") ++ "\t" ++ ` +
+	`author.name() ++ "\t" ++ author.email() ++ "\t" ++ author.timestamp().format("%s") ++ "\t" ++ ` +
+	`description.first_line() ++ "\n"`
+
+// jjConflict matches the lines output by "jj resolve --list", capturing the
+// path of the conflicted file.
+var jjConflict = regexp.MustCompile(`^(.*\S)\s+\d+-sided conflict`)
+
+// Name returns the human-facing name of the probe.
+func (probe JjProbe) Name() string {
+	return "jj"
+}
+
+// DefaultFormat returns the default format string to use for Jujutsu
+// repositories.
+func (probe JjProbe) DefaultFormat() string {
+	return "%n[%d%c%m]"
+}
+
+// IsAvailable indicates whether or not this probe has the tools/environment
+// necessary to operate.
+func (probe JjProbe) IsAvailable() (bool, error) {
+	return commandExists("jj"), nil
+}
+
+// IsRepositoryRoot identifies whether or not the specified path is the root
+// of a Jujutsu repository.
+func (probe JjProbe) IsRepositoryRoot(path string) (bool, error) {
+	return dirExists(filepath.Join(path, ".jj"))
+}
+
+func runJjCommand(ctx context.Context, workingDir string, command ...string) ([]string, error) {
+	return runCommand(ctx, workingDir, append([]string{"jj", "--color=never", "--no-pager"}, command[0:]...)...)
+}
+
+// extractCommitInfo retrieves the information about the working-copy commit.
+// As it is the first command run, it is also the one that snapshots the
+// working copy (unless the probe was told not to); the others are always run
+// with --ignore-working-copy so that they don't fight over doing so.
+func (probe JjProbe) extractCommitInfo(ctx context.Context, path string, info *VcsInfo) error {
+	command := []string{"log", "--revisions", "@", "--no-graph", "--template", jjLogTemplate}
+	if probe.IgnoreWorkingCopy {
+		command = append([]string{"--ignore-working-copy"}, command...)
+	}
+
+	out, err := runJjCommand(ctx, path, command...)
+	if err != nil {
+		return err
+	}
+
+	var parts []string
+	for _, line := range out {
+		// Skip past any warnings that were emitted while snapshotting.
+		if strings.HasPrefix(line, "vcsinfo\t") {
+			parts = strings.SplitN(line, "\t", 12)
+			break
+		}
+	}
+	if len(parts) < 11 {
+		return nil
+	}
+
+	info.Revision = parts[1]
+	info.Hash = parts[2]
+	info.ShortHash = parts[3]
+
+	if parts[4] != "" {
+		info.Branch = parts[4]
+	}
+	if parts[5] != "" {
+		info.Tags = strings.Split(parts[5], ",")
+	}
+
+	if parts[6] == "true" {
+		info.HasConflicts = true
+	}
+	info.EmptyChangeset = parts[7] == "true"
+	if !info.EmptyChangeset {
+		// The working copy's changes are the contents of the working-copy
+		// commit, so a non-empty one means there are changes.
+		info.HasModified = true
+	}
+
+	info.CommitAuthor = parts[8]
+	info.CommitEmail = parts[9]
+	if seconds, err := strconv.ParseInt(parts[10], 10, 64); err == nil {
+		info.CommitTime = time.Unix(seconds, 0)
+	}
+	if len(parts) > 11 {
+		info.CommitSubject = parts[11]
+	}
+
+	return nil
+}
+
+// extractNearestBookmark finds the bookmark of the closest ancestor of the
+// working-copy commit, which is effectively the branch being worked on (e.g.,
+// after "jj new main"). If there isn't one, the working copy is reported as
+// detached.
+func (probe JjProbe) extractNearestBookmark(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runJjCommand(
+		ctx,
+		path,
+		"--ignore-working-copy",
+		"log", "--revisions", "heads(::@ & bookmarks())", "--no-graph",
+		"--template", `local_bookmarks.map(|b| b.name()).join(",") ++ "\n"`,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range out {
+		if line != "" {
+			info.Branch = line
+			return nil
+		}
+	}
+
+	info.Detached = true
+	info.DetachedLabel = info.Revision
+	return nil
+}
+
+func (probe JjProbe) extractChanges(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runJjCommand(ctx, path, "--ignore-working-copy", "diff", "--revisions", "@", "--summary")
+	if err != nil {
+		return err
+	}
+
+	for _, line := range out {
+		if len(line) > 2 && line[1] == ' ' && strings.ContainsAny(line[0:1], "MADRC") {
+			info.HasModified = true
+			info.ModifiedCount++
+		}
+	}
+
+	return nil
+}
+
+func (probe JjProbe) extractConflicts(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runJjCommand(ctx, path, "--ignore-working-copy", "resolve", "--list")
+	if err != nil {
+		if getExitCode(err) > 0 {
+			// This fails when there aren't any conflicts.
+			return nil
+		}
+		return err
+	}
+
+	for _, line := range out {
+		if jjConflict.MatchString(line) {
+			info.HasConflicts = true
+			info.ConflictCount++
+		}
+	}
+
+	return nil
+}
+
+func (probe JjProbe) extractRemote(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runJjCommand(ctx, path, "--ignore-working-copy", "git", "remote", "list")
+	if err != nil {
+		exitCode := getExitCode(err)
+		if exitCode == 1 {
+			// This means the repository isn't backed by Git.
+			return nil
+		}
+		return err
+	}
+
+	name, remote := "", ""
+	for _, line := range out {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if name == "" || parts[0] == "origin" {
+			name, remote = parts[0], parts[1]
+		}
+	}
+	if name != "" {
+		setRemote(info, name, remote)
+	}
+
+	return nil
+}
+
+// GatherInfo extracts and returns VCS information for the Jujutsu repository
+// at the specified path.
+func (probe JjProbe) GatherInfo(path string) (VcsInfo, []error) {
+	return probe.GatherInfoContext(context.Background(), path)
+}
+
+// GatherInfoContext extracts and returns VCS information for the Jujutsu
+// repository at the specified path. If the context expires before all
+// information could be gathered, the fields collected so far are returned.
+func (probe JjProbe) GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error) {
+	return probe.GatherInfoFields(ctx, path, FieldAll)
+}
+
+// GatherInfoFields extracts and returns the requested VCS information for the
+// Jujutsu repository at the specified path, skipping the work needed for the
+// other fields. If the context expires before all information could be
+// gathered, the fields collected so far are returned.
+func (probe JjProbe) GatherInfoFields(ctx context.Context, path string, fields FieldMask) (VcsInfo, []error) {
+	info := VcsInfo{
+		VcsName: probe.Name(),
+		Path:    path,
+	}
+
+	root, err := findAcceptablePath(path, probe.IsRepositoryRoot)
+	if err != nil {
+		return info, []error{err}
+	}
+	info.RepositoryRoot = root
+
+	// This has to come first, as it may snapshot the working copy.
+	err = probe.extractCommitInfo(ctx, path, &info)
+	if err != nil {
+		return info, []error{err}
+	}
+
+	errors := waitGroup(
+		fields.when(FieldBranch, func() error {
+			if info.Branch != "" {
+				return nil
+			}
+			return probe.extractNearestBookmark(ctx, path, &info)
+		}),
+
+		fields.when(FieldStatus, func() error {
+			return probe.extractChanges(ctx, path, &info)
+		}),
+
+		fields.when(FieldStatus, func() error {
+			if !info.HasConflicts {
+				return nil
+			}
+			return probe.extractConflicts(ctx, path, &info)
+		}),
+
+		fields.when(FieldRemote, func() error {
+			return probe.extractRemote(ctx, path, &info)
+		}),
+	)
+
+	return info, errors
+}
+
+// jjFileStates maps the codes used by "jj diff --summary" to FileStates.
+var jjFileStates = map[byte]FileState{
+	'M': FileModified,
+	'A': FileAdded,
+	'D': FileDeleted,
+	'R': FileRenamed,
+	'C': FileCopied,
+}
+
+// splitJjRename splits the path of a renamed or copied file as output by "jj
+// diff --summary" (e.g., "dir/{old => new}.txt") into the original and new
+// paths.
+func splitJjRename(path string) (string, string) {
+	start := strings.Index(path, "{")
+	end := strings.LastIndex(path, "}")
+	if start < 0 || end < start {
+		if parts := strings.SplitN(path, " => ", 2); len(parts) == 2 {
+			return parts[0], parts[1]
+		}
+		return "", path
+	}
+
+	parts := strings.SplitN(path[start+1:end], " => ", 2)
+	if len(parts) != 2 {
+		return "", path
+	}
+
+	prefix, suffix := path[:start], path[end+1:]
+	join := func(middle string) string {
+		return filepath.Clean(prefix + middle + suffix)
+	}
+	return join(parts[0]), join(parts[1])
+}
+
+// FileStatus returns the status of each file in the working copy of the
+// Jujutsu repository at the specified path that differs from its parent.
+func (probe JjProbe) FileStatus(path string) ([]FileStatus, error) {
+	return probe.FileStatusContext(context.Background(), path)
+}
+
+// FileStatusContext returns the status of each file in the working copy of the
+// Jujutsu repository at the specified path that differs from its parent,
+// abandoning the work when the context expires.
+func (probe JjProbe) FileStatusContext(ctx context.Context, path string) ([]FileStatus, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return nil, err
+	}
+
+	info := VcsInfo{}
+	err = probe.extractCommitInfo(ctx, root, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := runJjCommand(ctx, root, "--ignore-working-copy", "diff", "--revisions", "@", "--summary")
+	if err != nil {
+		return nil, err
+	}
+
+	conflicted := map[string]bool{}
+	conflicts := []string{}
+	if info.HasConflicts {
+		lines, err := runJjCommand(ctx, root, "--ignore-working-copy", "resolve", "--list")
+		if err == nil {
+			for _, line := range lines {
+				if match := jjConflict.FindStringSubmatch(line); match != nil {
+					file := filepath.ToSlash(match[1])
+					conflicted[file] = true
+					conflicts = append(conflicts, file)
+				}
+			}
+		}
+	}
+
+	files := []FileStatus{}
+	for _, line := range out {
+		if len(line) < 3 || line[1] != ' ' {
+			continue
+		}
+		state, ok := jjFileStates[line[0]]
+		if !ok {
+			continue
+		}
+
+		file := worktreeStatus(line[2:], state)
+		if state == FileRenamed || state == FileCopied {
+			original, current := splitJjRename(line[2:])
+			file = worktreeStatus(current, state)
+			file.OriginalPath = filepath.ToSlash(original)
+		}
+		if conflicted[file.Path] {
+			file.Worktree = FileConflicted
+			delete(conflicted, file.Path)
+		}
+		files = append(files, file)
+	}
+	for _, file := range conflicts {
+		if conflicted[file] {
+			files = append(files, worktreeStatus(file, FileConflicted))
+		}
+	}
+
+	return files, nil
+}
+
+// DiffStat summarizes the differences between the working copy of the
+// Jujutsu repository at the specified path and its parent.
+func (probe JjProbe) DiffStat(path string) (DiffStat, error) {
+	return probe.DiffStatContext(context.Background(), path)
+}
+
+// DiffStatContext summarizes the differences between the working copy of the
+// Jujutsu repository at the specified path and its parent, abandoning the
+// work when the context expires. The working copy isn't snapshotted again, so
+// the summary is as of the last snapshot (e.g., the one taken when gathering
+// information).
+func (probe JjProbe) DiffStatContext(ctx context.Context, path string) (DiffStat, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	out, err := runJjCommand(ctx, root, "--ignore-working-copy", "diff", "--revisions", "@", "--stat")
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	return parseDiffStatSummary(out), nil
+}
@@ -0,0 +1,358 @@
+package vcsinfo_test
+
+import (
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/jayclassless/vcsinfo"
+)
+
+var _ = Describe("Jj", func() {
+	probe := JjProbe{}
+	ignoringProbe := JjProbe{IgnoreWorkingCopy: true}
+
+	Describe("Name", func() {
+		It("works", func() {
+			Expect(probe.Name()).To(Equal("jj"))
+		})
+	})
+
+	Describe("DefaultFormat", func() {
+		It("works", func() {
+			Expect(probe.DefaultFormat()).To(Not(Equal("")))
+		})
+	})
+
+	Describe("IsAvailable", func() {
+		It("works", func() {
+			Expect(probe.IsAvailable()).To(BeTrue())
+		})
+	})
+
+	Describe("IsRepositoryRoot", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("returns false in dir with no repo", func() {
+			Expect(probe.IsRepositoryRoot(dir)).To(BeFalse())
+		})
+
+		It("returns true in dir with new repo", func() {
+			run(dir, "jj", "git", "init")
+			Expect(probe.IsRepositoryRoot(dir)).To(BeTrue())
+		})
+
+		It("is preferred over git for colocated repos", func() {
+			run(dir, "git", "init")
+			mkdir(dir, ".jj")
+
+			found, err := FindProbeForPath(dir, Probes())
+			Expect(err).To(BeNil())
+			Expect(found).To(Equal(probe))
+		})
+	})
+
+	Describe("GatherInfo", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			run(dir, "jj", "git", "init")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("returns the basics", func() {
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName":        Equal("jj"),
+				"Path":           Equal(dir),
+				"RepositoryRoot": Equal(dir),
+			}))
+		})
+
+		It("returns the basics when deep in repo", func() {
+			deep := mkdir(dir, "/some/deep/path")
+			info, err := probe.GatherInfo(deep)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName":        Equal("jj"),
+				"Path":           Equal(deep),
+				"RepositoryRoot": Equal(dir),
+			}))
+		})
+
+		It("sees nothing when empty", func() {
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasNew":         BeFalse(),
+				"HasModified":    BeFalse(),
+				"HasStaged":      BeFalse(),
+				"HasStashed":     BeFalse(),
+				"HasConflicts":   BeFalse(),
+				"EmptyChangeset": BeTrue(),
+				"Branch":         Equal(""),
+				"Detached":       BeTrue(),
+				"DetachedLabel":  Equal(info.Revision),
+			}))
+			Expect(info.Revision).To(HaveLen(12))
+			Expect(info.Hash).To(HaveLen(40))
+			Expect(info.Hash).To(HavePrefix(info.ShortHash))
+		})
+
+		It("sees new files as changes to the working-copy commit", func() {
+			writeFile(dir, "foo", "bar")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasNew":         BeFalse(),
+				"HasModified":    BeTrue(),
+				"ModifiedCount":  Equal(1),
+				"EmptyChangeset": BeFalse(),
+			}))
+		})
+
+		It("doesn't snapshot the working copy when told not to", func() {
+			writeFile(dir, "foo", "bar")
+			info, err := ignoringProbe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+			Expect(info.HasModified).To(BeFalse())
+
+			info, err = probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+			Expect(info.HasModified).To(BeTrue())
+
+			info, err = ignoringProbe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+			Expect(info.HasModified).To(BeTrue())
+		})
+
+		It("sees modified files", func() {
+			writeFile(dir, "foo", "bar")
+			writeFile(dir, "baz", "qux")
+			run(dir, "jj", "commit", "-m", "first")
+			writeFile(dir, "foo", "changed")
+			rm(dir, "baz")
+
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasModified":   BeTrue(),
+				"ModifiedCount": Equal(2),
+			}))
+		})
+
+		It("sees an empty working-copy commit as unmodified", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "jj", "commit", "-m", "first")
+
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+			Expect(info.HasModified).To(BeFalse())
+			Expect(info.ModifiedCount).To(Equal(0))
+			Expect(info.EmptyChangeset).To(BeTrue())
+		})
+
+		It("sees bookmarks", func() {
+			run(dir, "jj", "bookmark", "create", "feature", "--revision", "@")
+
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Branch":   Equal("feature"),
+				"Detached": BeFalse(),
+			}))
+		})
+
+		It("sees bookmarks on ancestors", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "jj", "commit", "-m", "first")
+			run(dir, "jj", "bookmark", "create", "main", "--revision", "@-")
+			run(dir, "jj", "new")
+
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Branch":   Equal("main"),
+				"Detached": BeFalse(),
+			}))
+		})
+
+		It("sees conflicts", func() {
+			writeFile(dir, "foo", "base")
+			run(dir, "jj", "commit", "-m", "base")
+			run(dir, "jj", "bookmark", "create", "base", "--revision", "@-")
+			writeFile(dir, "foo", "left")
+			run(dir, "jj", "commit", "-m", "left")
+			run(dir, "jj", "bookmark", "create", "left", "--revision", "@-")
+			run(dir, "jj", "new", "base")
+			writeFile(dir, "foo", "right")
+			run(dir, "jj", "commit", "-m", "right")
+			run(dir, "jj", "bookmark", "create", "right", "--revision", "@-")
+			run(dir, "jj", "new", "left", "right")
+
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasConflicts":  BeTrue(),
+				"ConflictCount": Equal(1),
+			}))
+		})
+
+		It("sees commit metadata", func() {
+			before := time.Now().Add(-time.Minute)
+			writeFile(dir, "foo", "bar")
+			run(dir, "jj", "describe", "-m", "the subject\n\nthe body")
+
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"CommitAuthor":  Equal("Fake Tester"),
+				"CommitEmail":   Equal("fake@example.com"),
+				"CommitSubject": Equal("the subject"),
+				"CommitTime":    BeTemporally(">", before),
+			}))
+		})
+
+		It("sees the remote", func() {
+			run(dir, "jj", "git", "remote", "add", "origin", "https://github.com/jayclassless/vcsinfo.git")
+
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"RemoteName":     Equal("origin"),
+				"RemoteProvider": Equal(ProviderGitHub),
+				"RemoteOwner":    Equal("jayclassless"),
+				"RemoteRepo":     Equal("vcsinfo"),
+			}))
+		})
+
+		It("works in colocated repos", func() {
+			colocated := tmpdir()
+			defer rmdir(colocated)
+			run(colocated, "jj", "git", "init", "--colocate")
+			writeFile(colocated, "foo", "bar")
+
+			info, err := probe.GatherInfo(colocated)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName":        Equal("jj"),
+				"RepositoryRoot": Equal(colocated),
+				"HasModified":    BeTrue(),
+			}))
+		})
+
+		It("doesnt crash when in VCS special dir", func() {
+			_, err := probe.GatherInfo(filepath.Join(dir, ".jj"))
+			Expect(err).To(BeEmpty())
+		})
+	})
+
+	Describe("FileStatus", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			run(dir, "jj", "git", "init")
+			writeFile(dir, "foo", "bar")
+			writeFile(dir, "baz", "qux")
+			run(dir, "jj", "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("sees nothing when clean", func() {
+			files, err := probe.FileStatus(dir)
+			Expect(err).To(BeNil())
+			Expect(files).To(BeEmpty())
+		})
+
+		It("sees the changes in the working-copy commit", func() {
+			writeFile(dir, "foo", "changed")
+			rm(dir, "baz")
+			writeFile(dir, "new", "file")
+
+			files, err := probe.FileStatus(mkdir(dir, "sub"))
+			Expect(err).To(BeNil())
+			Expect(files).To(ConsistOf(
+				FileStatus{Path: "foo", Index: FileUnmodified, Worktree: FileModified},
+				FileStatus{Path: "baz", Index: FileUnmodified, Worktree: FileDeleted},
+				FileStatus{Path: "new", Index: FileUnmodified, Worktree: FileAdded},
+			))
+		})
+
+		It("sees renames", func() {
+			run(dir, "mv", "foo", "moved")
+
+			files, err := probe.FileStatus(dir)
+			Expect(err).To(BeNil())
+			Expect(files).To(ConsistOf(
+				FileStatus{Path: "moved", Index: FileUnmodified, Worktree: FileRenamed, OriginalPath: "foo"},
+			))
+		})
+	})
+
+	Describe("DiffStat", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			run(dir, "jj", "git", "init")
+			writeFile(dir, "foo", "one\ntwo\nthree\n")
+			run(dir, "jj", "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("sees nothing when clean", func() {
+			stat, err := probe.DiffStat(dir)
+			Expect(err).To(BeNil())
+			Expect(stat).To(Equal(DiffStat{}))
+		})
+
+		It("counts the changes", func() {
+			writeFile(dir, "foo", "one\n2\nthree\nfour\n")
+			writeFile(dir, "bar", "new\n")
+			_, errs := probe.GatherInfo(dir)
+			Expect(errs).To(BeEmpty())
+
+			stat, err := probe.DiffStat(dir)
+			Expect(err).To(BeNil())
+			Expect(stat).To(Equal(DiffStat{FilesChanged: 2, Insertions: 3, Deletions: 1}))
+		})
+	})
+})
@@ -0,0 +1,498 @@
+package vcsinfo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// P4Probe is a probe for extracting information out of a Perforce workspace.
+//
+// Perforce workspaces don't have a metadata directory to identify them by, so
+// the root of a workspace is the directory containing the file named by the
+// P4CONFIG environment variable (e.g., .p4config). Finding the files that
+// aren't known to the server requires examining the whole workspace, so it's
+// only done when FieldUntracked is requested.
+type P4Probe struct {
+	// Indicates whether or not, if the P4CLIENT environment variable is set,
+	// the root of that client (as reported by the server) should also be
+	// considered to be the root of a workspace. This means asking the server
+	// whenever a directory isn't otherwise recognized (though only once per
+	// process), so it isn't done by default.
+	AskServer bool
+
+	// How long to wait for the server when asking it for the root of the
+	// client. If zero, p4ClientRootTimeout is used.
+	AskServerTimeout time.Duration
+}
+
+// p4ClientRootTimeout is how long to wait for the server when asking it for
+// the root of the client, unless the probe says otherwise.
+const p4ClientRootTimeout = 5 * time.Second
+
+// p4ClientRoots caches the client roots reported by the server (keyed by the
+// environment they were requested with), as IsRepositoryRoot is called for
+// every directory that is examined.
+var p4ClientRoots sync.Map
+
+// p4NoFiles matches the warnings Perforce emits when there aren't any files
+// to report on.
+var p4NoFiles = regexp.MustCompile(`(?i)no file\(s\)|file\(s\) not (opened|in client view|on client)|no such file\(s\)`)
+
+// Name returns the human-facing name of the probe.
+func (probe P4Probe) Name() string {
+	return "p4"
+}
+
+// DefaultFormat returns the default format string to use for Perforce
+// workspaces. It leaves out %u, as finding the untracked files means
+// examining the whole workspace.
+func (probe P4Probe) DefaultFormat() string {
+	return "%n[%w%c%m%t]"
+}
+
+// IsAvailable indicates whether or not this probe has the tools/environment
+// necessary to operate.
+func (probe P4Probe) IsAvailable() (bool, error) {
+	return commandExists("p4"), nil
+}
+
+// IsRepositoryRoot identifies whether or not the specified path is the root
+// of a Perforce workspace.
+func (probe P4Probe) IsRepositoryRoot(path string) (bool, error) {
+	if config := os.Getenv("P4CONFIG"); config != "" {
+		exists, err := fileExists(filepath.Join(path, config))
+		if exists || err != nil {
+			return exists, err
+		}
+	}
+
+	if !probe.AskServer || os.Getenv("P4CLIENT") == "" {
+		return false, nil
+	}
+
+	timeout := probe.AskServerTimeout
+	if timeout <= 0 {
+		timeout = p4ClientRootTimeout
+	}
+	root, err := p4ClientRoot(timeout)
+	if err != nil || root == "" {
+		// If the server can't be reached, we can't tell.
+		return false, nil
+	}
+	return filepath.Clean(root) == filepath.Clean(path), nil
+}
+
+// p4ClientRoot retrieves the root of the client named by the environment from
+// the server.
+func p4ClientRoot(timeout time.Duration) (string, error) {
+	key := strings.Join([]string{os.Getenv("P4PORT"), os.Getenv("P4CLIENT"), os.Getenv("P4USER")}, "\x00")
+	if root, ok := p4ClientRoots.Load(key); ok {
+		return root.(string), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	out, err := runCommand(ctx, "/", "p4", "-F", "%clientRoot%", "info")
+	root := ""
+	if err == nil && len(out) > 0 && filepath.IsAbs(out[0]) {
+		root = out[0]
+	}
+
+	// Failures are remembered too, so that an unreachable server doesn't
+	// hold up the examination of every directory.
+	p4ClientRoots.Store(key, root)
+	return root, err
+}
+
+// runP4Command runs a p4 command in the specified directory (which determines
+// the P4CONFIG file that is used). The warnings emitted when there are no
+// files to report on aren't treated as failures.
+func runP4Command(ctx context.Context, workingDir string, command ...string) ([]string, error) {
+	// p4 uses $PWD rather than the actual working directory, so it has to be
+	// told explicitly.
+	out, err := runCommand(ctx, workingDir, append([]string{"p4", "-d", workingDir}, command[0:]...)...)
+	if err != nil && getExitCode(err) == 1 {
+		for _, line := range out {
+			if p4NoFiles.MatchString(line) {
+				return nil, nil
+			}
+		}
+	}
+	return out, err
+}
+
+// runP4TaggedCommand runs a p4 command with tagged output, returning the
+// records it produced.
+func runP4TaggedCommand(ctx context.Context, workingDir string, command ...string) ([]map[string]string, error) {
+	out, err := runP4Command(ctx, workingDir, append([]string{"-ztag"}, command[0:]...)...)
+	return parseP4Tagged(out), err
+}
+
+// parseP4Tagged parses the output of a command run with "p4 -ztag" into a
+// record per object, each of which maps the names of its fields to their
+// values. Lines that aren't tagged are continuations of the previous value
+// (e.g., a multi-line description).
+func parseP4Tagged(lines []string) []map[string]string {
+	records := []map[string]string{}
+	var record map[string]string
+	lastKey, blank := "", false
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "... ") {
+			if record == nil || blank {
+				record = map[string]string{}
+				records = append(records, record)
+			}
+			parts := strings.SplitN(line[4:], " ", 2)
+			if len(parts) == 1 {
+				parts = append(parts, "")
+			}
+			lastKey, blank = parts[0], false
+			record[lastKey] = parts[1]
+			continue
+		}
+
+		if line == "" {
+			blank = true
+			continue
+		}
+		if record != nil && lastKey != "" {
+			if blank {
+				record[lastKey] += "\n"
+			}
+			record[lastKey] += "\n" + line
+		}
+		blank = false
+	}
+
+	return records
+}
+
+// p4RelativePath converts a path reported by Perforce (either a local path,
+// or one in client syntax, e.g., //client/dir/file) to one relative to the
+// root of the workspace.
+func p4RelativePath(root string, path string) string {
+	if strings.HasPrefix(path, "//") {
+		parts := strings.SplitN(path[2:], "/", 2)
+		if len(parts) == 2 {
+			return parts[1]
+		}
+		return path
+	}
+
+	relative, err := filepath.Rel(root, path)
+	if err != nil {
+		return path
+	}
+	return relative
+}
+
+// extractClient retrieves the name and stream of the client, which have to be
+// known before most of the other information can be requested.
+func (probe P4Probe) extractClient(ctx context.Context, root string, info *VcsInfo) error {
+	records, err := runP4TaggedCommand(ctx, root, "client", "-o")
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	info.Workspace = records[0]["Client"]
+	info.Branch = records[0]["Stream"]
+	return nil
+}
+
+func (probe P4Probe) extractHaveChange(ctx context.Context, root string, info *VcsInfo) error {
+	if info.Workspace == "" {
+		return nil
+	}
+
+	records, err := runP4TaggedCommand(
+		ctx,
+		root,
+		"changes", "-m", "1", "-l", "-s", "submitted",
+		"//"+info.Workspace+"/...#have",
+	)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		// Nothing has been synced yet.
+		return nil
+	}
+
+	change := records[0]
+	info.Revision = change["change"]
+	info.CommitAuthor = change["user"]
+	info.CommitSubject = firstLine(change["desc"])
+	if seconds, err := strconv.ParseInt(change["time"], 10, 64); err == nil {
+		info.CommitTime = time.Unix(seconds, 0)
+	}
+
+	return nil
+}
+
+func (probe P4Probe) extractOpened(ctx context.Context, root string, info *VcsInfo) error {
+	records, err := runP4TaggedCommand(ctx, root, "fstat", "-Ro", "-T", "clientFile,action", "...")
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record["action"] != "" {
+			info.HasModified = true
+			info.ModifiedCount++
+		}
+	}
+
+	return nil
+}
+
+func (probe P4Probe) extractConflicts(ctx context.Context, root string, info *VcsInfo) error {
+	records, err := runP4TaggedCommand(ctx, root, "resolve", "-n", "...")
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record["clientFile"] != "" {
+			info.HasConflicts = true
+			info.ConflictCount++
+		}
+	}
+
+	return nil
+}
+
+// findP4NewFiles finds the files in the workspace that aren't known to the
+// server by previewing what "p4 reconcile" would open for add. The P4CONFIG
+// files themselves are skipped, as they're effectively Perforce's metadata.
+func findP4NewFiles(ctx context.Context, root string) ([]string, error) {
+	records, err := runP4TaggedCommand(ctx, root, "reconcile", "-n", "-a", "...")
+	if err != nil {
+		return nil, err
+	}
+
+	config := os.Getenv("P4CONFIG")
+	files := []string{}
+	for _, record := range records {
+		if record["clientFile"] == "" {
+			continue
+		}
+		file := p4RelativePath(root, record["clientFile"])
+		if config != "" && filepath.Base(file) == config {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+func (probe P4Probe) extractNew(ctx context.Context, root string, info *VcsInfo) error {
+	files, err := findP4NewFiles(ctx, root)
+	if err != nil {
+		return err
+	}
+
+	info.UntrackedCount = len(files)
+	info.HasNew = info.UntrackedCount > 0
+	return nil
+}
+
+// extractShelves counts the pending changelists of the client that have files
+// shelved in them, which are Perforce's equivalent of stashes.
+func (probe P4Probe) extractShelves(ctx context.Context, root string, info *VcsInfo) error {
+	if info.Workspace == "" {
+		return nil
+	}
+
+	records, err := runP4TaggedCommand(ctx, root, "changes", "-s", "shelved", "-c", info.Workspace)
+	if err != nil {
+		return err
+	}
+
+	info.StashCount = len(records)
+	info.HasStashed = info.StashCount > 0
+	return nil
+}
+
+// GatherInfo extracts and returns VCS information for the Perforce workspace
+// at the specified path.
+func (probe P4Probe) GatherInfo(path string) (VcsInfo, []error) {
+	return probe.GatherInfoContext(context.Background(), path)
+}
+
+// GatherInfoContext extracts and returns VCS information for the Perforce
+// workspace at the specified path. If the context expires before all
+// information could be gathered, the fields collected so far are returned.
+func (probe P4Probe) GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error) {
+	return probe.GatherInfoFields(ctx, path, FieldAll)
+}
+
+// GatherInfoFields extracts and returns the requested VCS information for the
+// Perforce workspace at the specified path, skipping the work needed for the
+// other fields. If the context expires before all information could be
+// gathered, the fields collected so far are returned.
+func (probe P4Probe) GatherInfoFields(ctx context.Context, path string, fields FieldMask) (VcsInfo, []error) {
+	info := VcsInfo{
+		VcsName: probe.Name(),
+		Path:    path,
+	}
+
+	root, err := findAcceptablePath(path, probe.IsRepositoryRoot)
+	if err != nil {
+		return info, []error{err}
+	}
+	info.RepositoryRoot = root
+	if root == "" {
+		return info, nil
+	}
+
+	if fields&(FieldBranch|FieldRevision|FieldCommit|FieldStash) != 0 {
+		err = probe.extractClient(ctx, root, &info)
+		if err != nil {
+			return info, []error{err}
+		}
+	}
+
+	errors := waitGroup(
+		fields.when(FieldRevision|FieldCommit, func() error {
+			return probe.extractHaveChange(ctx, root, &info)
+		}),
+
+		fields.when(FieldStatus, func() error {
+			return probe.extractOpened(ctx, root, &info)
+		}),
+
+		fields.when(FieldStatus, func() error {
+			return probe.extractConflicts(ctx, root, &info)
+		}),
+
+		fields.when(FieldUntracked, func() error {
+			return probe.extractNew(ctx, root, &info)
+		}),
+
+		fields.when(FieldStash, func() error {
+			return probe.extractShelves(ctx, root, &info)
+		}),
+	)
+
+	return info, errors
+}
+
+// p4FileStates maps the actions files can be opened for to FileStates.
+var p4FileStates = map[string]FileState{
+	"add":         FileAdded,
+	"branch":      FileAdded,
+	"import":      FileAdded,
+	"edit":        FileModified,
+	"integrate":   FileModified,
+	"delete":      FileDeleted,
+	"purge":       FileDeleted,
+	"archive":     FileDeleted,
+	"move/add":    FileRenamed,
+	"move/delete": FileDeleted,
+}
+
+// FileStatus returns the status of each file in the Perforce workspace at the
+// specified path that is opened, needs resolving, or isn't known to the
+// server.
+func (probe P4Probe) FileStatus(path string) ([]FileStatus, error) {
+	return probe.FileStatusContext(context.Background(), path)
+}
+
+// FileStatusContext returns the status of each file in the Perforce workspace
+// at the specified path that is opened, needs resolving, or isn't known to the
+// server, abandoning the work when the context expires.
+func (probe P4Probe) FileStatusContext(ctx context.Context, path string) ([]FileStatus, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return nil, err
+	}
+
+	opened, err := runP4TaggedCommand(ctx, root, "fstat", "-Ro", "-T", "depotFile,clientFile,action,movedFile", "...")
+	if err != nil {
+		return nil, err
+	}
+
+	unresolved, err := runP4TaggedCommand(ctx, root, "resolve", "-n", "...")
+	if err != nil {
+		return nil, err
+	}
+	conflicted := map[string]bool{}
+	for _, record := range unresolved {
+		conflicted[filepath.ToSlash(p4RelativePath(root, record["clientFile"]))] = true
+	}
+
+	// Moves are reported as a move/add of the new file and a move/delete of
+	// the original, which are combined into a single rename.
+	clientFiles := map[string]string{}
+	for _, record := range opened {
+		clientFiles[record["depotFile"]] = p4RelativePath(root, record["clientFile"])
+	}
+
+	files := []FileStatus{}
+	for _, record := range opened {
+		state, ok := p4FileStates[record["action"]]
+		if !ok {
+			continue
+		}
+		if record["action"] == "move/delete" {
+			if _, moved := clientFiles[record["movedFile"]]; moved {
+				continue
+			}
+		}
+
+		file := worktreeStatus(clientFiles[record["depotFile"]], state)
+		if original, ok := clientFiles[record["movedFile"]]; ok && state == FileRenamed {
+			file.OriginalPath = filepath.ToSlash(original)
+		}
+		if conflicted[file.Path] {
+			file.Worktree = FileConflicted
+		}
+		files = append(files, file)
+	}
+
+	added, err := findP4NewFiles(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range added {
+		files = append(files, worktreeStatus(file, FileUntracked))
+	}
+
+	return files, nil
+}
+
+// DiffStat summarizes the differences between the files opened in the
+// Perforce workspace at the specified path and the revisions that were
+// synced.
+func (probe P4Probe) DiffStat(path string) (DiffStat, error) {
+	return probe.DiffStatContext(context.Background(), path)
+}
+
+// DiffStatContext summarizes the differences between the files opened in the
+// Perforce workspace at the specified path and the revisions that were
+// synced, abandoning the work when the context expires.
+func (probe P4Probe) DiffStatContext(ctx context.Context, path string) (DiffStat, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	out, err := runP4Command(ctx, root, "diff", "-du", "...")
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	return parseUnifiedDiff(out), nil
+}
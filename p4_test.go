@@ -0,0 +1,391 @@
+package vcsinfo_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/jayclassless/vcsinfo"
+)
+
+// p4Workspace sets up a workspace in the directory that uses a server (started
+// on demand by p4 itself) whose files are in serverDir.
+func p4Workspace(dir string, serverDir string, client string, clientOptions string) {
+	writeFile(dir, ".p4config", fmt.Sprintf(
+		"P4PORT=rsh:p4d -r %s -L log -J off -i\nP4USER=fake\nP4CLIENT=%s\n",
+		serverDir,
+		client,
+	))
+	p4Pipe(dir, "client "+clientOptions+" -o", "client -i")
+}
+
+// p4 runs a p4 command in the workspace.
+func p4(dir string, command ...string) {
+	run(dir, append([]string{"p4", "-d", dir}, command...)...)
+}
+
+// p4Pipe pipes the output of one p4 command into another in the workspace,
+// which is how specs (e.g., clients) are created non-interactively.
+func p4Pipe(dir string, from string, to string) {
+	run(dir, "sh", "-c", fmt.Sprintf("p4 -d '%s' %s | p4 -d '%s' %s", dir, from, dir, to))
+}
+
+var _ = Describe("Perforce", func() {
+	probe := P4Probe{}
+
+	var originalConfig string
+
+	BeforeEach(func() {
+		originalConfig = os.Getenv("P4CONFIG")
+		os.Setenv("P4CONFIG", ".p4config")
+	})
+
+	AfterEach(func() {
+		os.Setenv("P4CONFIG", originalConfig)
+	})
+
+	Describe("Name", func() {
+		It("works", func() {
+			Expect(probe.Name()).To(Equal("p4"))
+		})
+	})
+
+	Describe("DefaultFormat", func() {
+		It("works", func() {
+			Expect(probe.DefaultFormat()).To(Not(Equal("")))
+		})
+	})
+
+	Describe("IsAvailable", func() {
+		It("works", func() {
+			Expect(probe.IsAvailable()).To(BeTrue())
+		})
+	})
+
+	Describe("IsRepositoryRoot", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("returns false in dir with no workspace", func() {
+			Expect(probe.IsRepositoryRoot(dir)).To(BeFalse())
+		})
+
+		It("returns true in dir with a config file", func() {
+			writeFile(dir, ".p4config", "P4CLIENT=fake\n")
+			Expect(probe.IsRepositoryRoot(dir)).To(BeTrue())
+		})
+
+		It("uses the name of the config file from the environment", func() {
+			writeFile(dir, ".p4config", "P4CLIENT=fake\n")
+			os.Setenv("P4CONFIG", "p4.ini")
+			Expect(probe.IsRepositoryRoot(dir)).To(BeFalse())
+			writeFile(dir, "p4.ini", "P4CLIENT=fake\n")
+			Expect(probe.IsRepositoryRoot(dir)).To(BeTrue())
+		})
+
+		It("only asks the server for the client root when told to", func() {
+			serverDir := tmpdir()
+			defer rmdir(serverDir)
+			p4Workspace(dir, serverDir, "vcsinfo-test", "")
+			rm(dir, ".p4config")
+
+			originalEnv := map[string]string{}
+			for _, name := range []string{"P4PORT", "P4USER", "P4CLIENT"} {
+				originalEnv[name] = os.Getenv(name)
+			}
+			defer func() {
+				for name, value := range originalEnv {
+					os.Setenv(name, value)
+				}
+			}()
+			os.Setenv("P4PORT", fmt.Sprintf("rsh:p4d -r %s -L log -J off -i", serverDir))
+			os.Setenv("P4USER", "fake")
+			os.Setenv("P4CLIENT", "vcsinfo-test")
+
+			Expect(probe.IsRepositoryRoot(dir)).To(BeFalse())
+			Expect(P4Probe{AskServer: true}.IsRepositoryRoot(dir)).To(BeTrue())
+		})
+	})
+
+	Describe("GatherInfo", func() {
+		var dir, serverDir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			serverDir = tmpdir()
+			p4Workspace(dir, serverDir, "vcsinfo-test", "")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+			rmdir(serverDir)
+			serverDir = ""
+		})
+
+		It("returns the basics", func() {
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName":        Equal("p4"),
+				"Path":           Equal(dir),
+				"RepositoryRoot": Equal(dir),
+				"Workspace":      Equal("vcsinfo-test"),
+			}))
+		})
+
+		It("returns the basics when deep in workspace", func() {
+			deep := mkdir(dir, "/some/deep/path")
+			info, err := probe.GatherInfo(deep)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName":        Equal("p4"),
+				"Path":           Equal(deep),
+				"RepositoryRoot": Equal(dir),
+				"Workspace":      Equal("vcsinfo-test"),
+			}))
+		})
+
+		It("sees nothing when empty", func() {
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasNew":       BeFalse(),
+				"HasModified":  BeFalse(),
+				"HasStaged":    BeFalse(),
+				"HasStashed":   BeFalse(),
+				"HasConflicts": BeFalse(),
+				"Branch":       Equal(""),
+				"Revision":     Equal(""),
+			}))
+		})
+
+		It("sees new files", func() {
+			writeFile(dir, "foo", "bar")
+			writeFile(dir, "baz", "qux")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasNew":         BeTrue(),
+				"UntrackedCount": Equal(2),
+				"HasModified":    BeFalse(),
+			}))
+		})
+
+		It("sees opened files", func() {
+			writeFile(dir, "foo", "bar")
+			writeFile(dir, "baz", "qux")
+			p4(dir, "add", "foo", "baz")
+			p4(dir, "submit", "-d", "first")
+			p4(dir, "edit", "foo")
+			p4(dir, "delete", "baz")
+
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasNew":        BeFalse(),
+				"HasModified":   BeTrue(),
+				"ModifiedCount": Equal(2),
+			}))
+		})
+
+		It("sees the have changelist", func() {
+			before := time.Now().Add(-time.Minute)
+			writeFile(dir, "foo", "bar")
+			p4(dir, "add", "foo")
+			p4(dir, "submit", "-d", "first")
+			p4(dir, "edit", "foo")
+			p4(dir, "submit", "-d", "second\n\nwith a body")
+
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Revision":      Equal("2"),
+				"CommitAuthor":  Equal("fake"),
+				"CommitSubject": Equal("second"),
+				"CommitTime":    BeTemporally(">", before),
+			}))
+
+			p4(dir, "sync", "//vcsinfo-test/...@1")
+			info, err = probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+			Expect(info.Revision).To(Equal("1"))
+		})
+
+		It("sees shelves", func() {
+			writeFile(dir, "foo", "bar")
+			p4(dir, "add", "foo")
+			p4(dir, "submit", "-d", "first")
+			p4(dir, "edit", "foo")
+			p4Pipe(dir, "--field Description=shelved change -o", "shelve -i")
+
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasStashed": BeTrue(),
+				"StashCount": Equal(1),
+			}))
+		})
+
+		It("sees conflicts", func() {
+			writeFile(dir, "foo", "bar")
+			p4(dir, "add", "foo")
+			p4(dir, "submit", "-d", "first")
+
+			other := tmpdir()
+			defer rmdir(other)
+			p4Workspace(other, serverDir, "vcsinfo-other", "")
+			p4(other, "sync")
+			p4(other, "edit", "foo")
+			writeFile(other, "foo", "theirs")
+			p4(other, "submit", "-d", "theirs")
+
+			p4(dir, "edit", "foo")
+			writeFile(dir, "foo", "ours")
+			p4(dir, "sync")
+
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasConflicts":  BeTrue(),
+				"ConflictCount": Equal(1),
+			}))
+		})
+
+		It("sees the stream", func() {
+			streamed := tmpdir()
+			defer rmdir(streamed)
+			p4Pipe(dir, "--field Type=stream depot -o streams", "depot -i")
+			p4Pipe(dir, "stream -t mainline -o //streams/main", "stream -i")
+			p4Workspace(streamed, serverDir, "vcsinfo-streamed", "-S //streams/main")
+
+			info, err := probe.GatherInfo(streamed)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Workspace": Equal("vcsinfo-streamed"),
+				"Branch":    Equal("//streams/main"),
+			}))
+		})
+
+		It("only gathers the requested fields", func() {
+			writeFile(dir, "foo", "bar")
+
+			info, err := probe.GatherInfoFields(context.Background(), dir, FieldUntracked)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasNew":    BeTrue(),
+				"Workspace": Equal(""),
+			}))
+
+			info, err = probe.GatherInfoFields(context.Background(), dir, FieldStatus)
+			Expect(err).To(BeEmpty())
+			Expect(info.HasNew).To(BeFalse())
+		})
+	})
+
+	Describe("FileStatus", func() {
+		var dir, serverDir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			serverDir = tmpdir()
+			p4Workspace(dir, serverDir, "vcsinfo-test", "")
+			writeFile(dir, "foo", "bar")
+			writeFile(dir, "baz", "qux")
+			writeFile(dir, "old", "moved")
+			p4(dir, "add", "foo", "baz", "old")
+			p4(dir, "submit", "-d", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+			rmdir(serverDir)
+			serverDir = ""
+		})
+
+		It("sees nothing when clean", func() {
+			files, err := probe.FileStatus(dir)
+			Expect(err).To(BeNil())
+			Expect(files).To(BeEmpty())
+		})
+
+		It("sees the opened and new files", func() {
+			p4(dir, "edit", "foo")
+			p4(dir, "delete", "baz")
+			p4(dir, "edit", "old")
+			p4(dir, "move", "old", "new")
+			writeFile(dir, "added", "file")
+			p4(dir, "add", "added")
+			writeFile(dir, "untracked", "file")
+
+			files, err := probe.FileStatus(mkdir(dir, "sub"))
+			Expect(err).To(BeNil())
+			Expect(files).To(ConsistOf(
+				FileStatus{Path: "foo", Index: FileUnmodified, Worktree: FileModified},
+				FileStatus{Path: "baz", Index: FileUnmodified, Worktree: FileDeleted},
+				FileStatus{Path: "new", Index: FileUnmodified, Worktree: FileRenamed, OriginalPath: "old"},
+				FileStatus{Path: "added", Index: FileUnmodified, Worktree: FileAdded},
+				FileStatus{Path: "untracked", Index: FileUnmodified, Worktree: FileUntracked},
+			))
+		})
+	})
+
+	Describe("DiffStat", func() {
+		var dir, serverDir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			serverDir = tmpdir()
+			p4Workspace(dir, serverDir, "vcsinfo-test", "")
+			writeFile(dir, "foo", "one\ntwo\nthree\n")
+			p4(dir, "add", "foo")
+			p4(dir, "submit", "-d", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+			rmdir(serverDir)
+			serverDir = ""
+		})
+
+		It("sees nothing when clean", func() {
+			stat, err := probe.DiffStat(dir)
+			Expect(err).To(BeNil())
+			Expect(stat).To(Equal(DiffStat{}))
+		})
+
+		It("counts the changes to opened files", func() {
+			p4(dir, "edit", "foo")
+			writeFile(dir, "foo", "one\n2\nthree\nfour\n")
+
+			stat, err := probe.DiffStat(dir)
+			Expect(err).To(BeNil())
+			Expect(stat).To(Equal(DiffStat{FilesChanged: 1, Insertions: 2, Deletions: 1}))
+		})
+	})
+})
@@ -0,0 +1,377 @@
+package vcsinfo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PluginPrefix is the prefix of the names of the executables that provide
+// external probes (e.g., vcsinfo-probe-pijul).
+const PluginPrefix = "vcsinfo-probe-"
+
+// pluginName matches the names that plugins may describe themselves with. The
+// name ends up in command line options and environment variables (e.g.,
+// --format-NAME), so it's kept to characters that are safe there.
+var pluginName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// pluginDescribeTimeout limits how long a plugin has to describe itself, so
+// that a broken one can't hang everything that looks for probes.
+const pluginDescribeTimeout = 5 * time.Second
+
+// pluginRequest is sent to a plugin (as JSON on its stdin) to ask it to do
+// something.
+type pluginRequest struct {
+	// Either "describe" or "gather_info".
+	Request string `json:"request"`
+
+	// The path the request is about.
+	Path string `json:"path,omitempty"`
+}
+
+// pluginDescription is the response of a plugin to the "describe" request.
+type pluginDescription struct {
+	// The name of the VCS the plugin handles.
+	Name string `json:"name"`
+
+	// The default format string to use for the repositories.
+	DefaultFormat string `json:"default_format"`
+
+	// The files or directories whose presence indicates that a directory is
+	// the root of a repository. At least one is required, as probes are asked
+	// about every directory above the one being examined (and every directory
+	// scanned), which is far too often to run the plugin.
+	RootMarkers []string `json:"root_markers"`
+}
+
+// pluginInfoResponse is the response of a plugin to the "gather_info"
+// request.
+type pluginInfoResponse struct {
+	Info   VcsInfo  `json:"info"`
+	Errors []string `json:"errors"`
+}
+
+// PluginProbe is a VcsProbe that delegates to an external executable, allowing
+// VCS that aren't built into VCSInfo to be supported. Each request is made by
+// running the executable with a JSON object describing the request on its
+// stdin, and reading a JSON object containing the response from its stdout.
+type PluginProbe struct {
+	// The path to the plugin's executable.
+	Executable string
+
+	description pluginDescription
+}
+
+// LoadPluginProbe creates a PluginProbe for the executable, asking it to
+// describe itself. Plugins that don't describe any root markers can't be used.
+func LoadPluginProbe(executable string) (PluginProbe, error) {
+	probe := PluginProbe{Executable: executable}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pluginDescribeTimeout)
+	defer cancel()
+
+	err := probe.call(ctx, pluginRequest{Request: "describe"}, &probe.description)
+	if err == nil && !pluginName.MatchString(probe.Name()) {
+		err = fmt.Errorf("%s has an invalid name: %q", executable, probe.Name())
+	}
+	if err == nil && len(probe.description.RootMarkers) == 0 {
+		err = fmt.Errorf("%s did not describe any root_markers", executable)
+	}
+	return probe, err
+}
+
+// FindPluginProbes loads the plugins found in the specified directories. If
+// plugins with the same name are found in multiple directories, the one in the
+// earliest directory is used. Plugins that fail to describe themselves are
+// ignored, as are plugins whose names are already taken by a registered probe
+// or an earlier plugin.
+//
+// The descriptions of the plugins are cached (in vcsinfo/plugins.json in the
+// user's cache directory), so a plugin is only asked to describe itself again
+// once its executable has changed.
+func FindPluginProbes(dirs []string) []VcsProbe {
+	probes := []VcsProbe{}
+	seen := make(map[string]bool)
+	names := make(map[string]bool)
+
+	cachePath := pluginCachePath()
+	cache := readPluginCache(cachePath)
+	fresh := make(map[string]pluginCacheEntry)
+	changed := false
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			if !strings.HasPrefix(name, PluginPrefix) || seen[name] {
+				continue
+			}
+
+			path := filepath.Join(dir, name)
+			if _, err := exec.LookPath(path); err != nil {
+				// Not an executable file.
+				continue
+			}
+			seen[name] = true
+
+			stat, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+
+			cached, ok := cache[path]
+			if !ok || cached.Size != stat.Size() || !cached.ModTime.Equal(stat.ModTime()) {
+				probe, err := LoadPluginProbe(path)
+				cached = pluginCacheEntry{
+					Size:        stat.Size(),
+					ModTime:     stat.ModTime(),
+					Description: probe.description,
+					Failed:      err != nil,
+				}
+				changed = true
+			}
+			fresh[path] = cached
+
+			probe := PluginProbe{Executable: path, description: cached.Description}
+			if cached.Failed || !pluginName.MatchString(probe.Name()) || names[probe.Name()] || LookupProbe(probe.Name()) != nil {
+				continue
+			}
+			names[probe.Name()] = true
+			probes = append(probes, probe)
+		}
+	}
+
+	if changed || len(fresh) != len(cache) {
+		writePluginCache(cachePath, fresh)
+	}
+
+	return probes
+}
+
+// PluginDirs returns the directories that are searched for plugins: the
+// directory named by $VCSINFO_PLUGIN_DIR (which defaults to vcsinfo/plugins in
+// $XDG_CONFIG_HOME). If $VCSINFO_PLUGIN_PATH is "true", the directories in
+// $PATH are searched after it. They aren't by default, as that would run
+// whatever happens to be named vcsinfo-probe-* on the $PATH.
+func PluginDirs() []string {
+	dirs := []string{}
+
+	pluginDir := os.Getenv("VCSINFO_PLUGIN_DIR")
+	if pluginDir == "" {
+		configDir := os.Getenv("XDG_CONFIG_HOME")
+		if configDir == "" {
+			if home, err := os.UserHomeDir(); err == nil {
+				configDir = filepath.Join(home, ".config")
+			}
+		}
+		if configDir != "" {
+			pluginDir = filepath.Join(configDir, "vcsinfo", "plugins")
+		}
+	}
+	if pluginDir != "" {
+		dirs = append(dirs, pluginDir)
+	}
+
+	if os.Getenv("VCSINFO_PLUGIN_PATH") != "true" {
+		return dirs
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// pluginCacheEntry is what's remembered about a plugin between runs.
+type pluginCacheEntry struct {
+	// The size and modification time of the executable when it described
+	// itself.
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+
+	Description pluginDescription `json:"description"`
+
+	// Indicates whether or not the plugin failed to describe itself.
+	Failed bool `json:"failed,omitempty"`
+}
+
+// pluginCachePath returns the path of the file that the plugin descriptions
+// are cached in, or an empty string if there's nowhere to put it.
+func pluginCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "vcsinfo", "plugins.json")
+}
+
+// readPluginCache reads the cached plugin descriptions, keyed by the path of
+// the executable. A missing or unreadable cache is treated as empty.
+func readPluginCache(path string) map[string]pluginCacheEntry {
+	cache := make(map[string]pluginCacheEntry)
+	if path == "" {
+		return cache
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+	if json.Unmarshal(content, &cache) != nil {
+		return make(map[string]pluginCacheEntry)
+	}
+	return cache
+}
+
+// writePluginCache replaces the cached plugin descriptions. Failures are
+// ignored, as they only mean the plugins will be described again next time.
+func writePluginCache(path string, cache map[string]pluginCacheEntry) {
+	if path == "" {
+		return
+	}
+
+	content, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(path), 0700) != nil {
+		return
+	}
+
+	// Write it alongside and then move it into place, so that concurrent
+	// runs never read a partially written cache.
+	tmp, err := os.CreateTemp(filepath.Dir(path), "plugins-*.json")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// call makes the request of the plugin, and decodes its response.
+func (probe PluginProbe) call(ctx context.Context, request pluginRequest, response interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	input, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(probe.Executable)
+	cmd.Dir = request.Path
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = runProcess(ctx, cmd)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return fmt.Errorf("%s failed to handle %s: %s", probe.Executable, request.Request, message)
+		}
+		return fmt.Errorf("%s failed to handle %s: %s", probe.Executable, request.Request, err)
+	}
+
+	err = json.Unmarshal(stdout.Bytes(), response)
+	if err != nil {
+		return fmt.Errorf("%s returned an invalid response to %s: %s", probe.Executable, request.Request, err)
+	}
+	return nil
+}
+
+// Name returns the human-facing name of the probe.
+func (probe PluginProbe) Name() string {
+	if probe.description.Name != "" {
+		return probe.description.Name
+	}
+	return strings.TrimPrefix(filepath.Base(probe.Executable), PluginPrefix)
+}
+
+// DefaultFormat returns the default format string to use for the repositories.
+func (probe PluginProbe) DefaultFormat() string {
+	if probe.description.DefaultFormat != "" {
+		return probe.description.DefaultFormat
+	}
+	return "%n[%b%m%u]"
+}
+
+// IsAvailable indicates whether or not this probe has the tools/environment
+// necessary to operate.
+func (probe PluginProbe) IsAvailable() (bool, error) {
+	return commandExists(probe.Executable), nil
+}
+
+// IsRepositoryRoot identifies whether or not the specified path is the root
+// of a repository this probe can handle, by checking for the root markers the
+// plugin described.
+func (probe PluginProbe) IsRepositoryRoot(path string) (bool, error) {
+	for _, marker := range probe.description.RootMarkers {
+		exists, err := fileExists(filepath.Join(path, marker))
+		if exists || err != nil {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// GatherInfo extracts and returns VCS information for the repository at the
+// specified path.
+func (probe PluginProbe) GatherInfo(path string) (VcsInfo, []error) {
+	return probe.GatherInfoContext(context.Background(), path)
+}
+
+// GatherInfoContext extracts and returns VCS information for the repository
+// at the specified path. If the context expires before the plugin responds,
+// only the name and path are returned.
+func (probe PluginProbe) GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error) {
+	response := pluginInfoResponse{}
+	err := probe.call(ctx, pluginRequest{Request: "gather_info", Path: path}, &response)
+
+	info := response.Info
+	if info.VcsName == "" {
+		info.VcsName = probe.Name()
+	}
+	if info.Path == "" {
+		info.Path = path
+	}
+	if err != nil {
+		return info, []error{err}
+	}
+
+	if info.RepositoryRoot == "" {
+		info.RepositoryRoot, _ = findAcceptablePath(path, probe.IsRepositoryRoot)
+	}
+
+	var errs []error
+	for _, message := range response.Errors {
+		errs = append(errs, errors.New(message))
+	}
+	return info, errs
+}
@@ -0,0 +1,245 @@
+package vcsinfo_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/jayclassless/vcsinfo"
+)
+
+const fakePlugin = `#!/bin/sh
+read request
+case "$request" in
+  *'"describe"'*)
+    echo '{"name": "fake", "default_format": "%n:%b", "root_markers": [".fake"]}'
+    ;;
+  *'"gather_info"'*)
+    echo '{"info": {"branch": "trunk", "has_modified": true}, "errors": ["partial failure"]}'
+    ;;
+esac
+`
+
+const minimalPlugin = `#!/bin/sh
+read request
+case "$request" in
+  *'"describe"'*)
+    echo '{"root_markers": [".other"]}'
+    ;;
+  *'"gather_info"'*)
+    sleep 5
+    ;;
+esac
+`
+
+const markerlessPlugin = `#!/bin/sh
+echo '{"name": "markerless"}'
+`
+
+const impostorPlugin = `#!/bin/sh
+echo '{"name": "git", "root_markers": [".impostor"]}'
+`
+
+const badlyNamedPlugin = `#!/bin/sh
+echo '{"name": "my vcs", "root_markers": [".mine"]}'
+`
+
+const brokenPlugin = `#!/bin/sh
+echo "no such thing" >&2
+exit 1
+`
+
+func writePlugin(dir string, name string, script string) string {
+	path := filepath.Join(dir, name)
+	os.WriteFile(path, []byte(script), 0755)
+	return path
+}
+
+func readFile(path string) string {
+	content, _ := os.ReadFile(path)
+	return string(content)
+}
+
+var _ = Describe("PluginProbe", func() {
+	var pluginDir, dir string
+
+	BeforeEach(func() {
+		pluginDir = tmpdir()
+		dir = tmpdir()
+	})
+
+	AfterEach(func() {
+		rmdir(pluginDir)
+		rmdir(dir)
+		pluginDir = ""
+		dir = ""
+	})
+
+	It("describes itself", func() {
+		probe, err := LoadPluginProbe(writePlugin(pluginDir, "vcsinfo-probe-fake", fakePlugin))
+		Expect(err).To(BeNil())
+		Expect(probe.Name()).To(Equal("fake"))
+		Expect(probe.DefaultFormat()).To(Equal("%n:%b"))
+		Expect(probe.IsAvailable()).To(BeTrue())
+	})
+
+	It("falls back to defaults for what it doesn't describe", func() {
+		probe, err := LoadPluginProbe(writePlugin(pluginDir, "vcsinfo-probe-other", minimalPlugin))
+		Expect(err).To(BeNil())
+		Expect(probe.Name()).To(Equal("other"))
+		Expect(probe.DefaultFormat()).To(Equal("%n[%b%m%u]"))
+	})
+
+	It("fails to load broken plugins", func() {
+		_, err := LoadPluginProbe(writePlugin(pluginDir, "vcsinfo-probe-broken", brokenPlugin))
+		Expect(err).To(MatchError(ContainSubstring("no such thing")))
+	})
+
+	It("detects repositories using the root markers", func() {
+		probe, _ := LoadPluginProbe(writePlugin(pluginDir, "vcsinfo-probe-fake", fakePlugin))
+		Expect(probe.IsRepositoryRoot(dir)).To(BeFalse())
+		mkdir(dir, ".fake")
+		Expect(probe.IsRepositoryRoot(dir)).To(BeTrue())
+
+		mkdir(dir, "deeper")
+		found, err := FindProbeForPath(filepath.Join(dir, "deeper"), []VcsProbe{probe})
+		Expect(err).To(BeNil())
+		Expect(found).To(Equal(probe))
+	})
+
+	It("fails to load plugins with invalid names", func() {
+		_, err := LoadPluginProbe(writePlugin(pluginDir, "vcsinfo-probe-mine", badlyNamedPlugin))
+		Expect(err).To(MatchError(ContainSubstring("invalid name")))
+	})
+
+	It("fails to load plugins without root markers", func() {
+		_, err := LoadPluginProbe(writePlugin(pluginDir, "vcsinfo-probe-markerless", markerlessPlugin))
+		Expect(err).To(MatchError(ContainSubstring("root_markers")))
+	})
+
+	It("gathers info", func() {
+		probe, _ := LoadPluginProbe(writePlugin(pluginDir, "vcsinfo-probe-fake", fakePlugin))
+		mkdir(dir, ".fake")
+		mkdir(dir, "deeper")
+
+		info, errs := probe.GatherInfo(filepath.Join(dir, "deeper"))
+		Expect(errs).To(ConsistOf(MatchError("partial failure")))
+		Expect(info).To(MatchFields(IgnoreExtras, Fields{
+			"VcsName":        Equal("fake"),
+			"Path":           Equal(filepath.Join(dir, "deeper")),
+			"RepositoryRoot": Equal(dir),
+			"Branch":         Equal("trunk"),
+			"HasModified":    BeTrue(),
+		}))
+
+		output, err := InfoToString(info, probe.DefaultFormat(), GetDefaultFormatOptions())
+		Expect(err).To(BeNil())
+		Expect(output).To(Equal("fake:trunk"))
+	})
+
+	It("gives up when the context expires", func() {
+		probe, _ := LoadPluginProbe(writePlugin(pluginDir, "vcsinfo-probe-other", minimalPlugin))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		info, errs := probe.GatherInfoContext(ctx, dir)
+		Expect(errs).To(ConsistOf(context.DeadlineExceeded))
+		Expect(info.VcsName).To(Equal("other"))
+	})
+
+	Describe("FindPluginProbes", func() {
+		var cacheDir, originalCacheDir string
+
+		BeforeEach(func() {
+			cacheDir = tmpdir()
+			originalCacheDir = os.Getenv("XDG_CACHE_HOME")
+			os.Setenv("XDG_CACHE_HOME", cacheDir)
+		})
+
+		AfterEach(func() {
+			os.Setenv("XDG_CACHE_HOME", originalCacheDir)
+			rmdir(cacheDir)
+			cacheDir = ""
+		})
+
+		It("finds the plugin executables", func() {
+			writePlugin(pluginDir, "vcsinfo-probe-fake", fakePlugin)
+			writePlugin(pluginDir, "vcsinfo-probe-broken", brokenPlugin)
+			writePlugin(pluginDir, "vcsinfo-probe-markerless", markerlessPlugin)
+			writePlugin(pluginDir, "something-else", fakePlugin)
+			writeFile(pluginDir, "vcsinfo-probe-unexecutable", fakePlugin)
+
+			probes := FindPluginProbes([]string{pluginDir})
+			Expect(probes).To(HaveLen(1))
+			Expect(probes[0].Name()).To(Equal("fake"))
+		})
+
+		It("prefers the earlier directories", func() {
+			writePlugin(pluginDir, "vcsinfo-probe-fake", fakePlugin)
+			writePlugin(dir, "vcsinfo-probe-fake", minimalPlugin)
+
+			probes := FindPluginProbes([]string{pluginDir, dir, filepath.Join(dir, "missing")})
+			Expect(probes).To(HaveLen(1))
+			Expect(probes[0].(PluginProbe).Executable).To(Equal(filepath.Join(pluginDir, "vcsinfo-probe-fake")))
+		})
+
+		It("skips plugins whose names are taken", func() {
+			writePlugin(pluginDir, "vcsinfo-probe-fake", fakePlugin)
+			writePlugin(pluginDir, "vcsinfo-probe-impostor", impostorPlugin)
+			writePlugin(pluginDir, "vcsinfo-probe-mine", badlyNamedPlugin)
+			writePlugin(dir, "vcsinfo-probe-another", fakePlugin)
+
+			probes := FindPluginProbes([]string{pluginDir, dir})
+			Expect(probes).To(HaveLen(1))
+			Expect(probes[0].(PluginProbe).Executable).To(Equal(filepath.Join(pluginDir, "vcsinfo-probe-fake")))
+		})
+
+		It("only asks plugins to describe themselves when they change", func() {
+			log := filepath.Join(dir, "described")
+			plugin := writePlugin(pluginDir, "vcsinfo-probe-counted", "#!/bin/sh\necho described >> '"+log+"'\necho '{\"name\": \"counted\", \"root_markers\": [\".counted\"]}'\n")
+
+			Expect(FindPluginProbes([]string{pluginDir})).To(HaveLen(1))
+			probes := FindPluginProbes([]string{pluginDir})
+			Expect(probes).To(HaveLen(1))
+			Expect(probes[0].Name()).To(Equal("counted"))
+			Expect(readFile(log)).To(Equal("described\n"))
+
+			later := time.Now().Add(time.Minute)
+			Expect(os.Chtimes(plugin, later, later)).To(Succeed())
+			Expect(FindPluginProbes([]string{pluginDir})).To(HaveLen(1))
+			Expect(readFile(log)).To(Equal("described\ndescribed\n"))
+		})
+	})
+
+	Describe("PluginDirs", func() {
+		var originalPluginDir, originalPluginPath string
+
+		BeforeEach(func() {
+			originalPluginDir = os.Getenv("VCSINFO_PLUGIN_DIR")
+			originalPluginPath = os.Getenv("VCSINFO_PLUGIN_PATH")
+			os.Setenv("VCSINFO_PLUGIN_DIR", pluginDir)
+		})
+
+		AfterEach(func() {
+			os.Setenv("VCSINFO_PLUGIN_DIR", originalPluginDir)
+			os.Setenv("VCSINFO_PLUGIN_PATH", originalPluginPath)
+		})
+
+		It("only searches the plugin directory by default", func() {
+			os.Setenv("VCSINFO_PLUGIN_PATH", "")
+			Expect(PluginDirs()).To(Equal([]string{pluginDir}))
+		})
+
+		It("searches the $PATH when asked", func() {
+			os.Setenv("VCSINFO_PLUGIN_PATH", "true")
+			Expect(PluginDirs()).To(HaveLen(1 + len(filepath.SplitList(os.Getenv("PATH")))))
+			Expect(PluginDirs()[0]).To(Equal(pluginDir))
+		})
+	})
+})
@@ -0,0 +1,170 @@
+package vcsinfo
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The priorities the built-in probes are registered with. When looking for the
+// probe to use for a path, the probes with higher priorities are tried first
+// (e.g., Jujutsu and Sapling come before Git, so that repositories they share
+// with Git are identified as theirs).
+const (
+	PriorityJj      = 80
+	PrioritySapling = 75
+	PriorityGit     = 70
+	PriorityHg      = 60
+	PrioritySvn     = 50
+	PriorityBzr     = 40
+	PriorityFossil  = 30
+	PriorityDarcs   = 20
+	PriorityCvs     = 10
+	PriorityP4      = 5
+)
+
+type registration struct {
+	probe    VcsProbe
+	priority int
+}
+
+var (
+	registry     []registration
+	registryLock sync.RWMutex
+)
+
+func init() {
+	Register(JjProbe{}, PriorityJj)
+	Register(SaplingProbe{}, PrioritySapling)
+	Register(GitProbe{}, PriorityGit)
+	Register(HgProbe{}, PriorityHg)
+	Register(SvnProbe{}, PrioritySvn)
+	Register(BzrProbe{}, PriorityBzr)
+	Register(FossilProbe{}, PriorityFossil)
+	Register(DarcsProbe{}, PriorityDarcs)
+	Register(CvsProbe{}, PriorityCvs)
+	Register(P4Probe{}, PriorityP4)
+}
+
+// Register adds the probe to the registry used by GetAvailableProbes, with the
+// specified priority. Probes with higher priorities come first (and so are
+// preferred by FindProbeForPath); probes with equal priorities are kept in the
+// order they were registered. If a probe with the same name is already
+// registered, it is replaced.
+func Register(probe VcsProbe, priority int) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	unregister(probe.Name())
+	registry = append(registry, registration{probe: probe, priority: priority})
+	sort.SliceStable(registry, func(i, j int) bool {
+		return registry[i].priority > registry[j].priority
+	})
+}
+
+// Unregister removes the probe with the specified name from the registry. It
+// returns false if there was no such probe.
+func Unregister(name string) bool {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	return unregister(name)
+}
+
+func unregister(name string) bool {
+	for idx, entry := range registry {
+		if entry.probe.Name() == name {
+			registry = append(registry[:idx:idx], registry[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Probes returns all of the registered probes (whether or not they are
+// available in the current environment), in order of priority.
+func Probes() []VcsProbe {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	probes := make([]VcsProbe, 0, len(registry))
+	for _, entry := range registry {
+		probes = append(probes, entry.probe)
+	}
+	return probes
+}
+
+// LookupProbe returns the registered probe with the specified name, or nil if
+// there is no such probe.
+func LookupProbe(name string) VcsProbe {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	for _, entry := range registry {
+		if entry.probe.Name() == name {
+			return entry.probe
+		}
+	}
+	return nil
+}
+
+// ProbeOptions controls which probes are returned by
+// GetAvailableProbesWithOptions and SelectProbes.
+type ProbeOptions struct {
+	// The names of the probes to include. If empty, all probes are included.
+	Allow []string
+
+	// The names of the probes to exclude.
+	Deny []string
+
+	// Indicates whether or not external probes (see PluginProbe) should be
+	// excluded.
+	NoPlugins bool
+}
+
+// ParseProbeOptions creates a ProbeOptions from a comma-separated list of
+// probe names (e.g., "git,hg"). Names prefixed with "-" (e.g., "-cvs") are
+// excluded rather than included.
+func ParseProbeOptions(names string) ProbeOptions {
+	options := ProbeOptions{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "" || name == "-":
+			continue
+		case strings.HasPrefix(name, "-"):
+			options.Deny = append(options.Deny, name[1:])
+		default:
+			options.Allow = append(options.Allow, name)
+		}
+	}
+	return options
+}
+
+// SelectProbes returns the probes that are permitted by the options, keeping
+// them in the order they were given.
+func SelectProbes(probes []VcsProbe, options ProbeOptions) []VcsProbe {
+	contains := func(names []string, name string) bool {
+		for _, candidate := range names {
+			if candidate == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	selected := []VcsProbe{}
+	for _, probe := range probes {
+		if len(options.Allow) > 0 && !contains(options.Allow, probe.Name()) {
+			continue
+		}
+		if contains(options.Deny, probe.Name()) {
+			continue
+		}
+		if _, ok := probe.(PluginProbe); ok && options.NoPlugins {
+			continue
+		}
+		selected = append(selected, probe)
+	}
+	return selected
+}
@@ -0,0 +1,130 @@
+package vcsinfo_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/jayclassless/vcsinfo"
+)
+
+type fakeProbe struct {
+	name string
+}
+
+func (probe fakeProbe) Name() string {
+	return probe.name
+}
+
+func (probe fakeProbe) DefaultFormat() string {
+	return "%n"
+}
+
+func (probe fakeProbe) IsAvailable() (bool, error) {
+	return true, nil
+}
+
+func (probe fakeProbe) IsRepositoryRoot(path string) (bool, error) {
+	return false, nil
+}
+
+func (probe fakeProbe) GatherInfo(path string) (VcsInfo, []error) {
+	return probe.GatherInfoContext(context.Background(), path)
+}
+
+func (probe fakeProbe) GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error) {
+	return VcsInfo{VcsName: probe.name, Path: path}, nil
+}
+
+func probeNames(probes []VcsProbe) []string {
+	names := []string{}
+	for _, probe := range probes {
+		names = append(names, probe.Name())
+	}
+	return names
+}
+
+var _ = Describe("Registry", func() {
+	AfterEach(func() {
+		Unregister("fake")
+		Unregister("other")
+		Register(GitProbe{}, PriorityGit)
+	})
+
+	It("contains the built-in probes", func() {
+		Expect(probeNames(Probes())).To(Equal([]string{"jj", "sl", "git", "hg", "svn", "bzr", "fossil", "darcs", "cvs", "p4"}))
+		Expect(LookupProbe("hg")).To(Equal(HgProbe{}))
+		Expect(LookupProbe("fake")).To(BeNil())
+	})
+
+	It("orders probes by priority", func() {
+		Register(fakeProbe{"fake"}, PriorityGit+1)
+		Register(fakeProbe{"other"}, PriorityHg)
+		Expect(probeNames(Probes())).To(Equal([]string{"jj", "sl", "fake", "git", "hg", "other", "svn", "bzr", "fossil", "darcs", "cvs", "p4"}))
+		Expect(LookupProbe("fake")).To(Equal(fakeProbe{"fake"}))
+	})
+
+	It("replaces probes with the same name", func() {
+		Register(GitProbe{Native: true}, 0)
+		Expect(probeNames(Probes())).To(Equal([]string{"jj", "sl", "hg", "svn", "bzr", "fossil", "darcs", "cvs", "p4", "git"}))
+		Expect(LookupProbe("git")).To(Equal(GitProbe{Native: true}))
+	})
+
+	It("unregisters probes", func() {
+		Expect(Unregister("git")).To(BeTrue())
+		Expect(Unregister("git")).To(BeFalse())
+		Expect(LookupProbe("git")).To(BeNil())
+		Expect(probeNames(Probes())).NotTo(ContainElement("git"))
+	})
+
+	It("is used by GetAvailableProbes", func() {
+		Register(fakeProbe{"fake"}, PriorityJj+1)
+		probes, err := GetAvailableProbes()
+		Expect(err).To(BeNil())
+		Expect(probes[0]).To(Equal(fakeProbe{"fake"}))
+	})
+
+	Describe("GetAvailableProbesWithOptions", func() {
+		It("only returns the allowed probes", func() {
+			Register(fakeProbe{"fake"}, 0)
+			probes, err := GetAvailableProbesWithOptions(ProbeOptions{Allow: []string{"fake", "git"}})
+			Expect(err).To(BeNil())
+			Expect(probeNames(probes)).To(Equal([]string{"git", "fake"}))
+		})
+
+		It("doesn't return the denied probes", func() {
+			Register(fakeProbe{"fake"}, 0)
+			probes, err := GetAvailableProbesWithOptions(ProbeOptions{Deny: []string{"git"}})
+			Expect(err).To(BeNil())
+			Expect(probeNames(probes)).To(ContainElement("fake"))
+			Expect(probeNames(probes)).NotTo(ContainElement("git"))
+		})
+	})
+
+	Describe("ParseProbeOptions", func() {
+		It("parses the names", func() {
+			Expect(ParseProbeOptions("")).To(Equal(ProbeOptions{}))
+			Expect(ParseProbeOptions("git, hg")).To(Equal(ProbeOptions{Allow: []string{"git", "hg"}}))
+			Expect(ParseProbeOptions("-cvs,git,-")).To(Equal(ProbeOptions{Allow: []string{"git"}, Deny: []string{"cvs"}}))
+		})
+	})
+
+	Describe("SelectProbes", func() {
+		probes := []VcsProbe{fakeProbe{"fake"}, GitProbe{}, fakeProbe{"other"}}
+
+		It("keeps the order of the probes", func() {
+			Expect(probeNames(SelectProbes(probes, ProbeOptions{Allow: []string{"other", "fake"}}))).To(Equal([]string{"fake", "other"}))
+		})
+
+		It("applies the allow and deny lists together", func() {
+			Expect(probeNames(SelectProbes(probes, ProbeOptions{Allow: []string{"git", "fake"}, Deny: []string{"fake"}}))).To(Equal([]string{"git"}))
+		})
+
+		It("excludes plugins when asked", func() {
+			plugins := []VcsProbe{GitProbe{}, PluginProbe{Executable: "/bin/vcsinfo-probe-fake"}}
+			Expect(probeNames(SelectProbes(plugins, ProbeOptions{}))).To(Equal([]string{"git", "fake"}))
+			Expect(probeNames(SelectProbes(plugins, ProbeOptions{NoPlugins: true}))).To(Equal([]string{"git"}))
+		})
+	})
+})
@@ -0,0 +1,239 @@
+package vcsinfo
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SaplingProbe is a probe for extracting information out of a Sapling
+// checkout. Sapling descends from Mercurial, so much of its output is parsed
+// the same way as HgProbe's. Checkouts that use a Git repository as their
+// backing store (i.e., that have a .git directory managed by Sapling) are
+// identified as Sapling rather than Git.
+type SaplingProbe struct{}
+
+// Name returns the human-facing name of the probe.
+func (probe SaplingProbe) Name() string {
+	return "sl"
+}
+
+// DefaultFormat returns the default format string to use for Sapling
+// checkouts.
+func (probe SaplingProbe) DefaultFormat() string {
+	return "%n[%d%c%m%u%t]"
+}
+
+// IsAvailable indicates whether or not this probe has the tools/environment
+// necessary to operate.
+func (probe SaplingProbe) IsAvailable() (bool, error) {
+	path, err := exec.LookPath("sl")
+	if path == "" || err != nil {
+		return false, nil
+	}
+
+	// The Steam Locomotive joke is also called sl, and is installed with the
+	// games (e.g., /usr/games/sl), where Sapling never is.
+	return filepath.Base(filepath.Dir(path)) != "games", nil
+}
+
+// IsRepositoryRoot identifies whether or not the specified path is the root
+// of a Sapling checkout.
+func (probe SaplingProbe) IsRepositoryRoot(path string) (bool, error) {
+	exists, err := dirExists(filepath.Join(path, ".sl"))
+	if exists || err != nil {
+		return exists, err
+	}
+
+	// When Sapling works on a Git repository directly, it keeps its own state
+	// within the .git directory.
+	return dirExists(filepath.Join(path, ".git", "sl"))
+}
+
+func runSlCommand(ctx context.Context, workingDir string, command ...string) ([]string, error) {
+	return runCommand(ctx, workingDir, append([]string{"sl"}, command[0:]...)...)
+}
+
+func (probe SaplingProbe) extractStatus(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runSlCommand(
+		ctx,
+		path,
+		"status",
+		"--modified", "--added", "--removed", "--deleted", "--unknown",
+	)
+	if err != nil {
+		return err
+	}
+
+	parseHgStatus(out, info)
+	return nil
+}
+
+func (probe SaplingProbe) extractConflicts(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runSlCommand(ctx, path, "resolve", "--list")
+	if err != nil {
+		return err
+	}
+
+	parseHgConflicts(out, info)
+	return nil
+}
+
+// extractCommitInfo retrieves the hash of the current commit and the active
+// bookmark. Sapling doesn't have named branches, so the active bookmark is
+// reported as the branch; without one, the checkout is considered detached.
+func (probe SaplingProbe) extractCommitInfo(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runSlCommand(ctx, path, "log", "--rev", ".", "--template", "{node}\n{activebookmark}\n")
+	if err != nil || len(out) == 0 {
+		return err
+	}
+
+	if len(out) > 1 && out[1] != "" {
+		info.Branch = out[1]
+	}
+
+	if strings.HasPrefix(out[0], "0000000000000000000000000000000000000000") || len(out[0]) < 12 {
+		// There aren't any commits yet.
+		return nil
+	}
+
+	info.Hash = out[0]
+	info.ShortHash = info.Hash[0:12]
+	if info.Branch == "" {
+		info.Detached = true
+		info.DetachedLabel = info.ShortHash
+	}
+
+	return nil
+}
+
+func (probe SaplingProbe) extractCommitMetadata(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runSlCommand(ctx, path, "log", "--rev", ".", "--template", hgCommitTemplate)
+	if err != nil {
+		return err
+	}
+
+	return parseHgCommitMetadata(out, info)
+}
+
+func (probe SaplingProbe) extractShelved(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runSlCommand(ctx, path, "shelve", "--list")
+	if err != nil {
+		return err
+	}
+
+	info.HasStashed = len(out) > 0
+	info.StashCount = len(out)
+	return nil
+}
+
+// GatherInfo extracts and returns VCS information for the Sapling checkout at
+// the specified path.
+func (probe SaplingProbe) GatherInfo(path string) (VcsInfo, []error) {
+	return probe.GatherInfoContext(context.Background(), path)
+}
+
+// GatherInfoContext extracts and returns VCS information for the Sapling
+// checkout at the specified path. If the context expires before all
+// information could be gathered, the fields collected so far are returned.
+func (probe SaplingProbe) GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error) {
+	return probe.GatherInfoFields(ctx, path, FieldAll)
+}
+
+// GatherInfoFields extracts and returns the requested VCS information for the
+// Sapling checkout at the specified path, skipping the work needed for the
+// other fields. If the context expires before all information could be
+// gathered, the fields collected so far are returned.
+func (probe SaplingProbe) GatherInfoFields(ctx context.Context, path string, fields FieldMask) (VcsInfo, []error) {
+	info := VcsInfo{
+		VcsName: probe.Name(),
+		Path:    path,
+	}
+
+	root, err := findAcceptablePath(path, probe.IsRepositoryRoot)
+	if err != nil {
+		return info, []error{err}
+	}
+	info.RepositoryRoot = root
+
+	errors := waitGroup(
+		fields.when(FieldStatus|FieldUntracked, func() error {
+			return probe.extractStatus(ctx, path, &info)
+		}),
+
+		fields.when(FieldStatus, func() error {
+			return probe.extractConflicts(ctx, path, &info)
+		}),
+
+		fields.when(FieldBranch|FieldHash, func() error {
+			return probe.extractCommitInfo(ctx, path, &info)
+		}),
+
+		fields.when(FieldCommit, func() error {
+			return probe.extractCommitMetadata(ctx, path, &info)
+		}),
+
+		fields.when(FieldStash, func() error {
+			return probe.extractShelved(ctx, path, &info)
+		}),
+	)
+
+	return info, errors
+}
+
+// FileStatus returns the status of each file in the Sapling checkout at the
+// specified path that differs from the current commit.
+func (probe SaplingProbe) FileStatus(path string) ([]FileStatus, error) {
+	return probe.FileStatusContext(context.Background(), path)
+}
+
+// FileStatusContext returns the status of each file in the Sapling checkout at
+// the specified path that differs from the current commit, abandoning the
+// work when the context expires.
+func (probe SaplingProbe) FileStatusContext(ctx context.Context, path string) ([]FileStatus, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := runSlCommand(
+		ctx,
+		root,
+		"status",
+		"--modified", "--added", "--removed", "--deleted", "--unknown", "--copies",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts, err := runSlCommand(ctx, root, "resolve", "--list")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseHgFileStatus(out, conflicts), nil
+}
+
+// DiffStat summarizes the differences between the Sapling checkout at the
+// specified path and the current commit.
+func (probe SaplingProbe) DiffStat(path string) (DiffStat, error) {
+	return probe.DiffStatContext(context.Background(), path)
+}
+
+// DiffStatContext summarizes the differences between the Sapling checkout at
+// the specified path and the current commit, abandoning the work when the
+// context expires.
+func (probe SaplingProbe) DiffStatContext(ctx context.Context, path string) (DiffStat, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	out, err := runSlCommand(ctx, root, "diff", "--stat")
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	return parseDiffStatSummary(out), nil
+}
@@ -0,0 +1,350 @@
+package vcsinfo_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/jayclassless/vcsinfo"
+)
+
+var _ = Describe("Sapling", func() {
+	probe := SaplingProbe{}
+
+	Describe("Name", func() {
+		It("works", func() {
+			Expect(probe.Name()).To(Equal("sl"))
+		})
+	})
+
+	Describe("DefaultFormat", func() {
+		It("works", func() {
+			Expect(probe.DefaultFormat()).To(Not(Equal("")))
+		})
+	})
+
+	Describe("IsAvailable", func() {
+		It("works", func() {
+			Expect(probe.IsAvailable()).To(BeTrue())
+		})
+	})
+
+	Describe("IsRepositoryRoot", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("returns false in dir with no repo", func() {
+			Expect(probe.IsRepositoryRoot(dir)).To(BeFalse())
+		})
+
+		It("returns true in dir with new repo", func() {
+			run(dir, "sl", "init", "--git")
+			Expect(probe.IsRepositoryRoot(dir)).To(BeTrue())
+		})
+
+		It("returns false in a plain git repo", func() {
+			run(dir, "git", "init")
+			Expect(probe.IsRepositoryRoot(dir)).To(BeFalse())
+
+			found, err := FindProbeForPath(dir, Probes())
+			Expect(err).To(BeNil())
+			Expect(found.Name()).To(Equal("git"))
+		})
+
+		It("is preferred over git for git repos it manages", func() {
+			run(dir, "git", "init")
+			mkdir(dir, ".git", "sl")
+
+			found, err := FindProbeForPath(dir, Probes())
+			Expect(err).To(BeNil())
+			Expect(found).To(Equal(probe))
+		})
+	})
+
+	Describe("GatherInfo", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			run(dir, "sl", "init", "--git")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("returns the basics", func() {
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName":        Equal("sl"),
+				"Path":           Equal(dir),
+				"RepositoryRoot": Equal(dir),
+			}))
+		})
+
+		It("returns the basics when deep in repo", func() {
+			deep := mkdir(dir, "/some/deep/path")
+			info, err := probe.GatherInfo(deep)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName":        Equal("sl"),
+				"Path":           Equal(deep),
+				"RepositoryRoot": Equal(dir),
+			}))
+		})
+
+		It("sees nothing when empty", func() {
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasNew":       BeFalse(),
+				"HasModified":  BeFalse(),
+				"HasStaged":    BeFalse(),
+				"HasStashed":   BeFalse(),
+				"HasConflicts": BeFalse(),
+				"Hash":         Equal(""),
+				"ShortHash":    Equal(""),
+				"Branch":       Equal(""),
+				"Detached":     BeFalse(),
+			}))
+		})
+
+		It("sees new files", func() {
+			writeFile(dir, "foo", "bar")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasNew":      BeTrue(),
+				"HasModified": BeFalse(),
+				"HasStaged":   BeFalse(),
+				"HasStashed":  BeFalse(),
+			}))
+		})
+
+		It("sees modified files", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "sl", "add", "foo")
+			run(dir, "sl", "commit", "-m", "blah")
+			writeFile(dir, "foo", "baz")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasNew":      BeFalse(),
+				"HasModified": BeTrue(),
+				"HasStaged":   BeFalse(),
+				"HasStashed":  BeFalse(),
+				"Hash":        HaveLen(40),
+				"ShortHash":   HaveLen(12),
+			}))
+		})
+
+		It("sees deleted files", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "sl", "add", "foo")
+			run(dir, "sl", "commit", "-m", "blah")
+			rm(dir, "foo")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasNew":      BeFalse(),
+				"HasModified": BeTrue(),
+			}))
+		})
+
+		It("sees shelved changes", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "sl", "add", "foo")
+			run(dir, "sl", "commit", "-m", "blah")
+			writeFile(dir, "foo", "baz")
+			run(dir, "sl", "shelve")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasModified": BeFalse(),
+				"HasStashed":  BeTrue(),
+				"StashCount":  Equal(1),
+			}))
+		})
+
+		It("counts changes", func() {
+			writeFile(dir, "foo", "bar")
+			writeFile(dir, "bar", "baz")
+			run(dir, "sl", "add", "foo", "bar")
+			run(dir, "sl", "commit", "-m", "blah")
+			writeFile(dir, "foo", "modified")
+			writeFile(dir, "bar", "modified")
+			writeFile(dir, "new", "file")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"ModifiedCount":  Equal(2),
+				"UntrackedCount": Equal(1),
+			}))
+		})
+
+		It("sees the active bookmark", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "sl", "add", "foo")
+			run(dir, "sl", "commit", "-m", "blah")
+			run(dir, "sl", "bookmark", "feature")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Branch":   Equal("feature"),
+				"Detached": BeFalse(),
+			}))
+		})
+
+		It("sees when there is no active bookmark", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "sl", "add", "foo")
+			run(dir, "sl", "commit", "-m", "blah")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"Branch":        Equal(""),
+				"Detached":      BeTrue(),
+				"DetachedLabel": Equal(info.ShortHash),
+			}))
+		})
+
+		It("sees conflicts", func() {
+			writeFile(dir, "foo", "base")
+			run(dir, "sl", "add", "foo")
+			run(dir, "sl", "commit", "-m", "base")
+			run(dir, "sl", "bookmark", "base")
+			writeFile(dir, "foo", "left")
+			run(dir, "sl", "commit", "-m", "left")
+			run(dir, "sl", "bookmark", "left")
+			run(dir, "sl", "goto", "base")
+			writeFile(dir, "foo", "right")
+			run(dir, "sl", "commit", "-m", "right")
+			runIgnoringFailure(dir, "sl", "rebase", "--rev", ".", "--dest", "left")
+
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasConflicts":  BeTrue(),
+				"ConflictCount": Equal(1),
+			}))
+		})
+
+		It("sees commit metadata", func() {
+			writeFile(dir, "foo", "bar")
+			run(dir, "sl", "add", "foo")
+			run(dir, "sl", "commit", "--user", "Jay <jay@example.com>", "-m", "First line\n\nMore details")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"CommitAuthor":  Equal("Jay"),
+				"CommitEmail":   Equal("jay@example.com"),
+				"CommitTime":    BeTemporally("~", time.Now(), time.Minute),
+				"CommitSubject": Equal("First line"),
+			}))
+		})
+
+		It("returns partial info when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			info, err := probe.GatherInfoContext(ctx, dir)
+			Expect(err).To(ContainElement(context.Canceled))
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName":        Equal("sl"),
+				"Path":           Equal(dir),
+				"RepositoryRoot": Equal(dir),
+			}))
+		})
+
+		It("doesnt crash when in VCS special dir", func() {
+			_, err := probe.GatherInfo(dir + "/.sl")
+			Expect(err).To(BeEmpty())
+		})
+	})
+
+	Describe("FileStatus", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			run(dir, "sl", "init", "--git")
+			writeFile(dir, "foo", "bar")
+			writeFile(dir, "baz", "qux")
+			run(dir, "sl", "add", "foo", "baz")
+			run(dir, "sl", "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("sees changed files", func() {
+			writeFile(dir, "foo", "changed")
+			writeFile(dir, "new", "file")
+			run(dir, "sl", "mv", "baz", "moved")
+			files, err := probe.FileStatus(dir)
+			Expect(err).To(BeNil())
+
+			Expect(files).To(ConsistOf(
+				FileStatus{Path: "foo", Index: FileUnmodified, Worktree: FileModified},
+				FileStatus{Path: "new", Index: FileUnmodified, Worktree: FileUntracked},
+				FileStatus{Path: "moved", Index: FileUnmodified, Worktree: FileRenamed, OriginalPath: "baz"},
+			))
+		})
+	})
+
+	Describe("DiffStat", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			run(dir, "sl", "init", "--git")
+			writeFile(dir, "foo", "one\ntwo\nthree\n")
+			run(dir, "sl", "add", "foo")
+			run(dir, "sl", "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+		})
+
+		It("counts changed files and lines", func() {
+			writeFile(dir, "foo", "one\nTWO\nthree\nfour\n")
+			stat, err := probe.DiffStat(dir)
+			Expect(err).To(BeNil())
+
+			Expect(stat).To(Equal(DiffStat{
+				FilesChanged: 1,
+				Insertions:   2,
+				Deletions:    1,
+			}))
+		})
+	})
+})
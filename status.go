@@ -0,0 +1,87 @@
+package vcsinfo
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"path/filepath"
+)
+
+// FileState describes how a file differs from the current changeset.
+type FileState string
+
+// The states that can be reported for a file in FileStatus.
+const (
+	FileUnmodified FileState = "unmodified"
+	FileModified   FileState = "modified"
+	FileAdded      FileState = "added"
+	FileDeleted    FileState = "deleted"
+	FileRenamed    FileState = "renamed"
+	FileCopied     FileState = "copied"
+	FileMissing    FileState = "missing"
+	FileUntracked  FileState = "untracked"
+	FileConflicted FileState = "conflicted"
+)
+
+// FileStatus describes the state of a file in a working copy that differs from
+// the current changeset.
+type FileStatus struct {
+	// The path to the file, relative to the root of the repository (always
+	// using "/" as the separator).
+	Path string `json:"path" xml:"path"`
+
+	// The state of the file in the staging area. This is always FileUnmodified
+	// for VCS that don't have a staging area (i.e., everything but Git).
+	Index FileState `json:"index" xml:"index"`
+
+	// The state of the file in the working copy.
+	Worktree FileState `json:"worktree" xml:"worktree"`
+
+	// The path the file was renamed or copied from, if known.
+	OriginalPath string `json:"original_path" xml:"originalPath"`
+}
+
+// FileStatusProbe is a VcsProbe that is also capable of listing the status of
+// the individual files in a working copy.
+type FileStatusProbe interface {
+	VcsProbe
+
+	// FileStatus returns the status of each file in the working copy of the
+	// repository at the specified path that differs from the current
+	// changeset.
+	FileStatus(path string) ([]FileStatus, error)
+
+	// FileStatusContext returns the status of each file in the working copy
+	// of the repository at the specified path that differs from the current
+	// changeset, abandoning the work when the context expires.
+	FileStatusContext(ctx context.Context, path string) ([]FileStatus, error)
+}
+
+type fileStatusList struct {
+	XMLName xml.Name     `xml:"files"`
+	Files   []FileStatus `xml:"file"`
+}
+
+// FilesToJSON renders the FileStatuses as a JSON array.
+func FilesToJSON(files []FileStatus) (string, error) {
+	if files == nil {
+		files = []FileStatus{}
+	}
+	out, err := json.Marshal(files)
+	return string(out), err
+}
+
+// FilesToXML renders the FileStatuses as an XML document.
+func FilesToXML(files []FileStatus) (string, error) {
+	out, err := xml.Marshal(fileStatusList{Files: files})
+	return string(out), err
+}
+
+// worktreeStatus creates a FileStatus for a VCS that has no staging area.
+func worktreeStatus(path string, state FileState) FileStatus {
+	return FileStatus{
+		Path:     filepath.ToSlash(path),
+		Index:    FileUnmodified,
+		Worktree: state,
+	}
+}
@@ -1,12 +1,23 @@
 package vcsinfo
 
 import (
+	"context"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // SvnProbe is a probe for extracting information out of an SVN repository.
-type SvnProbe struct{}
+type SvnProbe struct {
+	// AskServer causes the probe to retrieve the subject of the current
+	// changeset, which isn't kept in the working copy, by running svn log.
+	// That contacts the server (possibly over the network), so it isn't done
+	// by default; the rest of the commit metadata comes from svn info.
+	AskServer bool
+}
+
+// svnDateLayout is the layout of the dates output by svn info.
+const svnDateLayout = "2006-01-02 15:04:05 -0700"
 
 // Name returns the human-facing name of the probe.
 func (probe SvnProbe) Name() string {
@@ -21,7 +32,7 @@ func (probe SvnProbe) IsAvailable() (bool, error) {
 
 // DefaultFormat returns the default format string to use for SVN repositories.
 func (probe SvnProbe) DefaultFormat() string {
-	return "%n[%b%m%u]"
+	return "%n[%b%c%m%u]"
 }
 
 // IsRepositoryRoot identifies whether or not the specified path is the root
@@ -30,29 +41,42 @@ func (probe SvnProbe) IsRepositoryRoot(path string) (bool, error) {
 	return dirExists(filepath.Join(path, ".svn"))
 }
 
-func (probe SvnProbe) extractStatus(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "svn", "status")
+func (probe SvnProbe) extractStatus(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "svn", "status")
 	if err != nil {
 		return err
 	}
 
 	for _, line := range out {
-		item, props := line[0:1], line[1:2]
+		if strings.HasPrefix(line, "Summary of conflicts:") {
+			// Everything from here on is just a recap of what came before.
+			break
+		}
+		if len(line) < 7 {
+			continue
+		}
+
+		item, props, tree := line[0:1], line[1:2], line[6:7]
 
-		if item == "?" {
+		if item == "C" || props == "C" || tree == "C" {
+			info.HasConflicts = true
+			info.ConflictCount++
+		} else if item == "?" {
 			info.HasNew = true
+			info.UntrackedCount++
 		} else if item != " " || props != " " {
 			info.HasModified = true
+			info.ModifiedCount++
 		}
 	}
 
 	return nil
 }
 
-func (probe SvnProbe) extractInfo(path string, info *VcsInfo) error {
-	out, err := runCommand(path, "svn", "info")
+func (probe SvnProbe) extractInfo(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "svn", "info")
 	if err != nil {
-		if len(out) > 0 {
+		if len(out) > 0 && getExitCode(err) > 0 {
 			// We're likely in a new directory that hasn't been added yet
 			if strings.HasPrefix(out[len(out)-1], "svn: E200009") {
 				return nil
@@ -61,8 +85,16 @@ func (probe SvnProbe) extractInfo(path string, info *VcsInfo) error {
 		return err
 	}
 
+	url, rootURL := "", ""
+
 	for _, line := range out {
-		if strings.HasPrefix(line, "Relative URL: ^") {
+		if strings.HasPrefix(line, "URL: ") {
+			url = line[5:]
+
+		} else if strings.HasPrefix(line, "Repository Root: ") {
+			rootURL = line[17:]
+
+		} else if strings.HasPrefix(line, "Relative URL: ^") {
 			parts := strings.SplitN(line, "^", 2)
 			if parts[1] == "/trunk" {
 				info.Branch = "trunk"
@@ -73,6 +105,58 @@ func (probe SvnProbe) extractInfo(path string, info *VcsInfo) error {
 		} else if strings.HasPrefix(line, "Last Changed Rev: ") {
 			parts := strings.SplitN(line, ": ", 2)
 			info.Revision = parts[1]
+
+		} else if strings.HasPrefix(line, "Last Changed Author: ") {
+			parts := strings.SplitN(line, ": ", 2)
+			info.CommitAuthor = parts[1]
+
+		} else if strings.HasPrefix(line, "Last Changed Date: ") {
+			// The date is followed by a human-friendly version of it in
+			// parenthesis, which we don't need.
+			parts := strings.SplitN(line, ": ", 2)
+			commitTime, err := time.Parse(svnDateLayout, strings.SplitN(parts[1], " (", 2)[0])
+			if err != nil {
+				return err
+			}
+			info.CommitTime = commitTime
+		}
+	}
+
+	if url != "" {
+		// The owner/repository are derived from the root of the repository,
+		// rather than the particular branch/directory checked out.
+		if rootURL == "" {
+			rootURL = url
+		}
+		setRemote(info, "", rootURL)
+		info.RemoteURL = redactRemoteURL(url)
+	}
+
+	return nil
+}
+
+func (probe SvnProbe) extractSubject(ctx context.Context, path string, info *VcsInfo) error {
+	out, err := runCommand(ctx, path, "svn", "log", "--revision", "COMMITTED", "--limit", "1")
+	if err != nil {
+		if len(out) > 0 && getExitCode(err) > 0 {
+			// We're likely in a new directory that hasn't been added yet
+			last := out[len(out)-1]
+			if strings.HasPrefix(last, "svn: E155010") || strings.HasPrefix(last, "svn: E200009") {
+				return nil
+			}
+		}
+		return err
+	}
+
+	// The message follows the header line (e.g., "r1 | user | date | 1 line")
+	// and a blank line.
+	inMessage := false
+	for _, line := range out {
+		if !inMessage && strings.HasPrefix(line, "r") && strings.Contains(line, " | ") {
+			inMessage = true
+		} else if inMessage && strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "-----") {
+			info.CommitSubject = strings.TrimSpace(line)
+			break
 		}
 	}
 
@@ -82,6 +166,21 @@ func (probe SvnProbe) extractInfo(path string, info *VcsInfo) error {
 // GatherInfo extracts and returns VCS information for the SVN repository at
 // the specified path.
 func (probe SvnProbe) GatherInfo(path string) (VcsInfo, []error) {
+	return probe.GatherInfoContext(context.Background(), path)
+}
+
+// GatherInfoContext extracts and returns VCS information for the SVN
+// repository at the specified path. If the context expires before all
+// information could be gathered, the fields collected so far are returned.
+func (probe SvnProbe) GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error) {
+	return probe.GatherInfoFields(ctx, path, FieldAll)
+}
+
+// GatherInfoFields extracts and returns the requested VCS information for the
+// SVN repository at the specified path, skipping the work needed for the
+// other fields. If the context expires before all information could be
+// gathered, the fields collected so far are returned.
+func (probe SvnProbe) GatherInfoFields(ctx context.Context, path string, fields FieldMask) (VcsInfo, []error) {
 	info := VcsInfo{
 		VcsName: probe.Name(),
 		Path:    path,
@@ -94,14 +193,112 @@ func (probe SvnProbe) GatherInfo(path string) (VcsInfo, []error) {
 	info.RepositoryRoot = root
 
 	errors := waitGroup(
-		func() error {
-			return probe.extractStatus(path, &info)
-		},
+		fields.when(FieldStatus|FieldUntracked, func() error {
+			return probe.extractStatus(ctx, path, &info)
+		}),
+
+		fields.when(FieldRevision|FieldBranch|FieldRemote|FieldCommit, func() error {
+			return probe.extractInfo(ctx, path, &info)
+		}),
 
-		func() error {
-			return probe.extractInfo(path, &info)
-		},
+		fields.when(FieldCommit, func() error {
+			if !probe.AskServer {
+				return nil
+			}
+			return probe.extractSubject(ctx, path, &info)
+		}),
 	)
 
 	return info, errors
 }
+
+// svnFileStates maps the item codes used by "svn status" to FileStates.
+var svnFileStates = map[string]FileState{
+	"M": FileModified,
+	"R": FileModified,
+	"~": FileModified,
+	"A": FileAdded,
+	"D": FileDeleted,
+	"!": FileMissing,
+	"?": FileUntracked,
+}
+
+// FileStatus returns the status of each file in the SVN working copy at the
+// specified path that differs from the current revision.
+func (probe SvnProbe) FileStatus(path string) ([]FileStatus, error) {
+	return probe.FileStatusContext(context.Background(), path)
+}
+
+// FileStatusContext returns the status of each file in the SVN working copy at
+// the specified path that differs from the current revision, abandoning the
+// work when the context expires.
+func (probe SvnProbe) FileStatusContext(ctx context.Context, path string) ([]FileStatus, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := runCommand(ctx, root, "svn", "status")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []FileStatus{}
+	for _, line := range out {
+		if strings.HasPrefix(line, "Summary of conflicts:") {
+			// Everything from here on is just a recap of what came before.
+			break
+		}
+		if strings.HasPrefix(line, "        > moved from ") {
+			// This is the source of the move listed before it.
+			if len(files) > 0 {
+				files[len(files)-1].Worktree = FileRenamed
+				files[len(files)-1].OriginalPath = filepath.ToSlash(line[21:])
+			}
+			continue
+		}
+		if len(line) < 9 {
+			continue
+		}
+
+		item, props, tree := line[0:1], line[1:2], line[6:7]
+
+		var state FileState
+		if item == "C" || props == "C" || tree == "C" {
+			state = FileConflicted
+		} else if itemState, ok := svnFileStates[item]; ok {
+			state = itemState
+		} else if props == "M" {
+			state = FileModified
+		} else {
+			continue
+		}
+
+		files = append(files, worktreeStatus(line[8:], state))
+	}
+
+	return files, nil
+}
+
+// DiffStat summarizes the differences between the SVN working copy at the
+// specified path and the current revision.
+func (probe SvnProbe) DiffStat(path string) (DiffStat, error) {
+	return probe.DiffStatContext(context.Background(), path)
+}
+
+// DiffStatContext summarizes the differences between the SVN working copy at
+// the specified path and the current revision, abandoning the work when the
+// context expires.
+func (probe SvnProbe) DiffStatContext(ctx context.Context, path string) (DiffStat, error) {
+	root, err := requireRepositoryRoot(probe, path)
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	out, err := runCommand(ctx, root, "svn", "diff")
+	if err != nil {
+		return DiffStat{}, err
+	}
+
+	return parseUnifiedDiff(out), nil
+}
@@ -1,6 +1,9 @@
 package vcsinfo_test
 
 import (
+	"context"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gstruct"
@@ -168,6 +171,28 @@ var _ = Describe("Subversion", func() {
 			}))
 		})
 
+		It("sees conflicts", func() {
+			run(dir, "svn", "checkout", repoUrl+"/trunk", ".")
+			writeFile(dir, "foo", "bar")
+			run(dir, "svn", "add", "foo")
+			run(dir, "svn", "commit", "-m", "blah")
+
+			other := tmpdir()
+			defer rmdir(other)
+			run(other, "svn", "checkout", repoUrl+"/trunk", ".")
+			writeFile(other, "foo", "baz")
+			run(other, "svn", "commit", "-m", "other")
+
+			writeFile(dir, "foo", "qux")
+			runIgnoringFailure(dir, "svn", "update", "--non-interactive", "--accept", "postpone")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"HasConflicts": BeTrue(),
+			}))
+		})
+
 		It("sees branches", func() {
 			run(dir, "svn", "checkout", repoUrl+"/branches/mybranch", ".")
 			info, err := probe.GatherInfo(dir)
@@ -179,10 +204,129 @@ var _ = Describe("Subversion", func() {
 			}))
 		})
 
+		It("sees remotes", func() {
+			run(dir, "svn", "checkout", repoUrl+"/trunk", ".")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"RemoteName": Equal(""),
+				"RemoteURL":  Equal(repoUrl + "/trunk"),
+				"RemoteHost": Equal(""),
+			}))
+		})
+
+		It("sees commit metadata", func() {
+			run(dir, "svn", "checkout", repoUrl+"/trunk", ".")
+			info, err := SvnProbe{AskServer: true}.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"CommitAuthor":  Not(BeEmpty()),
+				"CommitTime":    BeTemporally("~", time.Now(), time.Minute),
+				"CommitSubject": Equal("dirs"),
+			}))
+		})
+
+		It("only asks the server for the subject when told to", func() {
+			run(dir, "svn", "checkout", repoUrl+"/trunk", ".")
+			info, err := probe.GatherInfo(dir)
+			Expect(err).To(BeEmpty())
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"CommitAuthor":  Not(BeEmpty()),
+				"CommitTime":    BeTemporally("~", time.Now(), time.Minute),
+				"CommitSubject": BeEmpty(),
+			}))
+		})
+
+		It("returns partial info when the context is cancelled", func() {
+			run(dir, "svn", "checkout", repoUrl+"/trunk", ".")
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			info, err := probe.GatherInfoContext(ctx, dir)
+			Expect(err).To(ContainElement(context.Canceled))
+
+			Expect(info).To(MatchFields(IgnoreExtras, Fields{
+				"VcsName":        Equal("svn"),
+				"Path":           Equal(dir),
+				"RepositoryRoot": Equal(dir),
+			}))
+		})
+
 		It("doesnt crash when in VCS special dir", func() {
 			run(dir, "svn", "checkout", repoUrl+"/trunk", ".")
 			_, err := probe.GatherInfo(dir + "/.svn")
 			Expect(err).To(BeEmpty())
 		})
 	})
+
+	Describe("FileStatus", func() {
+		var dir, repoDir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			repoDir = tmpdir()
+			run(repoDir, "svnadmin", "create", "TestRepo")
+			run(dir, "svn", "checkout", "file://"+repoDir+"/TestRepo", ".")
+			writeFile(dir, "foo", "bar")
+			run(dir, "svn", "add", "foo")
+			run(dir, "svn", "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+			rmdir(repoDir)
+			repoDir = ""
+		})
+
+		It("sees changed files", func() {
+			writeFile(dir, "foo", "changed")
+			writeFile(dir, "added", "file")
+			run(dir, "svn", "add", "added")
+			writeFile(dir, "new", "file")
+			files, err := probe.FileStatus(dir)
+			Expect(err).To(BeNil())
+
+			Expect(files).To(ConsistOf(
+				FileStatus{Path: "foo", Index: FileUnmodified, Worktree: FileModified},
+				FileStatus{Path: "added", Index: FileUnmodified, Worktree: FileAdded},
+				FileStatus{Path: "new", Index: FileUnmodified, Worktree: FileUntracked},
+			))
+		})
+	})
+
+	Describe("DiffStat", func() {
+		var dir, repoDir string
+
+		BeforeEach(func() {
+			dir = tmpdir()
+			repoDir = tmpdir()
+			run(repoDir, "svnadmin", "create", "TestRepo")
+			run(dir, "svn", "checkout", "file://"+repoDir+"/TestRepo", ".")
+			writeFile(dir, "foo", "one\ntwo\nthree\n")
+			run(dir, "svn", "add", "foo")
+			run(dir, "svn", "commit", "-m", "first")
+		})
+
+		AfterEach(func() {
+			rmdir(dir)
+			dir = ""
+			rmdir(repoDir)
+			repoDir = ""
+		})
+
+		It("counts changed files and lines", func() {
+			writeFile(dir, "foo", "one\nTWO\nthree\nfour\n")
+			stat, err := probe.DiffStat(dir)
+			Expect(err).To(BeNil())
+
+			Expect(stat).To(Equal(DiffStat{
+				FilesChanged: 1,
+				Insertions:   2,
+				Deletions:    1,
+			}))
+		})
+	})
 })
@@ -0,0 +1,109 @@
+package vcsinfo
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+func templateTruncate(length int, value string) string {
+	runes := []rune(value)
+	if length < 0 || len(runes) <= length {
+		return value
+	}
+	return string(runes[:length])
+}
+
+func templateDefault(fallback interface{}, value interface{}) interface{} {
+	if value == nil {
+		return fallback
+	}
+
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.Slice, reflect.Map:
+		if val.Len() == 0 {
+			return fallback
+		}
+	default:
+		if val.IsZero() {
+			return fallback
+		}
+	}
+
+	return value
+}
+
+func templatePad(width int, value string) string {
+	padding := width
+	if padding < 0 {
+		padding = -padding
+	}
+	padding -= utf8.RuneCountInString(value)
+	if padding <= 0 {
+		return value
+	}
+
+	if width < 0 {
+		return strings.Repeat(" ", padding) + value
+	}
+	return value + strings.Repeat(" ", padding)
+}
+
+func makeTemplateFuncs(info VcsInfo, options FormatOptions) template.FuncMap {
+	return template.FuncMap{
+		"truncate": templateTruncate,
+		"default":  templateDefault,
+		"pad":      templatePad,
+		"upper":    strings.ToUpper,
+		"color": func(spec string) (string, error) {
+			names := []string{}
+			for _, name := range strings.Split(spec, ",") {
+				name = strings.ToLower(strings.TrimSpace(name))
+				if _, ok := styles[name]; !ok {
+					return "", fmt.Errorf("unknown style %q", name)
+				}
+				names = append(names, name)
+			}
+			return renderStyles(names, options.ColorMode), nil
+		},
+		"relpath": func(target string) (string, error) {
+			return filepath.Rel(info.RepositoryRoot, target)
+		},
+	}
+}
+
+// InfoToTemplate renders the VcsInfo by executing the specified Go
+// text/template against it. In addition to the standard template functions,
+// the following are available:
+//
+//	truncate N S   S shortened to at most N characters
+//	default D V    V, or D if V is empty
+//	pad N S        S padded with spaces to N characters (right-aligned if N is
+//	               negative)
+//	upper S        S in upper case
+//	color SPEC     The sequence that applies the comma-separated styles in SPEC
+//	               (e.g., "bold,red"), as rendered in options.ColorMode
+//	relpath P      P relative to the repository root
+func InfoToTemplate(info VcsInfo, tmpl string, options FormatOptions) (string, error) {
+	if !isValidColorMode(options.ColorMode) {
+		return "", fmt.Errorf("unknown color mode %q", options.ColorMode)
+	}
+
+	parsed, err := template.New("vcsinfo").Funcs(makeTemplateFuncs(info, options)).Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = parsed.Execute(&buf, info)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -3,10 +3,13 @@ package vcsinfo
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 )
@@ -54,13 +57,21 @@ func getExitCode(err error) int {
 	return -1
 }
 
-func runCommand(workingDir string, command ...string) ([]string, error) {
+func runCommand(ctx context.Context, workingDir string, command ...string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
 	cmd := exec.Command(command[0], command[1:]...)
 	cmd.Dir = workingDir
-	out, err := cmd.CombinedOutput()
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := runProcess(ctx, cmd)
 
 	var lines []string
-	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner := bufio.NewScanner(&out)
 	for scanner.Scan() {
 		lines = append(lines, scanner.Text())
 	}
@@ -82,19 +93,102 @@ func runCommand(workingDir string, command ...string) ([]string, error) {
 	return lines, err
 }
 
+// runProcess runs the command to completion, killing it (and anything it
+// spawned) if the context expires first.
+func runProcess(ctx context.Context, cmd *exec.Cmd) error {
+	// Run the command in its own process group so that, if the context
+	// expires, anything it spawned is killed along with it.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		err = ctx.Err()
+	}
+	return err
+}
+
+// parseDescription splits a description of a changeset in the style of
+// "git describe --long" (TAG-DISTANCE-HASH) into its tag and distance.
+func parseDescription(description string) (string, int, bool) {
+	parts := strings.Split(description, "-")
+	if len(parts) < 3 {
+		return "", 0, false
+	}
+
+	distance, err := strconv.Atoi(parts[len(parts)-2])
+	if err != nil {
+		return "", 0, false
+	}
+
+	return strings.Join(parts[0:len(parts)-2], "-"), distance, true
+}
+
+// splitAuthor splits an author of the form "Name <email>" into the name and
+// email address. If there is no email address in angle brackets, but the whole
+// thing looks like one, it is used as both.
+func splitAuthor(author string) (string, string) {
+	author = strings.TrimSpace(author)
+
+	start := strings.LastIndex(author, "<")
+	end := strings.LastIndex(author, ">")
+	if start >= 0 && end > start {
+		name := strings.TrimSpace(author[:start])
+		email := author[start+1 : end]
+		if name == "" {
+			name = email
+		}
+		return name, email
+	}
+
+	if strings.Contains(author, "@") {
+		return author, author
+	}
+	return author, ""
+}
+
+// firstLine returns the first non-blank line of the text, trimmed.
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
 func waitGroup(routines ...func() error) []error {
 	waitGroup := sync.WaitGroup{}
 	waitGroup.Add(len(routines))
 
 	errors := []error{}
+	errorsLock := sync.Mutex{}
 
 	for idx := range routines {
 		routine := routines[idx]
+		if routine == nil {
+			waitGroup.Done()
+			continue
+		}
 		go func() {
 			defer waitGroup.Done()
 			err := routine()
 			if err != nil {
+				errorsLock.Lock()
 				errors = append(errors, err)
+				errorsLock.Unlock()
 			}
 		}()
 	}
@@ -123,3 +217,16 @@ func findAcceptablePath(path string, isAcceptable func(string) (bool, error)) (s
 
 	return "", nil
 }
+
+// requireRepositoryRoot returns the root of the repository the path is in,
+// failing if the probe doesn't recognize it.
+func requireRepositoryRoot(probe VcsProbe, path string) (string, error) {
+	root, err := findAcceptablePath(path, probe.IsRepositoryRoot)
+	if err != nil {
+		return "", err
+	}
+	if root == "" {
+		return "", fmt.Errorf("%s is not in a %s repository", path, probe.Name())
+	}
+	return root, nil
+}
@@ -48,3 +48,9 @@ func run(dir string, command ...string) {
 		Fail(fmt.Sprintf("Failed to execute %s %+v\n%s", command, err, out))
 	}
 }
+
+func runIgnoringFailure(dir string, command ...string) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = dir
+	cmd.CombinedOutput()
+}
@@ -0,0 +1,415 @@
+package vcsinfo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long a repository must go without changing before its
+// information is gathered again, so that bursts of changes (e.g., a checkout
+// of another branch) only produce a single update.
+const watchDebounce = 200 * time.Millisecond
+
+// WatchEvent describes the state of a repository being watched.
+type WatchEvent struct {
+	// The current information about the repository.
+	Info VcsInfo
+
+	// The names of the VcsInfo fields that have changed since the previous
+	// event. The first event lists all fields.
+	Changed []string
+
+	// Any problems encountered while gathering the information or watching
+	// the repository for changes.
+	Errors []error
+}
+
+// Watch monitors the repository the specified path is in, using the first of
+// the available probes that recognizes it. See WatchProbe.
+func Watch(ctx context.Context, path string) (<-chan WatchEvent, error) {
+	probes, err := GetAvailableProbes()
+	if err != nil {
+		return nil, err
+	}
+
+	probe, err := FindProbeForPath(path, probes)
+	if err != nil {
+		return nil, err
+	}
+	if probe == nil {
+		return nil, fmt.Errorf("%s is not in a repository", path)
+	}
+
+	return WatchProbe(ctx, probe, path)
+}
+
+// WatchProbe monitors the repository the specified path is in for changes
+// using the specified probe. An event containing the current information is
+// sent immediately, followed by another whenever a change to the working copy
+// or the VCS's metadata results in different information. The channel is
+// closed once the context is done.
+func WatchProbe(ctx context.Context, probe VcsProbe, path string) (<-chan WatchEvent, error) {
+	root, err := findAcceptablePath(path, probe.IsRepositoryRoot)
+	if err != nil {
+		return nil, err
+	}
+	if root == "" {
+		return nil, fmt.Errorf("%s is not in a %s repository", path, probe.Name())
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	err = WatchTree(watcher, root)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	marker, err := newGatherMarker(watcher)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	events := make(chan WatchEvent)
+	go runWatch(ctx, watcher, marker, probe, root, path, events)
+
+	return events, nil
+}
+
+// metadataWatches lists the directories VCSs keep their metadata in, along
+// with the directories within them that need to be watched (along with
+// everything beneath them) to notice changes. Only the files directly within
+// the rest of the metadata directory are watched (e.g., .git/HEAD and
+// .git/index), as the directories beneath can be huge (e.g., .git/objects).
+var metadataWatches = map[string][]string{
+	".git":   {"refs", "sl"},
+	".hg":    {"merge", "shelved"},
+	".sl":    {"merge", "shelved"},
+	".jj":    {"working_copy", filepath.Join("repo", "op_heads", "heads")},
+	".svn":   {},
+	".bzr":   {"branch", "checkout"},
+	"_darcs": {},
+	"CVS":    {},
+}
+
+// unwatchedDirs are the directories that aren't watched at all, as they can be
+// huge and are almost always ignored by the VCS (e.g., dependencies, virtual
+// environments and build output).
+var unwatchedDirs = map[string]bool{
+	"node_modules":     true,
+	"bower_components": true,
+	"target":           true,
+	"dist":             true,
+	".venv":            true,
+	"venv":             true,
+	"__pycache__":      true,
+	".tox":             true,
+	".mypy_cache":      true,
+	".pytest_cache":    true,
+	".gradle":          true,
+	".terraform":       true,
+	".next":            true,
+}
+
+// IsMetadataPath indicates whether or not the path is within one of the
+// directories that VCSs keep their metadata in (e.g., .git).
+func IsMetadataPath(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if _, ok := metadataWatches[part]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchedDirs returns the directories that need to be watched to notice
+// changes to the working copy or VCS metadata within the specified directory
+// (which is typically the root of a repository). Directories that can't be
+// read are left out, along with everything beneath them.
+func WatchedDirs(dir string) ([]string, error) {
+	dirs := []string{}
+
+	walk := func(dir string, skip func(path string, name string) bool) error {
+		return filepath.Walk(dir, func(path string, fileInfo os.FileInfo, err error) error {
+			if err != nil {
+				// The directory may have been removed since it was found.
+				if os.IsNotExist(err) {
+					return nil
+				}
+				if os.IsPermission(err) {
+					// The directory was already added before its contents
+					// turned out to be unreadable, and it can't be watched
+					// either.
+					if len(dirs) > 0 && dirs[len(dirs)-1] == path {
+						dirs = dirs[:len(dirs)-1]
+					}
+					return nil
+				}
+				return err
+			}
+			if !fileInfo.IsDir() {
+				return nil
+			}
+			if skip(path, fileInfo.Name()) {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, path)
+			return nil
+		})
+	}
+
+	everything := func(path string, name string) bool {
+		return false
+	}
+
+	var metadataErr error
+	err := walk(dir, func(path string, name string) bool {
+		if unwatchedDirs[name] {
+			return true
+		}
+
+		subdirs, ok := metadataWatches[name]
+		if !ok {
+			return false
+		}
+		dirs = append(dirs, path)
+		for _, subdir := range subdirs {
+			if err := walk(filepath.Join(path, subdir), everything); err != nil && metadataErr == nil {
+				metadataErr = err
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = metadataErr
+	}
+
+	return dirs, err
+}
+
+// WatchTree adds watches for the directory and the directories beneath it
+// that can change the information about the repository (see WatchedDirs). If
+// any of them can't be watched, the watches that were added are removed.
+func WatchTree(watcher *fsnotify.Watcher, dir string) error {
+	dirs, err := WatchedDirs(dir)
+	if err != nil {
+		return err
+	}
+
+	for idx, path := range dirs {
+		err = watcher.Add(path)
+		if err != nil {
+			for _, added := range dirs[:idx] {
+				watcher.Remove(added)
+			}
+			return watchError(path, err)
+		}
+	}
+	return nil
+}
+
+// watchError explains why the directory couldn't be watched. Running out of
+// inotify watches is reported as the device being out of space, which is
+// rather misleading.
+func watchError(path string, err error) error {
+	if err == syscall.ENOSPC {
+		return fmt.Errorf("could not watch %s: the limit on the number of inotify watches has been reached (see fs.inotify.max_user_watches)", path)
+	}
+	return fmt.Errorf("could not watch %s: %s", path, err)
+}
+
+// gatherMarker tells the changes the probe makes to the VCS metadata while
+// gathering information (e.g., Git refreshing its index) apart from the
+// changes made by others, so that the probe's own changes don't cause the
+// information to be gathered again, endlessly. After gathering, a file is
+// created and removed in a directory that is also being watched; as the events
+// are delivered in order, the changes to the metadata seen before that file's
+// removal were made while gathering.
+type gatherMarker struct {
+	dir   string
+	count int
+}
+
+func newGatherMarker(watcher *fsnotify.Watcher) (*gatherMarker, error) {
+	dir, err := os.MkdirTemp("", "vcsinfo-watch-")
+	if err != nil {
+		return nil, err
+	}
+
+	err = watcher.Add(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return &gatherMarker{dir: dir}, nil
+}
+
+func (marker *gatherMarker) path() string {
+	return filepath.Join(marker.dir, strconv.Itoa(marker.count))
+}
+
+// mark creates and removes the file that indicates that gathering is
+// finished.
+func (marker *gatherMarker) mark() error {
+	marker.count++
+
+	file, err := os.Create(marker.path())
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(marker.path())
+}
+
+// isLatestMark indicates whether or not the event is the removal of the file
+// that indicates that the most recent gathering is finished. (The creation of
+// the file isn't reported by fsnotify, as it's already gone by then.)
+func (marker *gatherMarker) isLatestMark(event fsnotify.Event) bool {
+	return event.Name == marker.path() && event.Op&fsnotify.Remove == fsnotify.Remove
+}
+
+// owns indicates whether or not the event is about the marker's directory.
+func (marker *gatherMarker) owns(event fsnotify.Event) bool {
+	return filepath.Dir(event.Name) == marker.dir || event.Name == marker.dir
+}
+
+func (marker *gatherMarker) Close() error {
+	return os.RemoveAll(marker.dir)
+}
+
+// diffInfo returns the names of the fields that differ between the VcsInfos.
+func diffInfo(previous VcsInfo, current VcsInfo) []string {
+	changed := []string{}
+
+	previousValue := reflect.ValueOf(previous)
+	currentValue := reflect.ValueOf(current)
+	for idx := 0; idx < currentValue.NumField(); idx++ {
+		if !reflect.DeepEqual(previousValue.Field(idx).Interface(), currentValue.Field(idx).Interface()) {
+			changed = append(changed, currentValue.Type().Field(idx).Name)
+		}
+	}
+
+	return changed
+}
+
+func allInfoFields() []string {
+	infoType := reflect.TypeOf(VcsInfo{})
+	fields := make([]string, infoType.NumField())
+	for idx := range fields {
+		fields[idx] = infoType.Field(idx).Name
+	}
+	return fields
+}
+
+func runWatch(ctx context.Context, watcher *fsnotify.Watcher, marker *gatherMarker, probe VcsProbe, root string, path string, events chan<- WatchEvent) {
+	defer close(events)
+	defer watcher.Close()
+	defer marker.Close()
+
+	send := func(event WatchEvent) bool {
+		select {
+		case events <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	// Whether or not the changes to the metadata are still the ones made
+	// while gathering.
+	settling := false
+	gather := func() (VcsInfo, []error) {
+		info, errs := probe.GatherInfoContext(ctx, path)
+		settling = marker.mark() == nil
+		return info, errs
+	}
+
+	previous, errs := gather()
+	if !send(WatchEvent{Info: previous, Changed: allInfoFields(), Errors: errs}) {
+		return
+	}
+
+	var debounce <-chan time.Time
+	pendingErrs := []error{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			if marker.owns(event) {
+				if marker.isLatestMark(event) {
+					settling = false
+				}
+				continue
+			}
+
+			// Lock files come and go while the VCS tools read the
+			// repository, so they don't indicate an actual change.
+			if strings.HasSuffix(event.Name, ".lock") {
+				continue
+			}
+
+			if settling && IsMetadataPath(strings.TrimPrefix(event.Name, root)) {
+				continue
+			}
+
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				if isDir, _ := dirExists(event.Name); isDir {
+					err := WatchTree(watcher, event.Name)
+					if err != nil {
+						pendingErrs = append(pendingErrs, err)
+					}
+				}
+			}
+
+			debounce = time.After(watchDebounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			pendingErrs = append(pendingErrs, err)
+			debounce = time.After(watchDebounce)
+
+		case <-debounce:
+			debounce = nil
+
+			current, errs := gather()
+			if ctx.Err() != nil {
+				return
+			}
+			errs = append(pendingErrs, errs...)
+			pendingErrs = []error{}
+
+			changed := diffInfo(previous, current)
+			if len(changed) == 0 && len(errs) == 0 {
+				continue
+			}
+			previous = current
+
+			if !send(WatchEvent{Info: current, Changed: changed, Errors: errs}) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package vcsinfo_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+
+	. "github.com/jayclassless/vcsinfo"
+)
+
+// countingProbe counts how many times it gathers information.
+type countingProbe struct {
+	GitProbe
+	count *int32
+}
+
+func (probe countingProbe) GatherInfoContext(ctx context.Context, path string) (VcsInfo, []error) {
+	atomic.AddInt32(probe.count, 1)
+	return probe.GitProbe.GatherInfoContext(ctx, path)
+}
+
+var _ = Describe("Watch", func() {
+	probe := GitProbe{}
+
+	var dir string
+	var ctx context.Context
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		dir = tmpdir()
+		run(dir, "git", "init")
+		writeFile(dir, "foo", "bar")
+		run(dir, "git", "add", "foo")
+		run(dir, "git", "commit", "-m", "first")
+		ctx, cancel = context.WithCancel(context.Background())
+	})
+
+	AfterEach(func() {
+		cancel()
+		rmdir(dir)
+		dir = ""
+	})
+
+	It("sends the current information immediately", func() {
+		events, err := WatchProbe(ctx, probe, dir)
+		Expect(err).To(BeNil())
+
+		var event WatchEvent
+		Eventually(events, 5*time.Second).Should(Receive(&event))
+		Expect(event.Errors).To(BeEmpty())
+		Expect(event.Changed).To(ContainElements("VcsName", "Branch", "HasNew"))
+		Expect(event.Info).To(MatchFields(IgnoreExtras, Fields{
+			"VcsName":        Equal("git"),
+			"RepositoryRoot": Equal(dir),
+			"Branch":         Equal("master"),
+			"HasNew":         BeFalse(),
+		}))
+	})
+
+	It("sends the changes", func() {
+		events, err := WatchProbe(ctx, probe, dir)
+		Expect(err).To(BeNil())
+		Eventually(events, 5*time.Second).Should(Receive())
+
+		writeFile(dir, "new", "file")
+
+		var event WatchEvent
+		Eventually(events, 5*time.Second).Should(Receive(&event))
+		Expect(event.Changed).To(Equal([]string{"HasNew", "UntrackedCount"}))
+		Expect(event.Info.HasNew).To(BeTrue())
+
+		run(dir, "git", "checkout", "-b", "other")
+
+		Eventually(events, 5*time.Second).Should(Receive(&event))
+		Expect(event.Changed).To(Equal([]string{"Branch"}))
+		Expect(event.Info.Branch).To(Equal("other"))
+	})
+
+	It("sends a single event for bursts of changes", func() {
+		events, err := WatchProbe(ctx, probe, dir)
+		Expect(err).To(BeNil())
+		Eventually(events, 5*time.Second).Should(Receive())
+
+		for idx := 0; idx < 10; idx++ {
+			writeFile(dir, fmt.Sprintf("new%d", idx), "file")
+			writeFile(dir, "foo", fmt.Sprintf("changed%d", idx))
+		}
+
+		var event WatchEvent
+		Eventually(events, 5*time.Second).Should(Receive(&event))
+		Expect(event.Changed).To(ConsistOf("HasModified", "HasNew", "ModifiedCount", "UntrackedCount"))
+		Consistently(events, time.Second).ShouldNot(Receive())
+	})
+
+	It("watches new directories", func() {
+		events, err := WatchProbe(ctx, probe, dir)
+		Expect(err).To(BeNil())
+		Eventually(events, 5*time.Second).Should(Receive())
+
+		sub := mkdir(dir, "sub")
+		var event WatchEvent
+		Consistently(events, time.Second).ShouldNot(Receive())
+
+		writeFile(sub, "new", "file")
+		Eventually(events, 5*time.Second).Should(Receive(&event))
+		Expect(event.Info.HasNew).To(BeTrue())
+	})
+
+	It("ignores the changes it makes to the metadata itself", func() {
+		// Make the index look stale, so that git rewrites it when the status
+		// is retrieved.
+		writeFile(dir, "foo", "baz")
+		writeFile(dir, "foo", "bar")
+
+		count := int32(0)
+		events, err := WatchProbe(ctx, countingProbe{count: &count}, dir)
+		Expect(err).To(BeNil())
+		Eventually(events, 5*time.Second).Should(Receive())
+
+		Consistently(func() int32 { return atomic.LoadInt32(&count) }, time.Second).Should(Equal(int32(1)))
+
+		run(dir, "git", "checkout", "-b", "other")
+		var event WatchEvent
+		Eventually(events, 5*time.Second).Should(Receive(&event))
+		Expect(event.Info.Branch).To(Equal("other"))
+	})
+
+	It("closes the channel when the context is done", func() {
+		events, err := WatchProbe(ctx, probe, dir)
+		Expect(err).To(BeNil())
+		Eventually(events, 5*time.Second).Should(Receive())
+
+		cancel()
+		Eventually(events, 5*time.Second).Should(BeClosed())
+	})
+
+	It("finds the probe", func() {
+		events, err := Watch(ctx, dir)
+		Expect(err).To(BeNil())
+
+		var event WatchEvent
+		Eventually(events, 5*time.Second).Should(Receive(&event))
+		Expect(event.Info.VcsName).To(Equal("git"))
+	})
+
+	It("fails outside of a repository", func() {
+		other := tmpdir()
+		defer rmdir(other)
+
+		_, err := WatchProbe(ctx, probe, other)
+		Expect(err).To(MatchError(fmt.Sprintf("%s is not in a git repository", other)))
+	})
+	Describe("WatchedDirs", func() {
+		It("skips the bulk of the metadata and ignored dependencies", func() {
+			mkdir(dir, "sub", "deeper")
+			mkdir(dir, "node_modules", "something")
+			mkdir(dir, "target", "debug")
+			mkdir(dir, ".venv", "lib")
+
+			dirs, err := WatchedDirs(dir)
+			Expect(err).To(BeNil())
+			Expect(dirs).To(ContainElements(
+				dir,
+				filepath.Join(dir, "sub"),
+				filepath.Join(dir, "sub", "deeper"),
+				filepath.Join(dir, ".git"),
+				filepath.Join(dir, ".git", "refs"),
+				filepath.Join(dir, ".git", "refs", "heads"),
+			))
+			Expect(dirs).NotTo(ContainElement(filepath.Join(dir, ".git", "objects")))
+			Expect(dirs).NotTo(ContainElement(filepath.Join(dir, "node_modules")))
+			Expect(dirs).NotTo(ContainElement(filepath.Join(dir, "node_modules", "something")))
+			Expect(dirs).NotTo(ContainElement(filepath.Join(dir, "target")))
+			Expect(dirs).NotTo(ContainElement(filepath.Join(dir, "target", "debug")))
+			Expect(dirs).NotTo(ContainElement(filepath.Join(dir, ".venv")))
+		})
+
+		It("skips directories that can't be read", func() {
+			if os.Geteuid() == 0 {
+				Skip("permissions aren't enforced for root")
+			}
+
+			mkdir(dir, "sub", "deeper")
+			mkdir(dir, "secret", "deeper")
+			os.Chmod(filepath.Join(dir, "secret"), 0)
+			defer os.Chmod(filepath.Join(dir, "secret"), 0755)
+
+			dirs, err := WatchedDirs(dir)
+			Expect(err).To(BeNil())
+			Expect(dirs).To(ContainElements(
+				dir,
+				filepath.Join(dir, "sub"),
+				filepath.Join(dir, "sub", "deeper"),
+			))
+			Expect(dirs).NotTo(ContainElement(filepath.Join(dir, "secret")))
+			Expect(dirs).NotTo(ContainElement(filepath.Join(dir, "secret", "deeper")))
+		})
+	})
+
+	Describe("IsMetadataPath", func() {
+		It("works", func() {
+			Expect(IsMetadataPath(filepath.Join(dir, ".git", "index"))).To(BeTrue())
+			Expect(IsMetadataPath(filepath.Join(dir, ".jj", "repo", "op_heads", "heads", "abc"))).To(BeTrue())
+			Expect(IsMetadataPath(filepath.Join(dir, "foo"))).To(BeFalse())
+			Expect(IsMetadataPath(filepath.Join(dir, ".gitignore"))).To(BeFalse())
+		})
+	})
+})